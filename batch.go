@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+// batchResult is the line written for each image of a batch with the json
+// format. Only the reference and error are set for an image that failed.
+type batchResult struct {
+	Reference string `json:"reference"`
+	Error     string `json:"error,omitempty"`
+	*jsonResult
+}
+
+// readRefs returns the container references in r, one per line. Blank lines
+// and lines starting with # are skipped.
+func readRefs(r io.Reader) ([]string, error) {
+	var refs []string
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		refs = append(refs, line)
+	}
+	return refs, s.Err()
+}
+
+// scanBatch scans each of refs with scanImage, writing the result of each to w
+// as a line of JSON as soon as it completes if asJSON is set, and logging it
+// otherwise. An image that fails is recorded with its error rather than
+// stopping the batch, unless its scan was canceled, as by an interrupt, in
+// which case the remaining images are not scanned. It returns whether the
+// report of any image is failing, and the error of the first image that
+// failed, if any.
+func scanBatch(w io.Writer, logger scan.Logger, refs []string, asJSON bool, failing func(*scan.Report) bool, scanImage func(ref string) (*scan.Report, error)) (bool, error) {
+	var firstErr error
+	var failed bool
+	enc := json.NewEncoder(w)
+	for _, ref := range refs {
+		logger.Log("Container under test:", ref)
+		report, err := scanImage(ref)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ERR:", ref+":", err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			if asJSON {
+				checkOutput(enc.Encode(batchResult{Reference: ref, Error: err.Error()}), "writing json")
+			}
+			if errors.Is(err, context.Canceled) {
+				return failed, err
+			}
+			continue
+		}
+		failed = failed || failing(report)
+		if len(report.DisallowedModifications) > 0 {
+			logger.Log("Disallowed modifications by layer")
+			logLayerModifications(logger, report)
+		}
+		if len(report.SuspiciousEntries) > 0 {
+			logger.Log("Suspicious entries with a parent directory element")
+			logSuspiciousEntries(logger, report)
+		}
+		logger.Log(summaryLine(report.Summary()))
+		if asJSON {
+			result := newJSONResult(ref, report, nil)
+			checkOutput(enc.Encode(batchResult{Reference: ref, jsonResult: &result}), "writing json")
+		}
+	}
+	return failed, firstErr
+}
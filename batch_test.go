@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestReadRefs(t *testing.T) {
+	refs, err := readRefs(strings.NewReader("quay.io/a/b:1\n\n# comment\n  quay.io/a/c:2  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"quay.io/a/b:1", "quay.io/a/c:2"}
+	if !reflect.DeepEqual(refs, want) {
+		t.Fatalf("want=%v, got=%v", want, refs)
+	}
+}
+
+func TestScanBatch(t *testing.T) {
+	reports := map[string]*scan.Report{
+		"clean": {LayerCount: 2},
+		"dirty": {
+			LayerCount: 2,
+			Layers: []scan.LayerResult{{
+				Digest:     "sha256:abc",
+				Disallowed: []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+			}},
+			DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:abc"}},
+		},
+	}
+	errPull := errors.New("pull failed")
+	var buf bytes.Buffer
+	failing := func(report *scan.Report) bool { return len(report.DisallowedModifications) > 0 }
+	failed, err := scanBatch(&buf, scan.NewTextLogger(io.Discard), []string{"clean", "missing", "dirty"}, true, failing, func(ref string) (*scan.Report, error) {
+		if report, ok := reports[ref]; ok {
+			return report, nil
+		}
+		return nil, errPull
+	})
+	if !failed {
+		t.Fatalf("want=%v, got=%v", true, failed)
+	}
+	if err != errPull {
+		t.Fatalf("want=%v, got=%v", errPull, err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("want=%v, got=%v", 3, len(lines))
+	}
+	type result struct {
+		Reference               string              `json:"reference"`
+		Error                   string              `json:"error"`
+		DisallowedModifications []scan.Modification `json:"disallowedModifications"`
+	}
+	var got []result
+	for _, line := range lines {
+		var r result
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, r)
+	}
+	if got[0].Reference != "clean" || got[0].Error != "" || len(got[0].DisallowedModifications) != 0 {
+		t.Fatalf("want=%v, got=%+v", "clean with no modifications", got[0])
+	}
+	if got[1].Reference != "missing" || got[1].Error != errPull.Error() {
+		t.Fatalf("want=%v, got=%+v", "missing with its error", got[1])
+	}
+	if got[2].Reference != "dirty" || len(got[2].DisallowedModifications) != 1 || got[2].DisallowedModifications[0].File != "usr/bin/foo" {
+		t.Fatalf("want=%v, got=%+v", "dirty with its modification", got[2])
+	}
+}
+
+func TestScanBatchCanceled(t *testing.T) {
+	var scanned []string
+	canceled := fmt.Errorf("reading layer: %w", context.Canceled)
+	_, err := scanBatch(io.Discard, scan.NewTextLogger(io.Discard), []string{"first", "second"}, false, func(*scan.Report) bool { return false }, func(ref string) (*scan.Report, error) {
+		scanned = append(scanned, ref)
+		return nil, canceled
+	})
+	if err != canceled {
+		t.Fatalf("want=%v, got=%v", canceled, err)
+	}
+	if !reflect.DeepEqual(scanned, []string{"first"}) {
+		t.Fatalf("want=%v, got=%v", []string{"first"}, scanned)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+const (
+	cyclonedxSpecVersion = "1.5"
+	// cyclonedxModifiedProperty names the property recording each file of a
+	// component that was modified.
+	cyclonedxModifiedProperty = "hasmodifiedfiles:disallowedModification"
+	// cyclonedxHistoryProperty names the property recording the layers that
+	// modified a file of a component, when more than one did.
+	cyclonedxHistoryProperty = "hasmodifiedfiles:modificationHistory"
+)
+
+// The types below model the subset of CycloneDX 1.5 used by the cyclonedx
+// format. https://cyclonedx.org/docs/1.5/json/
+
+type cyclonedxBOM struct {
+	BOMFormat   string               `json:"bomFormat"`
+	SpecVersion string               `json:"specVersion"`
+	Version     int                  `json:"version"`
+	Metadata    cyclonedxMetadata    `json:"metadata"`
+	Components  []cyclonedxComponent `json:"components"`
+}
+
+type cyclonedxMetadata struct {
+	Tools     []cyclonedxTool    `json:"tools"`
+	Component cyclonedxComponent `json:"component"`
+}
+
+type cyclonedxTool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type cyclonedxComponent struct {
+	Type       string              `json:"type"`
+	BOMRef     string              `json:"bom-ref"`
+	Name       string              `json:"name"`
+	Version    string              `json:"version,omitempty"`
+	PURL       string              `json:"purl,omitempty"`
+	Properties []cyclonedxProperty `json:"properties,omitempty"`
+}
+
+type cyclonedxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// purlTypes maps each package manager to the package URL type of its packages.
+var purlTypes = map[string]string{
+	scan.PackageManagerRPM:  "rpm",
+	scan.PackageManagerDpkg: "deb",
+	scan.PackageManagerApk:  "apk",
+}
+
+// writeCycloneDX writes the result of scanning ref to w as a CycloneDX BOM
+// listing a component for each package that owns a file with a disallowed
+// modification, identified by its package URL so that it can be correlated
+// with the same component in an existing SBOM of the image. The files of each
+// are recorded as its properties, along with the layers that modified each file
+// that more than one layer did. namespace is the package URL namespace, such
+// as redhat, debian, or alpine, which is omitted if it is empty.
+func writeCycloneDX(w io.Writer, ref string, report *scan.Report, namespace string) error {
+	components := map[string]*cyclonedxComponent{}
+	for _, mod := range report.Modifications() {
+		component, found := components[mod.Package]
+		if !found {
+			name, version := splitPackage(report.PackageManager, mod.Package)
+			purl := packageURL(purlTypes[report.PackageManager], namespace, name, version)
+			component = &cyclonedxComponent{Type: "library", BOMRef: purl, Name: name, Version: version, PURL: purl}
+			components[mod.Package] = component
+		}
+		component.Properties = append(component.Properties, cyclonedxProperty{Name: cyclonedxModifiedProperty, Value: mod.File})
+		if len(mod.Layers) > 1 {
+			component.Properties = append(component.Properties, cyclonedxProperty{Name: cyclonedxHistoryProperty, Value: mod.File + ": " + strings.Join(mod.Layers, ", ")})
+		}
+	}
+
+	packages := make([]string, 0, len(components))
+	for pkg := range components {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	bom := cyclonedxBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: cyclonedxSpecVersion,
+		Version:     1,
+		Metadata: cyclonedxMetadata{
+			Tools:     []cyclonedxTool{{Name: "hasmodifiedfiles", Version: currentBuild().Version}},
+			Component: cyclonedxComponent{Type: "container", BOMRef: ref, Name: ref, Version: report.ImageDigest},
+		},
+		Components: make([]cyclonedxComponent, 0, len(packages)),
+	}
+	for _, pkg := range packages {
+		bom.Components = append(bom.Components, *components[pkg])
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(bom)
+}
+
+// splitPackage splits pkg, a package as recorded in the filemap by manager,
+// into its name and version. The version of an RPM or apk package is its
+// last two dash separated fields, the version and release, as names may
+// contain dashes. That of a dpkg package follows the underscore separating it
+// from the name, which can appear in neither.
+func splitPackage(manager, pkg string) (name, version string) {
+	if manager == scan.PackageManagerDpkg {
+		name, version, _ = strings.Cut(pkg, "_")
+		return name, version
+	}
+	i := strings.LastIndex(pkg, "-")
+	if i < 0 {
+		return pkg, ""
+	}
+	if j := strings.LastIndex(pkg[:i], "-"); j >= 0 {
+		i = j
+	}
+	return pkg[:i], pkg[i+1:]
+}
+
+// purlEscaper percent-encodes the characters that url.PathEscape leaves alone
+// but that have a meaning in a package URL.
+var purlEscaper = strings.NewReplacer("+", "%2B", ":", "%3A", "@", "%40")
+
+// packageURL returns the package URL of the package name at version, of the
+// purl type typ and, if it is not empty, in namespace.
+// https://github.com/package-url/purl-spec
+func packageURL(typ, namespace, name, version string) string {
+	escape := func(s string) string { return purlEscaper.Replace(url.PathEscape(s)) }
+	purl := "pkg:" + typ + "/"
+	if namespace != "" {
+		purl += escape(namespace) + "/"
+	}
+	purl += escape(name)
+	if version != "" {
+		purl += "@" + escape(version)
+	}
+	return purl
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestWriteCycloneDX(t *testing.T) {
+	report := &scan.Report{
+		ImageDigest:    "sha256:def",
+		PackageManager: scan.PackageManagerRPM,
+		DisallowedModifications: map[string][]string{
+			"usr/bin/foo":     {"sha256:abc"},
+			"usr/bin/foo-cfg": {"sha256:abc"},
+			"usr/lib/libc.so": {"sha256:abc"},
+		},
+		FileMap: map[string]string{
+			"usr/bin/foo":     "foo-tools-1.0-1.el9",
+			"usr/bin/foo-cfg": "foo-tools-1.0-1.el9",
+			"usr/lib/libc.so": "glibc-2.34-60.el9",
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := writeCycloneDX(&buf, "quay.io/example/image:latest", report, "redhat"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var bom cyclonedxBOM
+	if err := json.Unmarshal(buf.Bytes(), &bom); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if bom.BOMFormat != "CycloneDX" || bom.Metadata.Component.Version != "sha256:def" || len(bom.Components) != 2 {
+		t.Fatalf("unexpected bom: %s", buf.String())
+	}
+	foo := bom.Components[0]
+	if foo.PURL != "pkg:rpm/redhat/foo-tools@1.0-1.el9" || foo.BOMRef != foo.PURL || foo.Name != "foo-tools" {
+		t.Fatalf("want=%s, got=%+v", "pkg:rpm/redhat/foo-tools@1.0-1.el9", foo)
+	}
+	if len(foo.Properties) != 2 || foo.Properties[0].Value != "usr/bin/foo" || foo.Properties[1].Value != "usr/bin/foo-cfg" {
+		t.Fatalf("want=%v, got=%v", []string{"usr/bin/foo", "usr/bin/foo-cfg"}, foo.Properties)
+	}
+	if purl := bom.Components[1].PURL; purl != "pkg:rpm/redhat/glibc@2.34-60.el9" {
+		t.Fatalf("want=%s, got=%s", "pkg:rpm/redhat/glibc@2.34-60.el9", purl)
+	}
+}
+
+func TestPackageURL(t *testing.T) {
+	for _, tc := range []struct {
+		manager, pkg, namespace, expected string
+	}{
+		{scan.PackageManagerRPM, "bash-4.4-19.6.1", "", "pkg:rpm/bash@4.4-19.6.1"},
+		{scan.PackageManagerRPM, "libstdc++-11.3.1-4.el9", "redhat", "pkg:rpm/redhat/libstdc%2B%2B@11.3.1-4.el9"},
+		{scan.PackageManagerApk, "musl-1.2.4-r2", "alpine", "pkg:apk/alpine/musl@1.2.4-r2"},
+		{scan.PackageManagerDpkg, "libc-bin_1:2.36-9", "debian", "pkg:deb/debian/libc-bin@1%3A2.36-9"},
+		{scan.PackageManagerDpkg, "gcc-12-base_12.2.0-14", "debian", "pkg:deb/debian/gcc-12-base@12.2.0-14"},
+		{scan.PackageManagerDpkg, "libstdc++-12-dev_12.2.0-14", "debian", "pkg:deb/debian/libstdc%2B%2B-12-dev@12.2.0-14"},
+	} {
+		name, version := splitPackage(tc.manager, tc.pkg)
+		if actual := packageURL(purlTypes[tc.manager], tc.namespace, name, version); actual != tc.expected {
+			t.Fatalf("want=%s, got=%s", tc.expected, actual)
+		}
+	}
+}
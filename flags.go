@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// envPrefix prefixes the environment variables that configure the tool.
+const envPrefix = "HMF_"
+
+// envReference is the environment variable giving the container reference
+// when none is given as an argument.
+const envReference = envPrefix + "REFERENCE"
+
+// stringsFlag is a flag.Value that collects every occurrence of a repeatable
+// flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// flagEnvName returns the environment variable that sets the flag name, which
+// is the name in upper case with dashes replaced by underscores, prefixed with
+// envPrefix. E.g. HMF_FAIL_ON for -fail-on.
+func flagEnvName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// setFlagsFromEnv sets each flag of fs that was not given on the command line
+// from its environment variable, as returned by lookup, if it is set, so that
+// the flags take precedence. A repeatable flag is set once for each non-empty
+// line of its variable. -version is never set from the environment.
+func setFlagsFromEnv(fs *flag.FlagSet, lookup func(string) (string, bool)) error {
+	given := map[string]struct{}{}
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = struct{}{}
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, found := given[f.Name]; found || f.Name == "version" || err != nil {
+			return
+		}
+		value, found := lookup(flagEnvName(f.Name))
+		if !found {
+			return
+		}
+		values := []string{value}
+		if _, repeatable := f.Value.(*stringsFlag); repeatable {
+			values = nil
+			for _, line := range strings.Split(value, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					values = append(values, line)
+				}
+			}
+		}
+		for _, v := range values {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid value %q for %s: %v", v, flagEnvName(f.Name), setErr)
+				return
+			}
+		}
+	})
+	return err
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string          `xml:"name,attr"`
+	Tests      int             `xml:"tests,attr"`
+	Failures   int             `xml:"failures,attr"`
+	Properties []junitProperty `xml:"properties>property"`
+	Cases      []junitTestCase `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the result of scanning ref to w as a JUnit XML test suite
+// with a test case for each layer that was checked. A case fails when its
+// layer made a disallowed modification, including to a file that a later
+// layer modified again.
+func writeJUnit(w io.Writer, ref string, report *scan.Report) error {
+	modified := map[string][]string{}
+	for _, layer := range report.Layers {
+		for _, change := range layer.Disallowed {
+			modified[layer.Digest] = append(modified[layer.Digest], fmt.Sprintf("%s (%s) was %s", change.Path, report.FileMap[change.Path], describeKind(change.Kind)))
+		}
+	}
+
+	suite := junitTestSuite{
+		Name:       ref,
+		Properties: []junitProperty{{Name: "imageDigest", Value: report.ImageDigest}},
+		Cases:      []junitTestCase{},
+	}
+	for _, layer := range report.Layers {
+		c := junitTestCase{Name: layer.Digest, Classname: "hasmodifiedfiles"}
+		if files := modified[layer.Digest]; len(files) > 0 {
+			sort.Strings(files)
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("layer made %d disallowed modification(s)", len(files)),
+				Type:    "DisallowedFileModification",
+				Text:    strings.Join(files, "\n"),
+			}
+			suite.Failures++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Tests = len(suite.Cases)
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "    ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
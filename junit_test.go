@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestWriteJUnit(t *testing.T) {
+	report := &scan.Report{
+		ImageDigest: "sha256:image",
+		FileMap:     map[string]string{"usr/bin/foo": "foo-1.0-1"},
+		Layers: []scan.LayerResult{
+			{Digest: "sha256:clean", Changes: []scan.Change{{Path: "opt/app", Kind: scan.ChangeAdded}}},
+			{
+				Digest:     "sha256:dirty",
+				Changes:    []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+				Disallowed: []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+			},
+			{
+				Digest:     "sha256:dirtier",
+				Changes:    []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeDeleted}},
+				Disallowed: []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeDeleted}},
+			},
+		},
+		DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:dirty", "sha256:dirtier"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, "quay.io/example/image:latest", report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var suites junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatalf("invalid xml: %v", err)
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 3 || suite.Failures != 2 {
+		t.Fatalf("want 3 tests and 2 failures, got %d tests and %d failures", suite.Tests, suite.Failures)
+	}
+	if len(suite.Properties) != 1 || suite.Properties[0].Value != "sha256:image" {
+		t.Fatalf("expected the image digest to be a property, got %+v", suite.Properties)
+	}
+	if suite.Cases[0].Failure != nil {
+		t.Fatalf("expected %s to pass", suite.Cases[0].Name)
+	}
+	if suite.Cases[1].Failure == nil || suite.Cases[1].Failure.Text != "usr/bin/foo (foo-1.0-1) was modified" {
+		t.Fatalf("expected %s to fail with its modified file, got %+v", suite.Cases[1].Name, suite.Cases[1].Failure)
+	}
+	if suite.Cases[2].Failure == nil || suite.Cases[2].Failure.Text != "usr/bin/foo (foo-1.0-1) was deleted" {
+		t.Fatalf("expected %s to fail with the file it modified again, got %+v", suite.Cases[2].Name, suite.Cases[2].Failure)
+	}
+}
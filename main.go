@@ -1,353 +1,709 @@
 package main
 
 import (
-	"archive/tar"
 	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
+	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"regexp"
+	"runtime"
 	"strings"
+	"syscall"
+	"time"
 
-	"github.com/charmbracelet/lipgloss"
-	"github.com/google/go-containerregistry/pkg/authn"
-	"github.com/google/go-containerregistry/pkg/crane"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+	"github.com/mattn/go-isatty"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+const helptext = `Searches an image's layers for the first layer containing an RPM, dpkg, or apk database, builds a list of files installed, then checks subsequent layers for modifications to those files
+
+The container reference is pulled from its registry unless it is prefixed with
+oci-layout:// or docker-archive://, in which case the image is read from an OCI
+image layout directory or a docker save tarball on the local filesystem. E.g.
+oci-layout:///path/to/layout or docker-archive:///path/to/image.tar
+
+References in the transport syntax of skopeo are also accepted: docker:// is
+pulled from its registry, oci: and dir: are read from an OCI image layout
+directory, and docker-archive: from a docker save tarball. E.g.
+docker://quay.io/mynamespace/myimage:latest or oci:/path/to/layout:latest
+
+A container filesystem written by docker export may be scanned by prefixing its
+path with container-export://. As it has no layers, its package-owned files are
+checked against the digests in its rpm database instead, and those missing from
+it are reported as deleted.
+
+Each flag may instead be set by an environment variable named after it in upper
+case with dashes replaced by underscores and prefixed with HMF_, such as
+HMF_FORMAT, HMF_OUTPUT_DIR, HMF_EXCLUSIONS, or HMF_FAIL_ON, and a repeatable
+flag by one with a value on each line. The container reference may be given by
+HMF_REFERENCE. A flag or argument given on the command line takes precedence
+over its environment variable, which takes precedence over the default.
+
+With -serve, scans are served over HTTP rather than run once: each container
+reference posted to /scan is scanned with the flags given, and the report is
+returned as JSON. A scan that fails is answered with an error status and a JSON
+object with its error. /metrics counts the scans performed and the disallowed
+modifications they found, and records their durations, labelled by whether each
+passed, failed, or could not complete. SIGINT or SIGTERM stops the server once
+the scans in progress complete, exiting with 0.
+
+Exit codes:
+  0   the scan completed, and found no disallowed modifications unless
+      -fail-on is none
+  1   an unexpected error occurred
+  2   the image could not be pulled or loaded
+  3   no usable package database was found in any layer of the image
+  4   a layer of the image could not be read
+  5   the results could not be written
+  6   the scan did not complete within -timeout
+  7   disallowed modifications were found, -strict-normalization found
+      suspicious entries, or the package database was empty and
+      -empty-package-db is fail, and -fail-on is any
+  8   the scan was interrupted by SIGINT or SIGTERM
+  10  invalid usage, configuration, or container reference`
+
+const (
+	exitError       = 1
+	exitPull        = 2
+	exitNoPackageDB = 3
+	exitLayerRead   = 4
+	exitOutput      = 5
+	exitTimeout     = 6
+	exitDisallowed  = 7
+	exitInterrupted = 8
+	exitUsage       = 10
+)
+
+const (
+	formatText      = "text"
+	formatJSON      = "json"
+	formatSARIF     = "sarif"
+	formatJUnit     = "junit"
+	formatNDJSON    = "ndjson"
+	formatCycloneDX = "cyclonedx"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+const (
+	modeScan    = "scan"
+	modeFileMap = "filemap"
 )
 
-const helptext = "Searches an image's layers for the first layer containing an RPMDB, builds a list of files installed, then checks subsequent layers for modifications to those files"
+const (
+	failOnNone = "none"
+	failOnAny  = "any"
+)
+
+const (
+	emptyPackageDBError = "error"
+	emptyPackageDBPass  = "pass"
+	emptyPackageDBFail  = "fail"
+)
+
+const (
+	pathStyleRelative = "relative"
+	pathStyleAbsolute = "absolute"
+)
+
+const (
+	exclusionsMerge   = "merge"
+	exclusionsReplace = "replace"
+)
 
 func main() {
-	if len(os.Args) != 2 {
-		fmt.Println("This only takes a single container reference as an argument. E.g. quay.io/mynamespace/myimage@digest")
-		fmt.Println(helptext)
-		os.Exit(10)
-	}
-	testContainer := os.Args[1]
-	fmt.Println("Container under test:", testContainer)
-
-	myImg, err := crane.Pull(testContainer, crane.WithAuthFromKeychain(authn.DefaultKeychain))
-	mne(err, "pull img")
-	layers, err := myImg.Layers()
-	mne(err, "get layers")
-
-	found, layerIndex, packages := FindRPMDB(layers)
-	if !found {
-		panic(errors.New("unable to find valid RPMDB in any layer of the image"))
-	}
-
-	if layerIndex == len(layers)-1 {
-		fmt.Println("The layer that contained the rpmdb was the last layer, so we consider it not possible to modify files. this is a pass case.")
-		os.Exit(0)
-	}
-
-	// filemap, err := InstalledFileMap(packages) // USING MANUAL EXCLUSIONS
-	filemap, err := InstalledFileMapWithExclusions(packages) // USING FILE FLAG EXCLUSIONS
-	mne(err, "couldn't extract a filemap from the package list")
-
-	if len(filemap) == 0 {
-		panic(errors.New("filemap was empty"))
-	}
-
-	remainingLayers := layers[layerIndex+1:]
-	disallowedModifications := map[string]string{}
-	for _, layer := range remainingLayers {
-		id, _ := layer.Digest()
-		fmt.Println("Checking layer for disallowed modifications", id)
-		modifiedFiles, err := GenerateChangesFor(layer)
-		mne(err, "error getting files from remaining layer")
-		var modFound bool
-		for _, modifiedFile := range modifiedFiles {
-			if _, found := filemap[modifiedFile]; found && (!PathIsExcluded(modifiedFile) && !DirectoryIsExcluded(modifiedFile)) { // USING MANUAL EXCLUSIONS
-				// if _, found := filemap[modifiedFile]; found  { // USING FILE FLAG EXCLUSIONS
-				modFound = true
-				disallowedModifications[modifiedFile] = id.String()
-			}
+	showVersion := flag.Bool("version", false, "print the version, commit, and build date of this build and exit")
+	mode := flag.String("mode", modeScan, "what to do with the image, one of: scan, filemap. filemap writes the file each installed package owns as text or json, without scanning for modifications")
+	format := flag.String("format", formatText, "output `format`, one of: text, json, sarif, junit, ndjson, cyclonedx. ndjson writes each disallowed modification as a JSON object on its own line as soon as it is found. cyclonedx writes a CycloneDX BOM with a component for each package owning a modified file, identified by its package URL, listing the files as its properties")
+	purlNamespace := flag.String("purl-namespace", "", "the `namespace` of the package URLs written with the cyclonedx format, such as redhat, debian, or alpine, to match those of an existing SBOM of the image. Omitted by default")
+	failOn := flag.String("fail-on", failOnAny, "when to exit non-zero because of the scan results, one of: none, any. none reports disallowed modifications without failing")
+	outputDir := flag.String("output-dir", "", "if set, write the filemap and per-layer results as JSON files to this `directory`")
+	pathStyle := flag.String("path-style", pathStyleRelative, "how the paths of the files in the image are written in the results, one of: relative, absolute. absolute paths have a leading /, e.g. /usr/bin/bash rather than usr/bin/bash")
+	reportFile := flag.String("report-file", "", "if set, write the whole report, including the image metadata, filemap, summary, and disallowed modifications, as a single JSON `file`")
+	reportLayerChanges := flag.Bool("report-layer-changes", false, "include every change made by each layer in -report-file, not only the disallowed ones")
+	exclusionsFile := flag.String("exclusions", "", "load directory and path exclusions from this JSON `file`")
+	profile := flag.String("profile", "", "merge the exclusions of this built-in `profile` for a distribution's base image, one of: "+strings.Join(scan.ProfileNames(), ", ")+", or auto to select it by the package manager found. -exclusions extends the profile, even with -exclusions-mode replace")
+	exclusionsMode := flag.String("exclusions-mode", exclusionsMerge, "whether exclusions from -exclusions are merged with or replace the defaults, one of: merge, replace")
+	dockerConfig := flag.String("docker-config", "", "read registry credentials from this docker config `file` instead of the default locations")
+	concurrency := flag.Int("concurrency", runtime.GOMAXPROCS(0), "number of layers to read at the same time")
+	logFormat := flag.String("log-format", logFormatText, "`format` of the progress messages written with the text format, one of: text, json")
+	check := flag.Bool("check", false, "only report the result with the exit code, writing nothing but a single line to stderr for an error. Unlike -quiet, this also suppresses the -format output")
+	quiet := flag.Bool("quiet", false, "only write the -format output, if it is not text, and errors; the exit code reports the result")
+	resolveSymlinks := flag.Bool("resolve-symlinks", true, "resolve the symlinked directories written by earlier layers, e.g. lib to usr/lib, in each path written by a layer, so that a file written through one is checked as the package-owned file it replaces. A symlink written at a package-owned path is reported either way")
+	verbose := flag.Bool("verbose", false, "explain, for every file changed by a layer, why its modification was or was not allowed")
+	timeout := flag.Duration("timeout", 0, "cancel the scan if it has not completed within this `duration`, e.g. 10m; 0 means no limit")
+	cacheDir := flag.String("cache-dir", "", "cache pulled layers in this `directory` so that repeated scans of the same image do not download them again")
+	noCache := flag.Bool("no-cache", false, "do not read or write the layer cache, even if -cache-dir is set")
+	rpmdbPath := flag.String("rpmdb-path", "", "the `directory` to look for the rpm database in within each layer, instead of /var/lib/rpm and /usr/lib/sysimage/rpm, for images that relocate it")
+	baselineFile := flag.String("baseline-file", "", "check the image against the installed files in this JSON `file`, as written by -mode filemap -format json for its base image, instead of reading its package database, which is much faster for many images built from the same base. Only the layers after the one the files were installed by are checked, and it is an error if the image does not have that layer. The files modifiable because of their flags are those with any of -modifiable-flags")
+	var rpmdbExtraPaths stringsFlag
+	flag.Var(&rpmdbExtraPaths, "rpmdb-extra-path", "an additional `directory` holding an rpm database, such as a per-user one or /mnt/sysroot/var/lib/rpm for a nested root, whose packages are merged with those of the main one; may be repeated. A file listed by several databases is owned by the package in the main one, and then in the one given first")
+	emptyPackageDB := flag.String("empty-package-db", emptyPackageDBError, "what a package database that lists no packages, such as one that was only initialized or is corrupt, means for the scan, one of: error, pass, fail. fail is subject to -fail-on like disallowed modifications")
+	rpmdbSelection := flag.String("rpmdb-selection", scan.RPMDBSelectionFirst, "which layer's rpm database to use as the baseline when several layers contain one, one of: first, last. last reflects the packages installed in the final image")
+	maxFileSize := flag.Int64("max-file-size", scan.DefaultMaxExtractedFileSize, "the most `bytes` extracted from a single file of a package database, beyond which the layer cannot be read; 0 means no limit")
+	maxLayerSize := flag.Int64("max-layer-size", scan.DefaultMaxExtractedLayerSize, "the most `bytes` extracted from a layer when reading its package database, beyond which the layer cannot be read; 0 means no limit")
+	tempDir := flag.String("temp-dir", "", "extract package databases to temporary directories in this `directory` instead of $TMPDIR or /tmp, e.g. a volume with room for a large database")
+	insecure := flag.Bool("insecure", false, "allow pulling from registries over plain HTTP or with TLS certificates that cannot be verified, e.g. self-signed ones")
+	proxy := flag.String("proxy", "", "reach registries through the proxy at this `url` instead of the one in HTTPS_PROXY or HTTP_PROXY")
+	caCert := flag.String("ca-cert", "", "trust the PEM encoded CA certificates in this `file`, in addition to the system's, when verifying registry certificates")
+	maxRetries := flag.Int("max-retries", scan.DefaultMaxRetries, "retry a registry request this many times after a network error or a 429, 502, 503, or 504 response; 0 disables retries")
+	retryBackoff := flag.Duration("retry-backoff", scan.DefaultRetryBackoff, "wait this `duration` before the first retry, doubling it for each retry after it, unless the registry asks to wait longer")
+	platform := flag.String("platform", "", "select the image for this `os/arch[/variant]` when the reference is a multi-platform image index, e.g. linux/amd64")
+	verifyDigests := flag.Bool("verify-digests", false, "only report an rpm-owned file written by a later layer if its content differs from the digest recorded in the rpm database")
+	allowModifiedSymlinks := flag.Bool("allow-modified-symlinks", false, "allow a symlink written at the path of a package-owned file, such as the usrmerge symlinks owned by the filesystem package that later layers often recreate, while still disallowing other modifications to it. Only enable this if the symlinks written by the layers being checked are trusted, as a symlink replacing a package-owned binary is allowed too. A directory replaced by a symlink, and any -always-fail-path, are still disallowed")
+	strictNormalization := flag.Bool("strict-normalization", false, "fail the scan on any entry of a layer being checked whose name has a .. element, such as usr/lib/../bin/sh, which no legitimate layer has and which may modify a package-owned file without appearing to, listing them separately from the disallowed modifications")
+	adviseConfig := flag.Bool("advise-config", false, "list the modified rpm config files allowed by -allow-flags as advisories, which do not fail the scan")
+	checkInstallLayer := flag.Bool("check-install-layer", false, "also check the layer containing the rpm database for files whose content differs from the digest recorded in it, such as those modified by the same RUN instruction that installed them")
+	showProgress := flag.Bool("progress", isatty.IsTerminal(os.Stderr.Fd()), "report each layer to stderr as it is read; defaults to true when stderr is a terminal")
+	allowFlags := flag.String("allow-flags", "config,doc,ghost,license,missingok,readme", "comma separated rpm file `flags` that make a file modifiable, e.g. config,doc")
+	var excludeRegexps stringsFlag
+	flag.Var(&excludeRegexps, "exclude-regex", "exclude paths matching this regular `expression`; may be repeated")
+	compareRef := flag.String("compare", "", "also scan this baseline container `reference`, such as the last release, and report which disallowed modifications were added, removed, or unchanged. -fail-on then only fails on added modifications")
+	serve := flag.String("serve", "", "instead of scanning a single reference, serve scans on this `address`, e.g. :8080, until interrupted. POST /scan with {\"reference\": \"...\"} responds with the report -report-file would write, GET /healthz with ok, and GET /metrics with Prometheus metrics of the scans performed. Only references to images in a registry are accepted")
+	serveConcurrency := flag.Int("serve-concurrency", 4, "the most scans -serve runs at the same time, beyond which a request is rejected with 429 Too Many Requests")
+	refsFile := flag.String("refs-file", "", "scan each container reference in this `file`, one per line, or in stdin if it is -, instead of a single reference. An image that fails is reported with its error without stopping the others, and with the json format each image's result is written as a line of JSON as soon as it completes")
+	var includeOnly stringsFlag
+	flag.Var(&includeOnly, "include-only", "only check the package-owned files under this directory, or matching this glob, e.g. /usr/bin or /usr/lib64/*.so*; may be repeated. Exclusions still apply to the files included")
+	var onlyPackages stringsFlag
+	flag.Var(&onlyPackages, "only-packages", "only check the files owned by the package with this `name`, e.g. openssl-libs, ignoring those of every other package; may be repeated. With the filemap mode, only their files are written")
+	topLayers := flag.Int("top-layers", 0, "only check the last `N` layers, such as those a build added on top of a trusted base, skipping any other layers after the package database without reading them. This trades completeness for speed: modifications made by the skipped layers are not found, and symlinks they create are not resolved. 0 checks every layer, as does an N larger than the number of layers")
+	var alwaysFailPaths stringsFlag
+	flag.Var(&alwaysFailPaths, "always-fail-path", "always disallow any modification to the package-owned file at this `path`, or matching this glob, e.g. /usr/bin/sudo, even if -include-only, the exclusions, or -allow-package would allow it; may be repeated. It does not apply to the files made modifiable by -allow-flags")
+	var allowPackages stringsFlag
+	flag.Var(&allowPackages, "allow-package", "allow any modification to the files owned by the package with this `name`, e.g. filesystem; may be repeated. This only adds to what -allow-flags and the exclusions allow")
+	flag.Usage = usage
+	flag.Parse()
+	if err := setFlagsFromEnv(flag.CommandLine, os.LookupEnv); err != nil {
+		usageError(err)
+	}
+
+	if *showVersion {
+		fmt.Println(currentBuild())
+		return
+	}
+	args := flag.Args()
+	if ref := os.Getenv(envReference); len(args) == 0 && *refsFile == "" && *serve == "" && ref != "" {
+		args = []string{ref}
+	}
+	if *refsFile != "" || *serve != "" {
+		if len(args) != 0 {
+			usageError("a container reference cannot be given as an argument with -refs-file or -serve")
 		}
-		if modFound {
-			fmt.Println(red("\tfound disallowed modification in layer"))
+	} else if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "This only takes a single container reference as an argument. E.g. quay.io/mynamespace/myimage@digest")
+		usage()
+		os.Exit(exitUsage)
+	}
+	switch *format {
+	case formatText, formatJSON, formatSARIF, formatJUnit, formatNDJSON, formatCycloneDX:
+	default:
+		usageError("unknown format", *format)
+	}
+	switch *mode {
+	case modeScan:
+	case modeFileMap:
+		if *format != formatText && *format != formatJSON {
+			usageError("the filemap mode can only be written as text or json")
 		}
-		b, _ := json.MarshalIndent(modifiedFiles, "", "    ")
-		os.WriteFile(fmt.Sprintf("modified-in-%s.json", id.String()), b, 0644)
+		if *compareRef != "" {
+			usageError("-compare cannot be used with the filemap mode")
+		}
+	default:
+		usageError("unknown mode", *mode)
+	}
+	if *check && *mode == modeFileMap {
+		usageError("-check cannot be used with the filemap mode")
+	}
+	if *baselineFile != "" && *mode == modeFileMap {
+		usageError("-baseline-file cannot be used with the filemap mode")
+	}
+	if *refsFile != "" {
+		switch {
+		case *mode != modeScan:
+			usageError("-refs-file can only be used with the scan mode")
+		case *format != formatText && *format != formatJSON:
+			usageError("-refs-file can only be used with the text or json format")
+		case *compareRef != "", *outputDir != "", *reportFile != "":
+			usageError("-refs-file cannot be used with -compare, -output-dir, or -report-file")
+		}
+	}
+	if *serve != "" {
+		switch {
+		case *mode != modeScan:
+			usageError("-serve can only be used with the scan mode")
+		case *refsFile != "", *compareRef != "", *outputDir != "", *reportFile != "":
+			usageError("-serve cannot be used with -refs-file, -compare, -output-dir, or -report-file")
+		case *format == formatNDJSON:
+			usageError("-serve cannot be used with the ndjson format")
+		case *serveConcurrency < 1:
+			usageError("-serve-concurrency must be at least 1")
+		}
+	}
+	// stdout is where the -format output is written, which -check discards
+	// along with everything -quiet does.
+	var stdout io.Writer = os.Stdout
+	if *check {
+		stdout = io.Discard
+		*quiet = true
+	}
+	switch *logFormat {
+	case logFormatText, logFormatJSON:
+	default:
+		usageError("unknown log format", *logFormat)
+	}
+	switch *pathStyle {
+	case pathStyleRelative, pathStyleAbsolute:
+	default:
+		usageError("unknown path style", *pathStyle)
 	}
-	if len(disallowedModifications) > 0 {
-		fmt.Println("Summary of disallowed modifications")
-		b, _ := json.MarshalIndent(disallowedModifications, "", "    ")
-		fmt.Println(string(b))
+	absolute := *pathStyle == pathStyleAbsolute
+	switch *failOn {
+	case failOnNone, failOnAny:
+	default:
+		usageError("unknown -fail-on threshold", *failOn)
+	}
+	var testContainer string
+	if len(args) > 0 {
+		testContainer = args[0]
 	}
 
-	b, _ := json.MarshalIndent(filemap, "", "    ")
-	os.WriteFile("filemap.json", b, 0644)
-	b, _ = json.MarshalIndent(disallowedModifications, "", "    ")
-	os.WriteFile("disallowedmods.json", b, 0644)
-}
+	opts := []scan.Option{}
+	if *exclusionsFile != "" {
+		exclusions, err := scan.LoadExclusions(*exclusionsFile)
+		if err != nil {
+			usageError(err)
+		}
+		switch *exclusionsMode {
+		case exclusionsMerge:
+			exclusions = scan.DefaultExclusions().Merge(exclusions)
+		case exclusionsReplace:
+		default:
+			usageError("unknown exclusions mode", *exclusionsMode)
+		}
+		opts = append(opts, scan.WithExclusions(exclusions))
+	}
+	if *baselineFile != "" {
+		baseline, err := scan.LoadBaseline(*baselineFile)
+		if err != nil {
+			usageError(err)
+		}
+		opts = append(opts, scan.WithBaseline(baseline))
+	}
 
-// FindRPMDB attempts to extract a valid RPMDB from layers in the order
-// they are provided. If found is not set to true, foundIndex and pkglist should
-// be disregarded as any value there will be invalid.
-func FindRPMDB(layers []v1.Layer) (found bool, foundIndex int, pkglist []*rpmdb.PackageInfo) {
-	for i, layer := range layers {
-		var err error
-		pkglist, err = ExtractRPMDB(layer)
-		if err == nil {
-			id, _ := layer.Digest()
-			fmt.Println("layer", id, "contained the rpmdb")
-			found = true
-			foundIndex = i
-			return
+	if *profile != "" && *profile != scan.ProfileAuto {
+		if _, err := scan.ProfileExclusions(*profile); err != nil {
+			usageError(err)
 		}
 	}
+	if *profile != "" {
+		opts = append(opts, scan.WithProfile(*profile))
+	}
 
-	return found, foundIndex, pkglist
-}
+	if *timeout < 0 {
+		usageError("-timeout must not be negative")
+	}
 
-// DirectoryIsExcluded excludes a directory and any file contained in that directory.
-func DirectoryIsExcluded(s string) bool {
-	excl := map[string]struct{}{
-		"etc": {},
-		"var": {},
-		"run": {},
+	if *concurrency < 1 {
+		usageError("-concurrency must be at least 1")
+	}
+	opts = append(opts, scan.WithConcurrency(*concurrency), scan.WithVerbose(*verbose), scan.WithSymlinkResolution(*resolveSymlinks))
+	// the images of a batch, and those compared with -compare, often share
+	// the layer containing the rpm database, which is then only parsed once.
+	// The server instead keeps the cache of each scan to itself, as one
+	// shared by every scan it serves would grow for as long as it runs.
+	if *serve == "" {
+		opts = append(opts, scan.WithRPMDBCache(scan.NewRPMDBCache()))
 	}
 
-	for k, _ := range excl {
-		if strings.HasPrefix(s, filepath.Clean(k+"/")) || k == s {
-			fmt.Println("\t", s, "was excluded by", yellow("directory"), "exclusions")
-			return true
-		}
+	modifiableFlags, err := scan.ParseFileFlags(*allowFlags)
+	if err != nil {
+		usageError("invalid -allow-flags:", err)
 	}
+	opts = append(opts, scan.WithModifiableFileFlags(modifiableFlags), scan.WithDigestVerification(*verifyDigests), scan.WithInstallLayerCheck(*checkInstallLayer), scan.WithConfigAdvisories(*adviseConfig), scan.WithModifiedSymlinksAllowed(*allowModifiedSymlinks), scan.WithStrictNormalization(*strictNormalization))
 
-	return false
-}
+	if *dockerConfig != "" {
+		opts = append(opts, scan.WithDockerConfig(*dockerConfig))
+	}
 
-// PathIsExcluded checks if s is excluded explicitly as written.
-func PathIsExcluded(s string) bool {
-	excl := map[string]struct{}{
-		"etc/resolv.conf": {},
-		"etc/hostname":    {},
-		// etc and etc/ are both required as both can present the directory
-		// in a tarball. Same goes for other directories.
-		"etc":  {},
-		"etc/": {},
-		"run":  {},
-		"run/": {},
+	if *rpmdbPath != "" {
+		opts = append(opts, scan.WithRPMDBPath(*rpmdbPath))
+	}
+	if len(rpmdbExtraPaths) > 0 {
+		opts = append(opts, scan.WithAdditionalRPMDBPaths(rpmdbExtraPaths...))
+	}
+	switch *emptyPackageDB {
+	case emptyPackageDBError, emptyPackageDBPass, emptyPackageDBFail:
+		opts = append(opts, scan.WithEmptyPackageDB(*emptyPackageDB != emptyPackageDBError))
+	default:
+		usageError("unknown -empty-package-db result", *emptyPackageDB)
 	}
+	failing := failingReport(*emptyPackageDB)
 
-	_, found := excl[s]
-	if found {
-		fmt.Println("\t", s, "was excluded by", blue("file"), "exclusions")
+	switch *rpmdbSelection {
+	case scan.RPMDBSelectionFirst, scan.RPMDBSelectionLast:
+		opts = append(opts, scan.WithRPMDBSelection(*rpmdbSelection))
+	default:
+		usageError("unknown rpmdb selection", *rpmdbSelection)
 	}
-	return found
-}
 
-// Normalize will clean a filepath of extraneous characters like ./, //, etc.
-// and strip a leading slash. E.g. /foo/../baz --> baz
-func Normalize(s string) string {
-	// for the root path, return the root path.
-	if s == "/" {
-		return s
+	if *maxFileSize < 0 || *maxLayerSize < 0 {
+		usageError("-max-file-size and -max-layer-size must not be negative")
+	}
+	opts = append(opts, scan.WithExtractionLimits(*maxFileSize, *maxLayerSize))
+	if *tempDir != "" {
+		if info, err := os.Stat(*tempDir); err != nil || !info.IsDir() {
+			usageError("-temp-dir must be an existing directory:", *tempDir)
+		}
+		opts = append(opts, scan.WithTempDir(*tempDir))
 	}
-	return filepath.Clean(strings.TrimPrefix(s, "/"))
-}
 
-// InstalledFileMap gets a map of installed filenames that have been cleaned
-// of extra slashes, dotslashes, and leading slashes.
-func InstalledFileMap(pkglist []*rpmdb.PackageInfo) (map[string]string, error) {
-	m := map[string]string{}
-	for _, pkg := range pkglist {
-		files, err := pkg.InstalledFileNames()
-		if err != nil {
-			return m, err
+	if *insecure {
+		if !*check {
+			fmt.Fprintln(os.Stderr, "WARN: -insecure is set, registry TLS certificates will not be verified and plain HTTP is allowed")
 		}
+		opts = append(opts, scan.WithInsecure(true))
+	}
 
-		for _, file := range files {
-			m[Normalize(file)] = fmt.Sprintf("%s-%s-%s", pkg.Name, pkg.Version, pkg.Release)
+	if *maxRetries < 0 || *retryBackoff < 0 {
+		usageError("-max-retries and -retry-backoff must not be negative")
+	}
+	opts = append(opts, scan.WithRetries(*maxRetries, *retryBackoff))
+
+	if *proxy != "" {
+		u, err := url.Parse(*proxy)
+		if err != nil || u.Host == "" {
+			usageError("invalid -proxy:", *proxy)
 		}
+		opts = append(opts, scan.WithProxy(u))
+	}
+	if *caCert != "" {
+		opts = append(opts, scan.WithCACertFile(*caCert))
 	}
-	return m, nil
-}
 
-// InstalledFileMapWithExclusions gets a map of installed filenames that have been cleaned
-// of extra slashes, dotslashes, and leading slashes.
-func InstalledFileMapWithExclusions(pkglist []*rpmdb.PackageInfo) (map[string]string, error) {
-	const okFlags = rpmdb.RPMFILE_CONFIG |
-		rpmdb.RPMFILE_DOC |
-		rpmdb.RPMFILE_LICENSE |
-		rpmdb.RPMFILE_MISSINGOK |
-		rpmdb.RPMFILE_README
-	m := map[string]string{}
-	for _, pkg := range pkglist {
-		files, err := pkg.InstalledFiles()
+	if *platform != "" {
+		p, err := v1.ParsePlatform(*platform)
 		if err != nil {
-			return m, err
+			usageError("invalid -platform:", err)
 		}
+		opts = append(opts, scan.WithPlatform(p))
+	}
 
-		for _, file := range files {
-			if int32(file.Flags)&okFlags > 0 {
-				// Uncomment this if you want to see what files were considered valid - but it's very verbose (it's a lot of files)
-				// fmt.Println("\tfile", yellow(Normalize(file.Path)), "is considered modifiable because of its file flags", file.Flags)
+	if *cacheDir != "" && !*noCache {
+		opts = append(opts, scan.WithCacheDir(*cacheDir))
+	}
 
-				// It is one of the ok flags. Skip it.
-				continue
+	for _, expr := range excludeRegexps {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			usageError("invalid -exclude-regex:", err)
+		}
+		opts = append(opts, scan.WithRegexpExclusions(re))
+	}
+	if len(includeOnly) > 0 {
+		if _, err := scan.CompilePathPatterns(includeOnly); err != nil {
+			usageError("invalid -include-only:", err)
+		}
+		opts = append(opts, scan.WithIncludeOnly(includeOnly...))
+	}
+	if len(onlyPackages) > 0 {
+		opts = append(opts, scan.WithOnlyPackages(onlyPackages...))
+	}
+	if *topLayers < 0 {
+		usageError("-top-layers cannot be negative")
+	}
+	if *topLayers > 0 {
+		opts = append(opts, scan.WithTopLayers(*topLayers))
+	}
+	if len(alwaysFailPaths) > 0 {
+		opts = append(opts, scan.WithAlwaysFailPaths(alwaysFailPaths...))
+	}
+	if len(allowPackages) > 0 {
+		opts = append(opts, scan.WithAllowedPackages(allowPackages...))
+	}
+
+	var ndjson *ndjsonWriter
+	if *format == formatNDJSON {
+		if *compareRef != "" {
+			usageError("-compare cannot be used with the ndjson format")
+		}
+		ndjson = newNDJSONWriter(stdout)
+		handle := ndjson.write
+		if absolute {
+			handle = func(mod scan.Modification) {
+				mod.File = absolutePath(mod.File)
+				ndjson.write(mod)
 			}
-			m[Normalize(file.Path)] = fmt.Sprintf("%s-%s-%s", pkg.Name, pkg.Version, pkg.Release)
 		}
+		opts = append(opts, scan.WithModificationHandler(handle))
 	}
-	return m, nil
-}
 
-const whiteoutPrefix = ".wh."
+	// Human readable logging is only emitted for the text format so that
+	// structured formats are machine-parseable, and not at all when quiet or
+	// when the filemap is written instead.
+	var logOut io.Writer = os.Stdout
+	if *format != formatText || *quiet || *mode == modeFileMap {
+		logOut = io.Discard
+	}
+	logger := scan.NewTextLogger(logOut)
+	if *logFormat == logFormatJSON {
+		logger = scan.NewJSONLogger(logOut)
+	}
+	if *showProgress && !*quiet {
+		opts = append(opts, scan.WithProgress(os.Stderr, isatty.IsTerminal(os.Stderr.Fd())))
+	}
+	opts = append(opts, scan.WithLogger(logger))
+
+	// SIGINT and SIGTERM cancel the scan, so that it stops and cleans up what
+	// it extracted before exiting. Once it is canceled, another signal exits
+	// immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if *serve != "" {
+		// the scans served run at the same time, so only the result of each
+		// is logged rather than its progress.
+		opts = append(opts, scan.WithOutput(io.Discard), scan.WithProgress(nil, false))
+		os.Exit(runServe(ctx, *serve, newScanServer(*serveConcurrency, logger, failing, func(ctx context.Context, ref string) (*scan.Report, error) {
+			return scanReference(ctx, ref, *timeout, absolute, opts)
+		})))
+	}
+	if *refsFile != "" {
+		os.Exit(runBatch(ctx, *refsFile, stdout, logger, *format == formatJSON, absolute, *failOn, failing, *timeout, opts))
+	}
+	logger.Log("Container under test:", testContainer)
 
-// GenerateChangesFor will check layer for file changes, and will return a list of those.
-func GenerateChangesFor(layer v1.Layer) ([]string, error) {
-	layerReader, err := layer.Uncompressed()
-	if err != nil {
-		return nil, fmt.Errorf("reading layer contents: %w", err)
-	}
-	defer layerReader.Close()
-	tarReader := tar.NewReader(layerReader)
-	var filelist []string
-	for {
-		header, err := tarReader.Next()
-		if errors.Is(err, io.EOF) {
-			break
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	failScan := func(err error) {
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			fmt.Fprintln(os.Stderr, "ERR: the scan did not complete within", *timeout)
+			os.Exit(exitTimeout)
+		case errors.Is(ctx.Err(), context.Canceled):
+			fmt.Fprintln(os.Stderr, "ERR: the scan was interrupted")
+			os.Exit(exitInterrupted)
 		}
+		fail(err)
+	}
+	if *mode == modeFileMap {
+		files, err := scan.InstalledFileMap(ctx, testContainer, opts...)
 		if err != nil {
-			return nil, fmt.Errorf("reading tar: %w", err)
+			failScan(err)
 		}
-
-		// Some tools prepend everything with "./", so if we don't Clean the
-		// name, we may have duplicate entries, which angers tar-split.
-		header.Name = filepath.Clean(header.Name)
-		// force PAX format to remove Name/Linkname length limit of 100 characters
-		// required by USTAR and to not depend on internal tar package guess which
-		// prefers USTAR over PAX
-		header.Format = tar.FormatPAX
-
-		basename := filepath.Base(header.Name)
-		dirname := filepath.Dir(header.Name)
-		tombstone := strings.HasPrefix(basename, whiteoutPrefix)
-		if tombstone {
-			basename = basename[len(whiteoutPrefix):]
+		if absolute {
+			absoluteFileMap(files)
 		}
-		switch {
-		case (header.Typeflag == tar.TypeDir && tombstone) || header.Typeflag == tar.TypeReg:
-			filelist = append(filelist, strings.TrimPrefix(filepath.Join(dirname, basename), "/"))
-		case header.Typeflag == tar.TypeSymlink:
-			filelist = append(filelist, strings.TrimPrefix(header.Linkname, "/"))
-		default:
-			// TODO: what do we do with other flags?
-			continue
+		if *format == formatJSON {
+			checkOutput(writeFileMapJSON(os.Stdout, testContainer, files), "writing json")
+		} else {
+			checkOutput(writeFileMap(os.Stdout, files), "writing filemap")
 		}
+		return
+	}
+	scanRef := func(ref string) *scan.Report {
+		report, err := scan.Scan(ctx, ref, opts...)
+		if err != nil {
+			failScan(err)
+		}
+		if absolute {
+			absolutePaths(report)
+		}
+		return report
+	}
+	report := scanRef(testContainer)
+	if report.RPMDBRemovedBy != "" && !*check {
+		fmt.Fprintln(os.Stderr, "WARN: the rpm database was removed by layer", report.RPMDBRemovedBy+", so the modifications found are against packages that may no longer be installed")
+	}
+	if ndjson != nil {
+		checkOutput(ndjson.err, "writing ndjson")
 	}
 
-	return filelist, nil
-}
+	var comparison *jsonComparison
+	if *compareRef != "" {
+		logger.Log("Baseline container:", *compareRef)
+		comparison = &jsonComparison{Reference: *compareRef, Comparison: scan.Compare(scanRef(*compareRef), report)}
+		logComparison(logger, comparison)
+	}
 
-// ExtractRPMDB copies /var/lib/rpm/* from the archive and derives a list of packages from
-// the rpm database.
-func ExtractRPMDB(layer v1.Layer) ([]*rpmdb.PackageInfo, error) {
-	layerReader, err := layer.Uncompressed()
-	if err != nil {
-		return nil, fmt.Errorf("reading layer contents: %w", err)
+	switch *format {
+	case formatJSON:
+		checkOutput(writeJSON(stdout, testContainer, report, comparison), "writing json")
+	case formatSARIF:
+		checkOutput(writeSARIF(stdout, testContainer, report), "writing sarif")
+	case formatJUnit:
+		checkOutput(writeJUnit(stdout, testContainer, report), "writing junit")
+	case formatCycloneDX:
+		checkOutput(writeCycloneDX(stdout, testContainer, report, *purlNamespace), "writing cyclonedx")
 	}
-	defer layerReader.Close()
 
-	basepath, err := os.MkdirTemp("", "rpmdb-*")
-	if err != nil {
-		return nil, err
+	if *reportFile != "" {
+		checkOutput(writeReportFile(*reportFile, testContainer, report, *reportLayerChanges), "writing -report-file")
 	}
-	defer os.RemoveAll(basepath)
 
-	tarReader := tar.NewReader(layerReader)
-	for {
-		header, err := tarReader.Next()
-		if errors.Is(err, io.EOF) {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("reading tar: %w", err)
-		}
+	logger.Log(coverageLine(report.Coverage))
+	// with nothing left to check, the scan passes through the same path as any
+	// other, so that -output-dir is still written.
+	if report.NoModifiablePossible {
+		logger.Log("The layer that contained the", report.PackageManager, "database was the last layer, so we consider it not possible to modify files. this is a pass case.")
+	}
 
-		// Some tools prepend everything with "./", so if we don't Clean the
-		// name, we may have duplicate entries, which angers tar-split.
-		header.Name = filepath.Clean(header.Name)
-		header.Format = tar.FormatPAX
-		rpmdirname := filepath.Clean("var/lib/rpm")
-		basename := filepath.Base(header.Name)
-		dirname := filepath.Dir(header.Name)
-		tombstone := strings.HasPrefix(basename, whiteoutPrefix)
-
-		// a dir or file with the correct var/lib/rpm prefix that has not been marked with a tombstone is valid.
-		if (header.Typeflag == tar.TypeDir || header.Typeflag == tar.TypeReg) && strings.HasPrefix(filepath.Join(dirname, basename), rpmdirname) && !tombstone {
-			if header.Typeflag == tar.TypeDir {
-				err := os.MkdirAll(filepath.Join(basepath, dirname, basename), header.FileInfo().Mode())
-				if err != nil {
-					return nil, err
-				}
-				continue
-			}
+	if len(report.DisallowedModifications) > 0 {
+		logger.Log("Summary of disallowed modifications")
+		b, err := json.MarshalIndent(report.DisallowedModifications, "", "    ")
+		mne(err, "marshal disallowed modifications")
+		logger.Log(string(b))
+		logger.Log("Disallowed modifications by layer")
+		logLayerModifications(logger, report)
+		logger.Log("Top offenders")
+		logger.Log(packageTable(report.PackageCounts()))
+	}
+	if len(report.SuspiciousEntries) > 0 {
+		logger.Log("Suspicious entries with a parent directory element")
+		logSuspiciousEntries(logger, report)
+	}
 
-			f, err := os.OpenFile(filepath.Join(basepath, dirname, basename), os.O_RDWR|os.O_CREATE|os.O_TRUNC, header.FileInfo().Mode())
-			if err != nil {
-				return nil, err
-			}
-			err = func() error {
-				// closure here allows us to defer f.Close() in this iteration instead of
-				// waiting for the parent function to complete.
-				defer f.Close()
-				_, err := io.Copy(f, tarReader)
-				if err != nil {
-					return err
-				}
-				return nil
-			}()
-			if err != nil {
-				return nil, nil // TODO: is this correct to return nil here?
-			}
-		}
+	if *outputDir != "" {
+		checkOutput(writeArtifacts(*outputDir, report), "writing -output-dir")
 	}
 
-	packageList, err := GetPackageList(context.TODO(), basepath)
-	if err != nil {
-		return nil, err
+	logger.Log(summaryLine(report.Summary()))
+	failed := failing(report)
+	if comparison != nil {
+		failed = len(comparison.Added) > 0
 	}
+	if *failOn == failOnAny && failed {
+		os.Exit(exitDisallowed)
+	}
+}
 
-	return packageList, nil
+// failingReport returns a func reporting whether a report fails the scan when
+// -fail-on is any, and -empty-package-db is emptyPackageDBResult.
+func failingReport(emptyPackageDBResult string) func(*scan.Report) bool {
+	return func(report *scan.Report) bool {
+		return len(report.DisallowedModifications) > 0 || len(report.SuspiciousEntries) > 0 || report.EmptyPackageDB && emptyPackageDBResult == emptyPackageDBFail
+	}
 }
 
-// GetPackageList returns the list of packages in the rpm database from either
-// /var/lib/rpm/rpmdb.sqlite, or /var/lib/rpm/Packages if the former does not exist.
-// If neither exists, this returns an error of type os.ErrNotExists
-// NOTE: Borrowed from existing preflight code. Nothing to change here.
-func GetPackageList(ctx context.Context, basePath string) ([]*rpmdb.PackageInfo, error) {
-	rpmdirPath := filepath.Join(basePath, "var", "lib", "rpm")
-	rpmdbPath := filepath.Join(rpmdirPath, "rpmdb.sqlite")
-
-	if _, err := os.Stat(rpmdbPath); errors.Is(err, os.ErrNotExist) {
-		// rpmdb.sqlite doesn't exist. Fall back to Packages
-		rpmdbPath = filepath.Join(rpmdirPath, "Packages")
-
-		// if the fall back path does not exist - this probably isn't a RHEL or UBI based image
-		if _, err := os.Stat(rpmdbPath); errors.Is(err, os.ErrNotExist) {
-			return nil, err
+// runBatch scans each container reference in the file name, or stdin if it is
+// -, and returns the exit code of the batch: that of the first image that
+// failed, if any, and otherwise that of any image whose report is failing.
+func runBatch(ctx context.Context, name string, stdout io.Writer, logger scan.Logger, asJSON, absolute bool, failOn string, failing func(*scan.Report) bool, timeout time.Duration, opts []scan.Option) int {
+	in := os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			usageError(err)
 		}
+		defer f.Close()
+		in = f
 	}
-
-	db, err := rpmdb.Open(rpmdbPath)
+	refs, err := readRefs(in)
 	if err != nil {
-		return nil, fmt.Errorf("could not open rpm db: %v", err)
+		usageError("reading -refs-file:", err)
+	}
+	if len(refs) == 0 {
+		usageError("no container references in", name)
 	}
-	pkgList, err := db.ListPackages()
+
+	failed, err := scanBatch(stdout, logger, refs, asJSON, failing, func(ref string) (*scan.Report, error) {
+		return scanReference(ctx, ref, timeout, absolute, opts)
+	})
+	switch {
+	case err != nil:
+		return exitCode(err)
+	case failOn == failOnAny && failed:
+		return exitDisallowed
+	}
+	return 0
+}
+
+// scanReference scans ref with opts, failing if it does not complete within
+// timeout, if it is not 0, and making the paths of its report absolute if
+// absolute is set.
+func scanReference(ctx context.Context, ref string, timeout time.Duration, absolute bool, opts []scan.Option) (*scan.Report, error) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	report, err := scan.Scan(ctx, ref, opts...)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("the scan did not complete within %s: %w", timeout, ctx.Err())
+	}
+	if err == nil && absolute {
+		absolutePaths(report)
+	}
+	return report, err
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "Usage: hasmodifiedfiles [flags] <container reference>")
+	fmt.Fprintln(out, "       hasmodifiedfiles [flags] -refs-file <file>")
+	fmt.Fprintln(out, "       hasmodifiedfiles [flags] -serve <address>")
+	fmt.Fprintln(out, helptext)
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+// usageError prints a to stderr and exits with the usage exit code.
+func usageError(a ...any) {
+	fmt.Fprintln(os.Stderr, append([]any{"ERR:"}, a...)...)
+	os.Exit(exitUsage)
+}
+
+// errOutput is the kind of error returned when the results cannot be written.
+var errOutput = errors.New("unable to write the results")
+
+// checkOutput fails with errOutput if err, from what, is non-nil.
+func checkOutput(err error, what string) {
 	if err != nil {
-		return nil, fmt.Errorf("could not list packages: %v", err)
+		fail(fmt.Errorf("%w: %s: %v", errOutput, what, err))
 	}
+}
 
-	return pkgList, nil
+// fail prints err to stderr and exits with the code for its failure class.
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "ERR:", err)
+	os.Exit(exitCode(err))
 }
 
-var red = lipgloss.NewStyle().Foreground(lipgloss.Color("#D21404")).Render
-var yellow = lipgloss.NewStyle().Foreground(lipgloss.Color("#D6B85A")).Render
-var blue = lipgloss.NewStyle().Foreground(lipgloss.Color("#0000FF")).Render
+// exitCode maps err to the exit code documented in helptext.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errOutput):
+		return exitOutput
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitTimeout
+	case errors.Is(err, context.Canceled):
+		return exitInterrupted
+	case errors.Is(err, scan.ErrPlatformRequired), errors.Is(err, scan.ErrInvalidReference):
+		return exitUsage
+	case errors.Is(err, scan.ErrImagePull):
+		return exitPull
+	case errors.Is(err, scan.ErrRPMDBNotFound), errors.Is(err, scan.ErrNoPackageDB), errors.Is(err, scan.ErrEmptyPackageDB), errors.Is(err, scan.ErrInvalidPackageDB):
+		return exitNoPackageDB
+	case errors.Is(err, scan.ErrLayerRead):
+		return exitLayerRead
+	default:
+		return exitError
+	}
+}
@@ -1,62 +1,86 @@
 package main
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
 
-func TestDirectoryExclusion(t *testing.T) {
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestExitCode(t *testing.T) {
 	tests := []struct {
-		input    string
-		expected bool
+		input    error
+		expected int
 	}{
-		{"etc/myconfig.txt", true},
-		{"opt/myconfig", false},
-		{"var", true},
-		{"run/foo/bar/baz", true},
+		{fmt.Errorf("wrapped: %w", scan.ErrImagePull), exitPull},
+		{scan.ErrRPMDBNotFound, exitNoPackageDB},
+		{scan.ErrNoPackageDB, exitNoPackageDB},
+		{scan.ErrLayerRead, exitLayerRead},
+		{fmt.Errorf("pulling: %w", context.DeadlineExceeded), exitTimeout},
+		{fmt.Errorf("pulling: %w", scan.ErrPlatformRequired), exitUsage},
+		{&scan.Error{Kind: scan.ErrImagePull, Err: scan.ErrInvalidReference}, exitUsage},
+		{scan.ErrInvalidPackageDB, exitNoPackageDB},
+		{fmt.Errorf("%w: the rpm database", scan.ErrEmptyPackageDB), exitNoPackageDB},
+		{fmt.Errorf("%w: writing json", errOutput), exitOutput},
+		{&scan.Error{Kind: scan.ErrLayerRead, Err: context.Canceled}, exitInterrupted},
+		{errors.New("something else"), exitError},
 	}
 
 	for _, test := range tests {
-		actual := DirectoryIsExcluded(test.input)
+		actual := exitCode(test.input)
 		if actual != test.expected {
-			t.Fatalf("want=%t, got=%t for input %s", test.expected, actual, test.input)
-			t.Fail()
+			t.Fatalf("want=%d, got=%d for input %v", test.expected, actual, test.input)
 		}
 	}
 }
 
-func TestFileExclusion(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected bool
-	}{
-		{"etc/myconfig.txt", false},
-		{"etc/resolv.conf", true},
+func TestSetFlagsFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	format := fs.String("format", formatText, "")
+	failOn := fs.String("fail-on", failOnAny, "")
+	quiet := fs.Bool("quiet", false, "")
+	var includeOnly stringsFlag
+	fs.Var(&includeOnly, "include-only", "")
+	if err := fs.Parse([]string{"-format", formatSARIF}); err != nil {
+		t.Fatal(err)
 	}
 
-	for _, test := range tests {
-		actual := PathIsExcluded(test.input)
-		if actual != test.expected {
-			t.Fatalf("want=%t, got=%t for input %s", test.expected, actual, test.input)
-			t.Fail()
-		}
+	env := map[string]string{
+		"HMF_FORMAT":       formatJSON,
+		"HMF_FAIL_ON":      failOnNone,
+		"HMF_QUIET":        "true",
+		"HMF_INCLUDE_ONLY": "/usr/bin\n\n/usr/lib64\n",
 	}
-}
-
-func TestNormalize(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{"/my/path", "my/path"},
-		{"./this/that", "this/that"},
-		{"this/that/../foo", "this/foo"},
-		{"this/../that", "that"},
-		{"/", "/"},
+	err := setFlagsFromEnv(fs, func(name string) (string, bool) {
+		v, found := env[name]
+		return v, found
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the flag given on the command line takes precedence.
+	if *format != formatSARIF {
+		t.Fatalf("want=%s, got=%s", formatSARIF, *format)
+	}
+	if *failOn != failOnNone || !*quiet {
+		t.Fatalf("want=%s and %v, got=%s and %v", failOnNone, true, *failOn, *quiet)
+	}
+	if expected := (stringsFlag{"/usr/bin", "/usr/lib64"}); !reflect.DeepEqual(includeOnly, expected) {
+		t.Fatalf("want=%v, got=%v", expected, includeOnly)
 	}
 
-	for _, test := range tests {
-		actual := Normalize(test.input)
-		if actual != test.expected {
-			t.Fatalf(`want="%s", got="%s" for input "%s"`, test.expected, actual, test.input)
-			t.Fail()
-		}
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.Bool("quiet", false, "")
+	env = map[string]string{"HMF_QUIET": "maybe"}
+	if err := setFlagsFromEnv(fs, func(name string) (string, bool) {
+		v, found := env[name]
+		return v, found
+	}); err == nil || !strings.Contains(err.Error(), "HMF_QUIET") {
+		t.Fatalf("expected an error naming HMF_QUIET, got %v", err)
 	}
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+// The outcomes of a scan that label its metrics.
+const (
+	outcomePass  = "pass"
+	outcomeFail  = "fail"
+	outcomeError = "error"
+)
+
+var outcomes = []string{outcomePass, outcomeFail, outcomeError}
+
+// scanDurationBuckets are the upper bounds, in seconds, of the buckets of the
+// scan duration histogram.
+var scanDurationBuckets = []float64{1, 5, 10, 30, 60, 120, 300, 600, 1800}
+
+// scanMetrics records the outcome of each scan served by -serve, and writes
+// them in the Prometheus text exposition format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+type scanMetrics struct {
+	// failing reports whether a report fails the scan, as it decides the exit
+	// code of a scan that is not served.
+	failing func(*scan.Report) bool
+	mu      sync.Mutex
+	// durations holds the durations of the scans with each outcome, whose
+	// count is the number of those scans.
+	durations map[string]*histogram
+	// disallowed is the number of disallowed modifications found by every
+	// scan.
+	disallowed int
+}
+
+// histogram counts observations in buckets with the upper bounds of
+// scanDurationBuckets.
+type histogram struct {
+	// buckets counts the observations no larger than the bound of each
+	// bucket, but larger than that of the one before it.
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// newScanMetrics returns the scanMetrics of scans whose outcome is fail if
+// failing reports their report does.
+func newScanMetrics(failing func(*scan.Report) bool) *scanMetrics {
+	m := &scanMetrics{failing: failing, durations: map[string]*histogram{}}
+	for _, outcome := range outcomes {
+		m.durations[outcome] = &histogram{buckets: make([]uint64, len(scanDurationBuckets))}
+	}
+	return m
+}
+
+// observe records a scan that took d, and returned report, or err if it
+// failed.
+func (m *scanMetrics) observe(report *scan.Report, err error, d time.Duration) {
+	outcome := outcomeError
+	if err == nil {
+		outcome = outcomePass
+		if m.failing(report) {
+			outcome = outcomeFail
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.durations[outcome]
+	seconds := d.Seconds()
+	for i, bound := range scanDurationBuckets {
+		if seconds <= bound {
+			h.buckets[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+	if report != nil {
+		m.disallowed += len(report.DisallowedModifications)
+	}
+}
+
+// ServeHTTP writes the metrics.
+func (m *scanMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	fmt.Fprintln(b, "# HELP hasmodifiedfiles_scans_total Scans performed, by outcome: pass, fail if its results fail the scan as they would with -fail-on any, or error if the scan could not complete.")
+	fmt.Fprintln(b, "# TYPE hasmodifiedfiles_scans_total counter")
+	for _, outcome := range outcomes {
+		fmt.Fprintf(b, "hasmodifiedfiles_scans_total{outcome=%q} %d\n", outcome, m.durations[outcome].count)
+	}
+
+	fmt.Fprintln(b, "# HELP hasmodifiedfiles_disallowed_modifications_total Disallowed modifications found by the scans performed.")
+	fmt.Fprintln(b, "# TYPE hasmodifiedfiles_disallowed_modifications_total counter")
+	fmt.Fprintf(b, "hasmodifiedfiles_disallowed_modifications_total %d\n", m.disallowed)
+
+	fmt.Fprintln(b, "# HELP hasmodifiedfiles_scan_duration_seconds How long scans took, by outcome.")
+	fmt.Fprintln(b, "# TYPE hasmodifiedfiles_scan_duration_seconds histogram")
+	for _, outcome := range outcomes {
+		h := m.durations[outcome]
+		var cumulative uint64
+		for i, bound := range scanDurationBuckets {
+			cumulative += h.buckets[i]
+			fmt.Fprintf(b, "hasmodifiedfiles_scan_duration_seconds_bucket{outcome=%q,le=%q} %d\n", outcome, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(b, "hasmodifiedfiles_scan_duration_seconds_bucket{outcome=%q,le=\"+Inf\"} %d\n", outcome, h.count)
+		fmt.Fprintf(b, "hasmodifiedfiles_scan_duration_seconds_sum{outcome=%q} %s\n", outcome, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(b, "hasmodifiedfiles_scan_duration_seconds_count{outcome=%q} %d\n", outcome, h.count)
+	}
+}
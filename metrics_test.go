@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestScanMetrics(t *testing.T) {
+	m := newScanMetrics(failingReport(emptyPackageDBPass))
+	m.observe(&scan.Report{DisallowedModifications: map[string][]string{}}, nil, 2*time.Second)
+	m.observe(&scan.Report{DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:abc"}, "usr/bin/bar": {"sha256:abc"}}}, nil, 45*time.Second)
+	m.observe(nil, errors.New("pull failed"), 500*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	for _, expected := range []string{
+		`hasmodifiedfiles_scans_total{outcome="pass"} 1`,
+		`hasmodifiedfiles_scans_total{outcome="fail"} 1`,
+		`hasmodifiedfiles_scans_total{outcome="error"} 1`,
+		`hasmodifiedfiles_disallowed_modifications_total 2`,
+		`hasmodifiedfiles_scan_duration_seconds_bucket{outcome="pass",le="1"} 0`,
+		`hasmodifiedfiles_scan_duration_seconds_bucket{outcome="pass",le="5"} 1`,
+		`hasmodifiedfiles_scan_duration_seconds_bucket{outcome="fail",le="30"} 0`,
+		`hasmodifiedfiles_scan_duration_seconds_bucket{outcome="fail",le="60"} 1`,
+		`hasmodifiedfiles_scan_duration_seconds_bucket{outcome="fail",le="+Inf"} 1`,
+		`hasmodifiedfiles_scan_duration_seconds_sum{outcome="fail"} 45`,
+		`hasmodifiedfiles_scan_duration_seconds_count{outcome="error"} 1`,
+	} {
+		if !strings.Contains(rec.Body.String(), expected+"\n") {
+			t.Fatalf("want=%s, got=%s", expected, rec.Body.String())
+		}
+	}
+}
+
+func TestScanMetricsEmptyPackageDB(t *testing.T) {
+	for result, expected := range map[string]string{
+		emptyPackageDBPass: `hasmodifiedfiles_scans_total{outcome="pass"} 1`,
+		emptyPackageDBFail: `hasmodifiedfiles_scans_total{outcome="fail"} 1`,
+	} {
+		m := newScanMetrics(failingReport(result))
+		m.observe(&scan.Report{EmptyPackageDB: true}, nil, time.Second)
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		if !strings.Contains(rec.Body.String(), expected+"\n") {
+			t.Fatalf("want=%s, got=%s for %s", expected, rec.Body.String(), result)
+		}
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+// jsonResult is the document written by the json format.
+type jsonResult struct {
+	Tool                    buildInfo              `json:"tool"`
+	Reference               string                 `json:"reference"`
+	ImageDigest             string                 `json:"imageDigest"`
+	PackageManager          string                 `json:"packageManager"`
+	RPMDBLayer              string                 `json:"rpmdbLayer"`
+	RPMDBRemovedBy          string                 `json:"rpmdbRemovedBy,omitempty"`
+	EmptyPackageDB          bool                   `json:"emptyPackageDB,omitempty"`
+	SkippedLayers           int                    `json:"skippedLayers,omitempty"`
+	Metadata                scan.Metadata          `json:"metadata"`
+	Coverage                scan.Coverage          `json:"coverage"`
+	Summary                 scan.Summary           `json:"summary"`
+	Packages                []scan.PackageCount    `json:"packages"`
+	DisallowedModifications []scan.Modification    `json:"disallowedModifications"`
+	Advisories              []scan.Modification    `json:"advisories,omitempty"`
+	SuspiciousEntries       []scan.SuspiciousEntry `json:"suspiciousEntries,omitempty"`
+	Comparison              *jsonComparison        `json:"comparison,omitempty"`
+}
+
+// jsonComparison is the comparison with the baseline image, if any.
+type jsonComparison struct {
+	// Reference is the baseline container reference.
+	Reference string `json:"reference"`
+	scan.Comparison
+}
+
+// writeJSON writes the result of scanning ref to w as a single JSON document,
+// including its comparison with a baseline if it is not nil.
+func writeJSON(w io.Writer, ref string, report *scan.Report, comparison *jsonComparison) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(newJSONResult(ref, report, comparison))
+}
+
+// newJSONResult returns the jsonResult of scanning ref.
+func newJSONResult(ref string, report *scan.Report, comparison *jsonComparison) jsonResult {
+	return jsonResult{
+		Tool:                    currentBuild(),
+		Reference:               ref,
+		ImageDigest:             report.ImageDigest,
+		PackageManager:          report.PackageManager,
+		RPMDBLayer:              report.RPMDBLayerDigest,
+		RPMDBRemovedBy:          report.RPMDBRemovedBy,
+		EmptyPackageDB:          report.EmptyPackageDB,
+		SkippedLayers:           report.SkippedLayers,
+		Metadata:                report.Metadata,
+		Coverage:                report.Coverage,
+		Summary:                 report.Summary(),
+		Packages:                report.PackageCounts(),
+		DisallowedModifications: report.Modifications(),
+		Advisories:              report.Advisories,
+		SuspiciousEntries:       report.SuspiciousEntries,
+		Comparison:              comparison,
+	}
+}
+
+// jsonFileMap is the document written by the json format in the filemap mode.
+type jsonFileMap struct {
+	Reference string `json:"reference"`
+	*scan.InstalledFiles
+}
+
+// writeFileMapJSON writes the files installed in the image ref to w as a
+// single JSON document.
+func writeFileMapJSON(w io.Writer, ref string, files *scan.InstalledFiles) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(jsonFileMap{Reference: ref, InstalledFiles: files})
+}
+
+// writeFileMap writes each installed file in files to w, sorted, on its own
+// line with the package that owns it.
+func writeFileMap(w io.Writer, files *scan.InstalledFiles) error {
+	paths := make([]string, 0, len(files.Files))
+	for path := range files.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", path, files.Files[path]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logComparison logs the modifications added and removed since the baseline
+// in comparison, along with the number unchanged.
+func logComparison(logger scan.Logger, comparison *jsonComparison) {
+	logger.Log("Compared with", comparison.Reference+":", len(comparison.Added), "added,", len(comparison.Removed), "removed,", len(comparison.Unchanged), "unchanged disallowed modifications")
+	for _, mod := range comparison.Added {
+		logger.Log("\t+", mod.File, "owned by", mod.Package, "was", describeKind(mod.Kind))
+	}
+	for _, mod := range comparison.Removed {
+		logger.Log("\t-", mod.File, "owned by", mod.Package, "was", describeKind(mod.Kind))
+	}
+}
+
+// topPackages is the number of packages listed by packageTable.
+const topPackages = 10
+
+var (
+	tableHeader = lipgloss.NewStyle().Bold(true).Underline(true)
+	tableCell   = lipgloss.NewStyle().PaddingRight(2)
+)
+
+// packageTable renders the topPackages packages in counts, which are sorted
+// from most to fewest modifications, as a table of the top offenders.
+func packageTable(counts []scan.PackageCount) string {
+	shown := counts
+	if len(shown) > topPackages {
+		shown = shown[:topPackages]
+	}
+	names := []string{tableHeader.Render("PACKAGE")}
+	numbers := []string{tableHeader.Render("MODIFICATIONS")}
+	for _, count := range shown {
+		names = append(names, count.Package)
+		numbers = append(numbers, strconv.Itoa(count.Modifications))
+	}
+	table := lipgloss.JoinHorizontal(lipgloss.Top,
+		tableCell.Render(lipgloss.JoinVertical(lipgloss.Left, names...)),
+		lipgloss.JoinVertical(lipgloss.Right, numbers...),
+	)
+	if rest := len(counts) - len(shown); rest > 0 {
+		table += fmt.Sprintf("\n...and %d more packages", rest)
+	}
+	return table
+}
+
+// logLayerModifications logs the disallowed modifications made by each layer,
+// along with the command that created the layer, if known.
+func logLayerModifications(logger scan.Logger, report *scan.Report) {
+	for _, layer := range report.Layers {
+		if len(layer.Disallowed) == 0 {
+			continue
+		}
+		if layer.CreatedBy != "" {
+			logger.Log("Layer", layer.Digest, "created by", layer.CreatedBy)
+		} else {
+			logger.Log("Layer", layer.Digest)
+		}
+		for _, change := range layer.Disallowed {
+			logger.Log("\t", change.Path, "was", describeKind(change.Kind))
+		}
+	}
+}
+
+// summaryLine describes s in a single line for the end of a text run.
+func summaryLine(s scan.Summary) string {
+	result := "PASSED"
+	if !s.Passed {
+		result = "FAILED"
+	}
+	line := fmt.Sprintf("%s: %d disallowed modifications to files from %d packages in %d of %d layers",
+		result, s.DisallowedModifications, s.PackagesAffected, s.LayersWithModifications, s.Layers)
+	if s.SuspiciousEntries > 0 {
+		line += fmt.Sprintf(", and %d suspicious entries", s.SuspiciousEntries)
+	}
+	return line
+}
+
+// logSuspiciousEntries logs the entries of each layer whose names have a ".."
+// element.
+func logSuspiciousEntries(logger scan.Logger, report *scan.Report) {
+	for _, entry := range report.SuspiciousEntries {
+		logger.Log("\t", entry.Name, "in layer", entry.Layer)
+	}
+}
+
+// coverageLine describes how much of the image c shows was checked, naming
+// each layer after the package database that was not checked and why.
+func coverageLine(c scan.Coverage) string {
+	var checked int
+	var skipped []string
+	for _, layer := range c.Layers {
+		switch layer.Status {
+		case scan.LayerChecked, scan.LayerEmpty:
+			checked++
+		case scan.LayerSkipped:
+			skipped = append(skipped, fmt.Sprintf("%s (%s)", layer.Digest, layer.Reason))
+		}
+	}
+	line := fmt.Sprintf("Checked %d of %d layers against %d package-owned files", checked, len(c.Layers), c.BaselineFiles)
+	if len(skipped) > 0 {
+		line += ", skipping " + strings.Join(skipped, ", ")
+	}
+	return line
+}
+
+// absolutePath returns p, a path relative to the root of the image, as an
+// absolute path.
+func absolutePath(p string) string {
+	return path.Join("/", p)
+}
+
+// absoluteKeys returns m with each of its keys, a path to a file of the image,
+// made absolute.
+func absoluteKeys[V any](m map[string]V) map[string]V {
+	if m == nil {
+		return nil
+	}
+	abs := make(map[string]V, len(m))
+	for p, v := range m {
+		abs[absolutePath(p)] = v
+	}
+	return abs
+}
+
+// absolutePaths makes every path to a file of the image in report absolute,
+// once the scan has matched them in the relative form it uses internally.
+func absolutePaths(report *scan.Report) {
+	report.FileMap = absoluteKeys(report.FileMap)
+	report.DisallowedModifications = absoluteKeys(report.DisallowedModifications)
+	for i := range report.Layers {
+		for j := range report.Layers[i].Changes {
+			report.Layers[i].Changes[j].Path = absolutePath(report.Layers[i].Changes[j].Path)
+		}
+		for j := range report.Layers[i].Disallowed {
+			report.Layers[i].Disallowed[j].Path = absolutePath(report.Layers[i].Disallowed[j].Path)
+		}
+	}
+	for i := range report.Advisories {
+		report.Advisories[i].File = absolutePath(report.Advisories[i].File)
+	}
+}
+
+// absoluteFileMap makes every path in files absolute.
+func absoluteFileMap(files *scan.InstalledFiles) {
+	abs := make(map[string]string, len(files.Files))
+	for p, owner := range files.Files {
+		abs[absolutePath(p)] = owner
+	}
+	files.Files = abs
+	if files.Flagged != nil {
+		flagged := make(map[string]rpmdb.FileFlags, len(files.Flagged))
+		for p, flags := range files.Flagged {
+			flagged[absolutePath(p)] = flags
+		}
+		files.Flagged = flagged
+	}
+}
+
+// ndjsonWriter writes each disallowed modification as a JSON object on its own
+// line as soon as the scan finds it.
+type ndjsonWriter struct {
+	enc *json.Encoder
+	// err is the first error writing a modification, after which no more are
+	// written.
+	err error
+}
+
+func newNDJSONWriter(w io.Writer) *ndjsonWriter {
+	return &ndjsonWriter{enc: json.NewEncoder(w)}
+}
+
+// write is a handler for scan.WithModificationHandler.
+func (n *ndjsonWriter) write(mod scan.Modification) {
+	if n.err == nil {
+		n.err = n.enc.Encode(mod)
+	}
+}
+
+// writeArtifacts writes the filemap, the disallowed modifications, and the
+// files modified by each layer as JSON files in dir, creating it if needed.
+func writeArtifacts(dir string, report *scan.Report) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("creating output directory: %w", err)
+	}
+
+	for _, layer := range report.Layers {
+		if err := writeJSONFile(filepath.Join(dir, fmt.Sprintf("modified-in-%s.json", layer.Digest)), layer.Changes); err != nil {
+			return err
+		}
+	}
+	if err := writeJSONFile(filepath.Join(dir, "filemap.json"), report.FileMap); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(dir, "disallowedmods.json"), report.DisallowedModifications)
+}
+
+// reportFile is the document written by -report-file.
+type reportFile struct {
+	Tool      buildInfo `json:"tool"`
+	Reference string    `json:"reference"`
+	*scan.Report
+	Summary scan.Summary  `json:"summary"`
+	Layers  []reportLayer `json:"layers"`
+}
+
+// reportLayer is a layer in a reportFile, whose changes are only written when
+// requested, as there may be many of them.
+type reportLayer struct {
+	scan.LayerResult
+	Changes []scan.Change `json:"changes,omitempty"`
+}
+
+// writeReportFile writes the whole report of scanning ref to the JSON file
+// name, including the changes made by each layer if layerChanges is set.
+func writeReportFile(name, ref string, report *scan.Report, layerChanges bool) error {
+	return writeJSONFile(name, newReportFile(ref, report, layerChanges))
+}
+
+// newReportFile returns the reportFile of scanning ref, including the changes
+// made by each layer if layerChanges is set.
+func newReportFile(ref string, report *scan.Report, layerChanges bool) reportFile {
+	layers := make([]reportLayer, 0, len(report.Layers))
+	for _, layer := range report.Layers {
+		l := reportLayer{LayerResult: layer}
+		if layerChanges {
+			l.Changes = layer.Changes
+		}
+		layers = append(layers, l)
+	}
+	return reportFile{Tool: currentBuild(), Reference: ref, Report: report, Summary: report.Summary(), Layers: layers}
+}
+
+// writeJSONFile writes v to the file name as indented JSON. It is written to a
+// temporary file that is renamed over name once complete, so that a process
+// killed while writing it does not leave a partial file in its place.
+func writeJSONFile(name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return fmt.Errorf("marshaling %s: %w", name, err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), name)
+	}
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	return nil
+}
+
+// describeKind returns kind as it reads after "was" in a sentence.
+func describeKind(kind scan.ChangeKind) string {
+	switch kind {
+	case scan.ChangeSymlink:
+		return "replaced by a symlink"
+	case scan.ChangeDevice:
+		return "replaced by a device node"
+	case scan.ChangeFifo:
+		return "replaced by a fifo"
+	case scan.ChangeDirectory:
+		return "replaced by a directory"
+	case scan.ChangeReplacedDirectory:
+		return "replaced by a file in place of a directory"
+	}
+	return string(kind)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestWriteArtifacts(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "output")
+	report := &scan.Report{
+		FileMap: map[string]string{"usr/bin/foo": "foo-1.0-1"},
+		Layers: []scan.LayerResult{{
+			Digest:     "sha256:abc",
+			Changes:    []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+			Disallowed: []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+		}},
+		DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:abc"}},
+	}
+
+	if err := writeArtifacts(dir, report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"filemap.json", "disallowedmods.json", "modified-in-sha256:abc.json"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Fatalf("expected %s to be written: %v", name, err)
+		}
+	}
+}
+
+func TestWriteJSONSummary(t *testing.T) {
+	report := &scan.Report{
+		LayerCount: 2,
+		FileMap:    map[string]string{"usr/bin/foo": "foo-1.0-1"},
+		Layers: []scan.LayerResult{{
+			Digest:     "sha256:abc",
+			Disallowed: []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+		}},
+		DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:abc"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, "example.com/foo:latest", report, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var result jsonResult
+	if err := json.Unmarshal(buf.Bytes(), &result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := scan.Summary{Layers: 2, LayersWithModifications: 1, DisallowedModifications: 1, PackagesAffected: 1}
+	if result.Summary != expected {
+		t.Fatalf("want=%+v, got=%+v", expected, result.Summary)
+	}
+}
+
+func TestPackageTable(t *testing.T) {
+	var counts []scan.PackageCount
+	for i := 0; i < topPackages+2; i++ {
+		counts = append(counts, scan.PackageCount{Package: fmt.Sprintf("pkg%02d-1.0-1", i), Modifications: 20 - i})
+	}
+
+	table := packageTable(counts)
+	lines := strings.Split(table, "\n")
+	if len(lines) != topPackages+2 {
+		t.Fatalf("want=%d lines, got=%d:\n%s", topPackages+2, len(lines), table)
+	}
+	if !strings.Contains(lines[1], "pkg00-1.0-1") || !strings.HasSuffix(strings.TrimSpace(lines[1]), "20") {
+		t.Fatalf("want=the package with the most modifications first, got=%q", lines[1])
+	}
+	if expected := "...and 2 more packages"; lines[len(lines)-1] != expected {
+		t.Fatalf("want=%q, got=%q", expected, lines[len(lines)-1])
+	}
+}
+
+func TestNDJSONWriter(t *testing.T) {
+	var buf bytes.Buffer
+	n := newNDJSONWriter(&buf)
+	n.write(scan.Modification{File: "usr/bin/foo", Package: "foo-1.0-1", Layer: "sha256:a", Layers: []string{"sha256:a"}, Kind: scan.ChangeModified})
+	n.write(scan.Modification{File: "usr/bin/foo", Package: "foo-1.0-1", Layer: "sha256:b", Layers: []string{"sha256:a", "sha256:b"}, Kind: scan.ChangeDeleted})
+	if n.err != nil {
+		t.Fatalf("unexpected error: %v", n.err)
+	}
+
+	expected := `{"file":"usr/bin/foo","package":"foo-1.0-1","layer":"sha256:a","layers":["sha256:a"],"kind":"modified","packageChanged":false}
+{"file":"usr/bin/foo","package":"foo-1.0-1","layer":"sha256:b","layers":["sha256:a","sha256:b"],"kind":"deleted","packageChanged":false}
+`
+	if buf.String() != expected {
+		t.Fatalf("want=%s, got=%s", expected, buf.String())
+	}
+}
+
+func TestWriteFileMap(t *testing.T) {
+	var buf bytes.Buffer
+	files := &scan.InstalledFiles{Files: map[string]string{
+		"usr/bin/foo":  "foo-1.0-1",
+		"etc/foo.conf": "foo-1.0-1",
+		"usr/bin/bar":  "bar-2.0-1",
+	}}
+	if err := writeFileMap(&buf, files); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "etc/foo.conf\tfoo-1.0-1\nusr/bin/bar\tbar-2.0-1\nusr/bin/foo\tfoo-1.0-1\n"
+	if buf.String() != expected {
+		t.Fatalf("want=%q, got=%q", expected, buf.String())
+	}
+}
+
+func TestWriteReportFile(t *testing.T) {
+	report := &scan.Report{
+		ImageDigest: "sha256:image",
+		LayerCount:  2,
+		FileMap:     map[string]string{"usr/bin/foo": "foo-1.0-1"},
+		Layers: []scan.LayerResult{{
+			Digest:     "sha256:abc",
+			Changes:    []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}, {Path: "opt/bar", Kind: scan.ChangeAdded}},
+			Disallowed: []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+		}},
+		DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:abc"}},
+	}
+
+	for _, layerChanges := range []bool{false, true} {
+		name := filepath.Join(t.TempDir(), "report.json")
+		if err := writeReportFile(name, "example.com/foo:latest", report, layerChanges); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var result struct {
+			Reference               string            `json:"reference"`
+			ImageDigest             string            `json:"imageDigest"`
+			FileMap                 map[string]string `json:"filemap"`
+			Summary                 scan.Summary      `json:"summary"`
+			Layers                  []scan.LayerResult
+			DisallowedModifications map[string][]string `json:"disallowedModifications"`
+		}
+		if err := json.Unmarshal(b, &result); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		if result.Reference != "example.com/foo:latest" || result.ImageDigest != report.ImageDigest {
+			t.Fatalf("want=%s@%s, got=%s@%s", "example.com/foo:latest", report.ImageDigest, result.Reference, result.ImageDigest)
+		}
+		if !reflect.DeepEqual(result.FileMap, report.FileMap) || !reflect.DeepEqual(result.DisallowedModifications, report.DisallowedModifications) {
+			t.Fatalf("want=%v and %v, got=%v and %v", report.FileMap, report.DisallowedModifications, result.FileMap, result.DisallowedModifications)
+		}
+		if result.Summary != report.Summary() {
+			t.Fatalf("want=%+v, got=%+v", report.Summary(), result.Summary)
+		}
+		if len(result.Layers) != 1 || !reflect.DeepEqual(result.Layers[0].Disallowed, report.Layers[0].Disallowed) {
+			t.Fatalf("want=%v, got=%v", report.Layers, result.Layers)
+		}
+		if expected := len(report.Layers[0].Changes); layerChanges != (len(result.Layers[0].Changes) == expected) {
+			t.Fatalf("want=%d changes only when requested, got=%d with layer changes %v", expected, len(result.Layers[0].Changes), layerChanges)
+		}
+	}
+}
+
+func TestAbsolutePaths(t *testing.T) {
+	report := &scan.Report{
+		FileMap: map[string]string{"usr/bin/foo": "foo-1.0-1"},
+		Layers: []scan.LayerResult{{
+			Digest:     "sha256:abc",
+			Changes:    []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}, {Path: ".", Kind: scan.ChangeOpaque}},
+			Disallowed: []scan.Change{{Path: "usr/bin/foo", Kind: scan.ChangeModified}},
+		}},
+		DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:abc"}},
+		Advisories:              []scan.Modification{{File: "etc/foo.conf", Package: "foo-1.0-1"}},
+	}
+	absolutePaths(report)
+
+	expected := &scan.Report{
+		FileMap: map[string]string{"/usr/bin/foo": "foo-1.0-1"},
+		Layers: []scan.LayerResult{{
+			Digest:     "sha256:abc",
+			Changes:    []scan.Change{{Path: "/usr/bin/foo", Kind: scan.ChangeModified}, {Path: "/", Kind: scan.ChangeOpaque}},
+			Disallowed: []scan.Change{{Path: "/usr/bin/foo", Kind: scan.ChangeModified}},
+		}},
+		DisallowedModifications: map[string][]string{"/usr/bin/foo": {"sha256:abc"}},
+		Advisories:              []scan.Modification{{File: "/etc/foo.conf", Package: "foo-1.0-1"}},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Fatalf("want=%+v, got=%+v", expected, report)
+	}
+	if mods := report.Modifications(); len(mods) != 1 || mods[0].File != "/usr/bin/foo" || mods[0].Package != "foo-1.0-1" {
+		t.Fatalf("expected the modification to keep its package, got %v", mods)
+	}
+}
+
+func TestCoverageLine(t *testing.T) {
+	c := scan.Coverage{
+		BaselineFiles: 12,
+		Layers: []scan.LayerCoverage{
+			{Index: 0, Digest: "sha256:aaa", Status: scan.LayerBaseline},
+			{Index: 1, Digest: "sha256:bbb", Status: scan.LayerSkipped, Reason: "only the top 1 layers were checked"},
+			{Index: 2, Digest: "sha256:ccc", Status: scan.LayerChecked},
+		},
+	}
+	expected := "Checked 1 of 3 layers against 12 package-owned files, skipping sha256:bbb (only the top 1 layers were checked)"
+	if actual := coverageLine(c); actual != expected {
+		t.Fatalf("want=%s, got=%s", expected, actual)
+	}
+}
@@ -0,0 +1,135 @@
+package scan
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const apkInstalledPath = "lib/apk/db/installed"
+
+// FindApkDB attempts to extract a valid apk database from layers in the
+// order they are provided, returning the index of the first layer that
+// contains one along with the filemap derived from it. If no layer contains a
+// apk database, this returns ErrApkDBNotFound. Any other error means a layer
+// could not be read.
+func FindApkDB(ctx context.Context, layers []v1.Layer) (int, map[string]string, error) {
+	return findApkDB(ctx, layers, defaultExtractionLimits)
+}
+
+// findApkDB is like FindApkDB, but extracts the database within limits.
+func findApkDB(ctx context.Context, layers []v1.Layer, limits extractionLimits) (int, map[string]string, error) {
+	for i, layer := range layers {
+		filemap, err := extractApkDB(ctx, layer, limits)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			id, _ := layer.Digest()
+			err = fmt.Errorf("extracting apk database from layer %s: %w", id, err)
+			if errors.Is(err, ErrInvalidPackageDB) {
+				return 0, nil, err
+			}
+			return 0, nil, wrap(ErrLayerRead, err)
+		}
+		return i, filemap, nil
+	}
+
+	return 0, nil, ErrApkDBNotFound
+}
+
+// ExtractApkDB reads /lib/apk/db/installed from the archive and builds a map
+// of installed files to the package-version that owns them. If the layer does
+// not contain an apk database, this returns an error of type os.ErrNotExist.
+func ExtractApkDB(ctx context.Context, layer v1.Layer) (map[string]string, error) {
+	return extractApkDB(ctx, layer, defaultExtractionLimits)
+}
+
+// extractApkDB is like ExtractApkDB, but reads the database within limits.
+func extractApkDB(ctx context.Context, layer v1.Layer, limits extractionLimits) (map[string]string, error) {
+	layerReader, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("reading layer contents: %w", err)
+	}
+	defer layerReader.Close()
+
+	tarReader := tar.NewReader(layerReader)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar: %w", err)
+		}
+
+		if header.Typeflag == tar.TypeReg && Normalize(header.Name) == apkInstalledPath {
+			b, err := limits.start().readAll(tarReader, apkInstalledPath)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", apkInstalledPath, err)
+			}
+			return parseApkInstalled(b)
+		}
+	}
+
+	return nil, os.ErrNotExist
+}
+
+// parseApkInstalled builds a filemap from the contents of an apk installed
+// database. Each package is a block of "K:value" records separated by a blank
+// line, where F records name a directory and the R records that follow name
+// files within it. It is an ErrInvalidPackageDB if the database cannot be read
+// in full, rather than omitting the packages after the line that stopped it.
+func parseApkInstalled(b []byte) (map[string]string, error) {
+	m := map[string]string{}
+	var name, version, dir string
+	var paths []string
+	flush := func() {
+		for _, p := range paths {
+			m[p] = fmt.Sprintf("%s-%s", name, version)
+		}
+		name, version, dir, paths = "", "", "", nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "P":
+			name = value
+		case "V":
+			version = value
+		case "F":
+			dir = Normalize(value)
+			paths = append(paths, dir)
+		case "R":
+			paths = append(paths, Normalize(path.Join(dir, value)))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("reading %s: %w", apkInstalledPath, err))
+	}
+	flush()
+
+	return m, nil
+}
@@ -0,0 +1,65 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const testApkInstalled = `C:Q1abc=
+P:musl
+V:1.2.4-r2
+A:x86_64
+F:lib
+R:ld-musl-x86_64.so.1
+a:0:0:755
+Z:Q1def=
+R:libc.musl-x86_64.so.1
+
+C:Q1ghi=
+P:busybox
+V:1.36.1-r5
+F:bin
+R:busybox
+F:etc
+R:securetty
+`
+
+func TestExtractApkDB(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "lib/apk/db/installed", content: testApkInstalled},
+	)
+
+	expected := map[string]string{
+		"lib":                       "musl-1.2.4-r2",
+		"lib/ld-musl-x86_64.so.1":   "musl-1.2.4-r2",
+		"lib/libc.musl-x86_64.so.1": "musl-1.2.4-r2",
+		"bin":                       "busybox-1.36.1-r5",
+		"bin/busybox":               "busybox-1.36.1-r5",
+		"etc":                       "busybox-1.36.1-r5",
+		"etc/securetty":             "busybox-1.36.1-r5",
+	}
+	actual, err := ExtractApkDB(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestFindApkDBLongLine(t *testing.T) {
+	// a line longer than the scanner allows must not hide the packages after
+	// it.
+	installed := "P:long\nT:" + strings.Repeat("x", 128*1024) + "\n\n" + testApkInstalled
+	layers := []v1.Layer{testLayer(t, testEntry{name: "lib/apk/db/installed", content: installed})}
+
+	_, _, err := FindApkDB(context.Background(), layers)
+	if !errors.Is(err, ErrInvalidPackageDB) || errors.Is(err, ErrLayerRead) {
+		t.Fatalf("want=%v, got=%v", ErrInvalidPackageDB, err)
+	}
+}
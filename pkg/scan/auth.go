@@ -0,0 +1,68 @@
+package scan
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/cli/cli/config/types"
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// dockerConfigKeychain resolves credentials from a single docker config file.
+type dockerConfigKeychain struct {
+	cf *configfile.ConfigFile
+}
+
+// NewDockerConfigKeychain returns a keychain that resolves credentials from
+// the docker config file at path rather than from the ambient docker config.
+// Registries without credentials in the file are accessed anonymously.
+func NewDockerConfigKeychain(path string) (authn.Keychain, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening docker config: %w", err)
+	}
+	defer f.Close()
+
+	cf, err := config.LoadFromReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("loading docker config %s: %w", path, err)
+	}
+	return &dockerConfigKeychain{cf: cf}, nil
+}
+
+// Resolve implements authn.Keychain.
+func (k *dockerConfigKeychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	var cfg, empty types.AuthConfig
+	for _, key := range []string{
+		target.String(),
+		target.RegistryStr(),
+	} {
+		// Docker Hub credentials are stored under a legacy key.
+		if key == name.DefaultRegistry {
+			key = authn.DefaultAuthKey
+		}
+
+		var err error
+		cfg, err = k.cf.GetAuthConfig(key)
+		if err != nil {
+			return nil, err
+		}
+		if cfg != empty {
+			break
+		}
+	}
+	if cfg == empty {
+		return authn.Anonymous, nil
+	}
+
+	return authn.FromConfig(authn.AuthConfig{
+		Username:      cfg.Username,
+		Password:      cfg.Password,
+		Auth:          cfg.Auth,
+		IdentityToken: cfg.IdentityToken,
+		RegistryToken: cfg.RegistryToken,
+	}), nil
+}
@@ -0,0 +1,49 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+func TestDockerConfigKeychain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	content := `{"auths": {"quay.io": {"auth": "dXNlcjpwYXNz"}}}`
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keychain, err := NewDockerConfigKeychain(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		input    string
+		expected authn.AuthConfig
+	}{
+		{"quay.io/private/image", authn.AuthConfig{Username: "user", Password: "pass"}},
+		{"registry.example.com/public/image", authn.AuthConfig{}},
+	}
+
+	for _, test := range tests {
+		repo, err := name.NewRepository(test.input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		auth, err := keychain.Resolve(repo)
+		if err != nil {
+			t.Fatalf("unexpected error resolving %s: %v", test.input, err)
+		}
+		actual, err := auth.Authorization()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *actual != test.expected {
+			t.Fatalf("want=%+v, got=%+v for input %s", test.expected, *actual, test.input)
+		}
+	}
+}
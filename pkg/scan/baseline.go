@@ -0,0 +1,72 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+// LoadBaseline reads the installed files of an image from the JSON file at
+// name, as written by the json format of the filemap mode, to be given to
+// WithBaseline. Paths are normalized so that they can be written as absolute
+// or relative paths.
+func LoadBaseline(name string) (*InstalledFiles, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("reading baseline: %w", err)
+	}
+	var files InstalledFiles
+	if err := json.Unmarshal(b, &files); err != nil {
+		return nil, fmt.Errorf("parsing baseline file %s: %w", name, err)
+	}
+	if files.Layer == "" || len(files.Files) == 0 {
+		return nil, fmt.Errorf("baseline file %s does not record the layer of a package database and its files", name)
+	}
+
+	normalized := make(map[string]string, len(files.Files))
+	for p, owner := range files.Files {
+		normalized[Normalize(p)] = owner
+	}
+	files.Files = normalized
+	if files.Flagged != nil {
+		flagged := make(map[string]rpmdb.FileFlags, len(files.Flagged))
+		for p, flags := range files.Flagged {
+			flagged[Normalize(p)] = flags
+		}
+		files.Flagged = flagged
+	}
+	return &files, nil
+}
+
+// baselineDB builds the packageDB for the baseline from the layer of layers it
+// was taken from. The files whose recorded flags include any of the modifiable
+// flags of the scan are omitted from its filemap.
+func (o *options) baselineDB(layers []v1.Layer) (*packageDB, error) {
+	for i, layer := range layers {
+		digest, err := layer.Digest()
+		if err != nil || digest.String() != o.baseline.Layer {
+			continue
+		}
+		filemap := make(map[string]string, len(o.baseline.Files))
+		flagged := make(map[string]rpmdb.FileFlags)
+		for p, owner := range o.baseline.Files {
+			if flags, found := o.baseline.Flagged[p]; found && int32(flags)&o.modifiableFlags > 0 {
+				flagged[p] = flags
+				continue
+			}
+			filemap[p] = owner
+		}
+		o.log.Log("Using the baseline of", len(o.baseline.Files), "files installed by layer", digest)
+		return &packageDB{
+			manager:    o.baseline.PackageManager,
+			layerIndex: i,
+			filemap:    filemap,
+			flagged:    flagged,
+			dirs:       parentDirs(o.baseline.Files),
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: the baseline layer %s is not a layer of the image", ErrInvalidPackageDB, o.baseline.Layer)
+}
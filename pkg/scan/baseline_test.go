@@ -0,0 +1,96 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+func TestLoadBaseline(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "filemap.json")
+	b, err := json.Marshal(map[string]any{
+		"reference":      "quay.io/example/base:latest",
+		"packageManager": PackageManagerRPM,
+		"layer":          "sha256:abc",
+		"files":          map[string]string{"/usr/bin/foo": "foo-1.0-1", "etc/foo.conf": "foo-1.0-1"},
+		"flagged":        map[string]rpmdb.FileFlags{"/etc/foo.conf": rpmdb.FileFlags(rpmdb.RPMFILE_CONFIG)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := LoadBaseline(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &InstalledFiles{
+		PackageManager: PackageManagerRPM,
+		Layer:          "sha256:abc",
+		Files:          map[string]string{"usr/bin/foo": "foo-1.0-1", "etc/foo.conf": "foo-1.0-1"},
+		Flagged:        map[string]rpmdb.FileFlags{"etc/foo.conf": rpmdb.FileFlags(rpmdb.RPMFILE_CONFIG)},
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Fatalf("want=%+v, got=%+v", expected, files)
+	}
+
+	if err := os.WriteFile(name, []byte(`{"usr/bin/foo": "foo-1.0-1"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadBaseline(name); err == nil {
+		t.Fatalf("expected an error for a filemap without a layer")
+	}
+}
+
+func TestScanImageBaseline(t *testing.T) {
+	// the base layer has no package database, so the files can only come
+	// from the baseline.
+	base := testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"}, testEntry{name: "etc/foo.conf", content: "conf"}, testEntry{name: "usr/lib/foo/foo.conf", content: "conf"})
+	digest, _ := base.Digest()
+	baseline := &InstalledFiles{
+		PackageManager: PackageManagerRPM,
+		Layer:          digest.String(),
+		Files:          map[string]string{"usr/bin/foo": "foo-1.0-1", "etc/foo.conf": "foo-1.0-1", "usr/lib/foo/foo.conf": "foo-1.0-1"},
+		Flagged: map[string]rpmdb.FileFlags{
+			"etc/foo.conf":         rpmdb.FileFlags(rpmdb.RPMFILE_CONFIG),
+			"usr/lib/foo/foo.conf": rpmdb.FileFlags(rpmdb.RPMFILE_CONFIG),
+		},
+	}
+	img := testImage(t,
+		base,
+		testLayer(t, testEntry{name: "usr/bin/foo", content: "modified"}, testEntry{name: "etc/foo.conf", content: "modified"}, testEntry{name: "usr/lib/foo/foo.conf", content: "modified"}),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithBaseline(baseline))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{"usr/bin/foo": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+
+	// the recorded flags are filtered by the modifiable flags of the scan.
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithBaseline(baseline), WithModifiableFileFlags(int32(rpmdb.RPMFILE_DOC)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected = map[string][]string{"usr/bin/foo": {report.Layers[0].Digest}, "usr/lib/foo/foo.conf": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+
+	_, err = ScanImage(context.Background(), testImage(t, testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"})), WithOutput(io.Discard), WithBaseline(baseline))
+	if !errors.Is(err, ErrInvalidPackageDB) {
+		t.Fatalf("want=%v, got=%v", ErrInvalidPackageDB, err)
+	}
+}
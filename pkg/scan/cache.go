@@ -0,0 +1,99 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+)
+
+// layerCache stores compressed layer blobs on disk keyed by their digest.
+//
+// The filesystem cache writes a blob as it is read, but a scan stops reading
+// a layer as soon as it has what it needs, which would leave a truncated blob
+// to be served to the next scan. layerCache instead reads each blob to
+// completion when it is first stored, and only serves blobs whose contents
+// match their digest.
+type layerCache struct {
+	cache.Cache
+	// mu guards locks.
+	mu sync.Mutex
+	// locks serialize Put for each digest, so that an image containing the
+	// same layer more than once does not write the same blob concurrently,
+	// while different layers are still stored in parallel.
+	locks map[v1.Hash]*sync.Mutex
+}
+
+func newLayerCache(dir string) *layerCache {
+	return &layerCache{Cache: cache.NewFilesystemCache(dir), locks: map[v1.Hash]*sync.Mutex{}}
+}
+
+// Get returns the cached layer with the digest h, or cache.ErrNotFound.
+func (c *layerCache) Get(h v1.Hash) (v1.Layer, error) {
+	l, err := c.Cache.Get(h)
+	if err != nil {
+		return nil, err
+	}
+	if digest, err := l.Digest(); err != nil || digest != h {
+		// the blob is corrupt or was stored under the wrong key.
+		if err := c.Cache.Delete(h); err != nil && !errors.Is(err, cache.ErrNotFound) {
+			return nil, err
+		}
+		return nil, cache.ErrNotFound
+	}
+	return l, nil
+}
+
+// Put downloads l in full and returns the cached copy of it.
+func (c *layerCache) Put(l v1.Layer) (v1.Layer, error) {
+	digest, err := l.Digest()
+	if err != nil {
+		return nil, err
+	}
+	lock := c.lock(digest)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if cl, err := c.Get(digest); err == nil {
+		return cl, nil
+	}
+
+	if err := c.store(l); err != nil {
+		if err := c.Cache.Delete(digest); err != nil && !errors.Is(err, cache.ErrNotFound) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("caching layer %s: %w", digest, err)
+	}
+	return c.Get(digest)
+}
+
+// lock returns the lock serializing Put for the layer with digest h.
+func (c *layerCache) lock(h v1.Hash) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, found := c.locks[h]
+	if !found {
+		l = &sync.Mutex{}
+		c.locks[h] = l
+	}
+	return l
+}
+
+func (c *layerCache) store(l v1.Layer) error {
+	rl, err := c.Cache.Put(l)
+	if err != nil {
+		return err
+	}
+	rc, err := rl.Compressed()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		rc.Close()
+		return err
+	}
+	return rc.Close()
+}
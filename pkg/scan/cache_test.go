@@ -0,0 +1,110 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+)
+
+func TestLayerCachePartialRead(t *testing.T) {
+	dir := t.TempDir()
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}, testEntry{name: "usr/bin/foo", content: "foo"}),
+	)
+
+	// ExtractApkDB stops reading the layer once it has found the database.
+	layers, err := cache.Image(img, newLayerCache(dir)).Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ExtractApkDB(context.Background(), layers[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	layers, err = cache.Image(img, newLayerCache(dir)).Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{{Path: "lib/apk/db/installed", Kind: ChangeAdded}, {Path: "usr/bin/foo", Kind: ChangeAdded}}
+	actual, err := GenerateChangesFor(context.Background(), layers[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestLayerCacheCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	c := newLayerCache(dir)
+	layer := testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"})
+	digest, err := layer.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Put(layer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected a single cached blob, got %v: %v", entries, err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, entries[0].Name()), []byte("corrupt"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(digest); !errors.Is(err, cache.ErrNotFound) {
+		t.Fatalf("want=%v, got=%v", cache.ErrNotFound, err)
+	}
+}
+
+// gatedLayer signals started when its contents are first read, and only
+// returns them once wait is closed.
+type gatedLayer struct {
+	v1.Layer
+	once    *sync.Once
+	started chan struct{}
+	wait    <-chan struct{}
+}
+
+func (l gatedLayer) Compressed() (io.ReadCloser, error) {
+	l.once.Do(func() { close(l.started) })
+	select {
+	case <-l.wait:
+	case <-time.After(5 * time.Second):
+		return nil, errors.New("the other layer was not stored at the same time")
+	}
+	return l.Layer.Compressed()
+}
+
+func TestLayerCacheParallelPut(t *testing.T) {
+	c := newLayerCache(t.TempDir())
+	aStarted, bStarted := make(chan struct{}), make(chan struct{})
+	layers := []v1.Layer{
+		gatedLayer{testLayer(t, testEntry{name: "usr/bin/a", content: "a"}), &sync.Once{}, aStarted, bStarted},
+		gatedLayer{testLayer(t, testEntry{name: "usr/bin/b", content: "b"}), &sync.Once{}, bStarted, aStarted},
+	}
+
+	// each layer is only stored once the other has started to be.
+	var wg sync.WaitGroup
+	for _, layer := range layers {
+		wg.Add(1)
+		go func(layer v1.Layer) {
+			defer wg.Done()
+			if _, err := c.Put(layer); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(layer)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,34 @@
+package scan
+
+// Comparison is the difference between the disallowed modifications found in
+// two images, such as a candidate build and the last released one.
+type Comparison struct {
+	// Added are the modifications only found in the candidate.
+	Added []Modification `json:"added"`
+	// Removed are the modifications only found in the baseline.
+	Removed []Modification `json:"removed"`
+	// Unchanged are the modifications found in both, as found in the
+	// candidate.
+	Unchanged []Modification `json:"unchanged"`
+}
+
+// Compare returns the difference between the disallowed modifications in
+// baseline and candidate. Modifications are matched by file alone, as the
+// layers that make them, and the versions of the packages that own them,
+// usually differ between builds.
+func Compare(baseline, candidate *Report) Comparison {
+	c := Comparison{Added: []Modification{}, Removed: []Modification{}, Unchanged: []Modification{}}
+	for _, mod := range candidate.Modifications() {
+		if _, found := baseline.DisallowedModifications[mod.File]; found {
+			c.Unchanged = append(c.Unchanged, mod)
+		} else {
+			c.Added = append(c.Added, mod)
+		}
+	}
+	for _, mod := range baseline.Modifications() {
+		if _, found := candidate.DisallowedModifications[mod.File]; !found {
+			c.Removed = append(c.Removed, mod)
+		}
+	}
+	return c
+}
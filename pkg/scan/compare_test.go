@@ -0,0 +1,51 @@
+package scan
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	baseline := &Report{
+		FileMap: map[string]string{
+			"usr/bin/foo": "foo-1.0-1",
+			"usr/bin/bar": "bar-2.0-1",
+		},
+		DisallowedModifications: map[string][]string{
+			"usr/bin/foo": {"sha256:a"},
+			"usr/bin/bar": {"sha256:a"},
+		},
+		Layers: []LayerResult{
+			{Digest: "sha256:a", Disallowed: []Change{
+				{Path: "usr/bin/foo", Kind: ChangeModified},
+				{Path: "usr/bin/bar", Kind: ChangeModified},
+			}},
+		},
+	}
+	candidate := &Report{
+		FileMap: map[string]string{
+			"usr/bin/foo": "foo-1.1-1",
+			"usr/bin/baz": "baz-3.0-1",
+		},
+		DisallowedModifications: map[string][]string{
+			"usr/bin/foo": {"sha256:b"},
+			"usr/bin/baz": {"sha256:b"},
+		},
+		Layers: []LayerResult{
+			{Digest: "sha256:b", Disallowed: []Change{
+				{Path: "usr/bin/foo", Kind: ChangeModified},
+				{Path: "usr/bin/baz", Kind: ChangeDeleted},
+			}},
+		},
+	}
+
+	expected := Comparison{
+		Added:     []Modification{{File: "usr/bin/baz", Package: "baz-3.0-1", Layer: "sha256:b", Layers: []string{"sha256:b"}, Kind: ChangeDeleted}},
+		Removed:   []Modification{{File: "usr/bin/bar", Package: "bar-2.0-1", Layer: "sha256:a", Layers: []string{"sha256:a"}, Kind: ChangeModified}},
+		Unchanged: []Modification{{File: "usr/bin/foo", Package: "foo-1.1-1", Layer: "sha256:b", Layers: []string{"sha256:b"}, Kind: ChangeModified}},
+	}
+	actual := Compare(baseline, candidate)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
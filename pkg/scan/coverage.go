@@ -0,0 +1,76 @@
+package scan
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// The statuses of a layer in the coverage of a scan.
+const (
+	// LayerChecked is a layer whose changes were checked.
+	LayerChecked = "checked"
+	// LayerEmpty is a layer that was checked but changes no files.
+	LayerEmpty = "empty"
+	// LayerBaseline is the layer that contained the package database, or one
+	// before it, whose files are those the later layers are checked against.
+	LayerBaseline = "baseline"
+	// LayerSkipped is a layer after the package database that was not
+	// checked, for the reason given.
+	LayerSkipped = "skipped"
+)
+
+// Coverage records how much of an image a scan checked, so that a complete
+// scan can be told apart from a partial one.
+type Coverage struct {
+	// BaselineFiles is the number of package-owned files the layers were
+	// checked against.
+	BaselineFiles int `json:"baselineFiles"`
+	// ModifiableFiles is the number of package-owned files that were not
+	// checked because of their RPM file flags.
+	ModifiableFiles int `json:"modifiableFiles,omitempty"`
+	// Layers is the coverage of each layer of the image, in order.
+	Layers []LayerCoverage `json:"layers"`
+	// Complete is true if every layer after the package database was
+	// checked.
+	Complete bool `json:"complete"`
+}
+
+// LayerCoverage is whether a layer was checked, and if not, why.
+type LayerCoverage struct {
+	Index  int    `json:"index"`
+	Digest string `json:"digest"`
+	// Status is one of LayerChecked, LayerEmpty, LayerBaseline, or
+	// LayerSkipped.
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// newCoverage returns the coverage of layers, of which the one at dbIndex
+// contained the package database, before any of them were checked.
+func newCoverage(layers []v1.Layer, dbIndex int) Coverage {
+	c := Coverage{Layers: make([]LayerCoverage, len(layers)), Complete: true}
+	for i, layer := range layers {
+		id, _ := layer.Digest()
+		c.Layers[i] = LayerCoverage{Index: i, Digest: id.String()}
+		switch {
+		case i < dbIndex:
+			c.Layers[i].Status, c.Layers[i].Reason = LayerBaseline, "the package database was found in a later layer"
+		case i == dbIndex:
+			c.Layers[i].Status, c.Layers[i].Reason = LayerBaseline, "the layer contained the package database"
+		}
+	}
+	return c
+}
+
+// check records that the layer at i was checked, making changes.
+func (c *Coverage) check(i int, changes []Change) {
+	c.Layers[i].Status, c.Layers[i].Reason = LayerChecked, ""
+	if len(changes) == 0 {
+		c.Layers[i].Status = LayerEmpty
+	}
+}
+
+// skip records that the layer at i was not checked because of reason.
+func (c *Coverage) skip(i int, reason string) {
+	c.Layers[i].Status, c.Layers[i].Reason = LayerSkipped, reason
+	c.Complete = false
+}
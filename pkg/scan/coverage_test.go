@@ -0,0 +1,51 @@
+package scan
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestScanImageCoverage(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "etc/hostname", content: "base"}),
+		testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		testLayer(t, testEntry{name: "usr/bin/bash", content: "modified"}),
+		testLayer(t),
+		testLayer(t, testEntry{name: "etc/motd", content: "modified"}),
+	)
+
+	statuses := func(c Coverage) []string {
+		var s []string
+		for _, layer := range c.Layers {
+			s = append(s, layer.Status)
+		}
+		return s
+	}
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c := report.Coverage
+	expected := []string{LayerBaseline, LayerBaseline, LayerChecked, LayerEmpty, LayerChecked}
+	if actual := statuses(c); !c.Complete || !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v complete, got=%v %v", expected, actual, c.Complete)
+	}
+	if c.BaselineFiles != len(report.FileMap) || c.BaselineFiles == 0 {
+		t.Fatalf("want=%v, got=%v", len(report.FileMap), c.BaselineFiles)
+	}
+
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithTopLayers(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c = report.Coverage
+	expected = []string{LayerBaseline, LayerBaseline, LayerSkipped, LayerSkipped, LayerChecked}
+	if actual := statuses(c); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+	if c.Complete || c.Layers[2].Reason == "" {
+		t.Fatalf("want=an incomplete scan with a reason for each skipped layer, got=%+v", c)
+	}
+}
@@ -0,0 +1,94 @@
+package scan
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash"
+	"io"
+	"sort"
+
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+// digestHashes are the hash functions for the RPM file digest algorithms that
+// can be verified, keyed by the algorithm's name.
+var digestHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha224": sha256.New224,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// fileDigest is the digest of a file's content as recorded in the RPMDB.
+type fileDigest struct {
+	algorithm string
+	hex       string
+}
+
+// installedFileDigests returns the recorded digest of each regular file
+// installed by pkglist whose digest algorithm can be verified.
+func installedFileDigests(pkglist []*rpmdb.PackageInfo) (map[string]fileDigest, error) {
+	digests := map[string]fileDigest{}
+	for _, pkg := range pkglist {
+		algorithm := pkg.DigestAlgorithm
+		if algorithm == 0 {
+			// packages that do not record an algorithm use rpm's original
+			// default.
+			algorithm = rpmdb.PGPHASHALGO_MD5
+		}
+		if _, found := digestHashes[algorithm.String()]; !found {
+			continue
+		}
+
+		files, err := pkg.InstalledFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file.Digest != "" {
+				digests[Normalize(file.Path)] = fileDigest{algorithm: algorithm.String(), hex: file.Digest}
+			}
+		}
+	}
+	return digests, nil
+}
+
+// digestAlgorithms returns the algorithms used by digests, sorted.
+func digestAlgorithms(digests map[string]fileDigest) []string {
+	seen := map[string]struct{}{}
+	var algorithms []string
+	for _, d := range digests {
+		if _, found := seen[d.algorithm]; !found {
+			seen[d.algorithm] = struct{}{}
+			algorithms = append(algorithms, d.algorithm)
+		}
+	}
+	sort.Strings(algorithms)
+	return algorithms
+}
+
+// digestContent reads r to completion and returns its hex digest with each of
+// algorithms, keyed by algorithm.
+func digestContent(r io.Reader, algorithms []string) (map[string]string, error) {
+	hashes := make(map[string]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, 0, len(algorithms))
+	for _, algorithm := range algorithms {
+		h := digestHashes[algorithm]()
+		hashes[algorithm] = h
+		writers = append(writers, h)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return nil, err
+	}
+
+	digests := make(map[string]string, len(hashes))
+	for algorithm, h := range hashes {
+		digests[algorithm] = hex.EncodeToString(h.Sum(nil))
+	}
+	return digests, nil
+}
@@ -0,0 +1,72 @@
+package scan
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+// testFooSHA256 is the sha256 digest of "foo".
+const testFooSHA256 = "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func TestInstalledFileDigests(t *testing.T) {
+	pkglist := []*rpmdb.PackageInfo{
+		{
+			Name:            "foo",
+			DigestAlgorithm: rpmdb.PGPHASHALGO_SHA256,
+			BaseNames:       []string{"bin", "foo"},
+			DirIndexes:      []int32{0, 1},
+			DirNames:        []string{"/usr/", "/usr/bin/"},
+			FileDigests:     []string{"", testFooSHA256},
+		},
+		{
+			Name:        "old",
+			BaseNames:   []string{"old"},
+			DirIndexes:  []int32{0},
+			DirNames:    []string{"/usr/bin/"},
+			FileDigests: []string{"d41d8cd98f00b204e9800998ecf8427e"},
+		},
+	}
+
+	expected := map[string]fileDigest{
+		"usr/bin/foo": {algorithm: "sha256", hex: testFooSHA256},
+		"usr/bin/old": {algorithm: "md5", hex: "d41d8cd98f00b204e9800998ecf8427e"},
+	}
+	actual, err := installedFileDigests(pkglist)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "usr/bin/foo", content: "foo"},
+		testEntry{name: "usr/bin/bar", content: "modified"},
+	)
+	changes, _, err := readChanges(context.Background(), layer, []string{"sha256"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	o := newOptions(WithOutput(io.Discard), WithDigestVerification(true))
+	db := &packageDB{
+		filemap: map[string]string{"usr/bin/foo": "foo-1.0-1", "usr/bin/bar": "foo-1.0-1"},
+		digests: map[string]fileDigest{
+			"usr/bin/foo": {algorithm: "sha256", hex: testFooSHA256},
+			"usr/bin/bar": {algorithm: "sha256", hex: testFooSHA256},
+		},
+	}
+	expected := map[string]bool{"usr/bin/foo": true, "usr/bin/bar": false}
+	for _, change := range changes {
+		change.Kind = ChangeModified
+		if actual := o.unchanged(db, change); actual != expected[change.Path] {
+			t.Fatalf("want=%v, got=%v for %s", expected[change.Path], actual, change.Path)
+		}
+	}
+}
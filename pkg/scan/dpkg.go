@@ -0,0 +1,200 @@
+package scan
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const (
+	dpkgStatusPath = "var/lib/dpkg/status"
+	dpkgInfoDir    = "var/lib/dpkg/info"
+)
+
+// FindDpkgDB attempts to extract a valid dpkg database from layers in the
+// order they are provided, returning the index of the first layer that
+// contains one along with the filemap derived from it. If no layer contains a
+// dpkg database, this returns ErrDpkgDBNotFound. Any other error means a layer
+// could not be read.
+func FindDpkgDB(ctx context.Context, layers []v1.Layer) (int, map[string]string, error) {
+	return findDpkgDB(ctx, layers, defaultExtractionLimits)
+}
+
+// findDpkgDB is like FindDpkgDB, but extracts the database within limits.
+func findDpkgDB(ctx context.Context, layers []v1.Layer, limits extractionLimits) (int, map[string]string, error) {
+	for i, layer := range layers {
+		filemap, err := extractDpkgDB(ctx, layer, limits)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			id, _ := layer.Digest()
+			err = fmt.Errorf("extracting dpkg database from layer %s: %w", id, err)
+			if errors.Is(err, ErrInvalidPackageDB) {
+				return 0, nil, err
+			}
+			return 0, nil, wrap(ErrLayerRead, err)
+		}
+		return i, filemap, nil
+	}
+
+	return 0, nil, ErrDpkgDBNotFound
+}
+
+// ExtractDpkgDB reads /var/lib/dpkg/status and the /var/lib/dpkg/info/*.list
+// files from the archive and builds a map of installed files to the
+// package_version that owns them, separated by an underscore as in the name
+// of a .deb, as it cannot appear in either while both may contain dashes. Files listed as conffiles are omitted, as
+// they are expected to be modified. If the layer does not contain a dpkg
+// status file, this returns an error of type os.ErrNotExist.
+func ExtractDpkgDB(ctx context.Context, layer v1.Layer) (map[string]string, error) {
+	return extractDpkgDB(ctx, layer, defaultExtractionLimits)
+}
+
+// extractDpkgDB is like ExtractDpkgDB, but reads the database within limits.
+func extractDpkgDB(ctx context.Context, layer v1.Layer, limits extractionLimits) (map[string]string, error) {
+	layerReader, err := layer.Uncompressed()
+	if err != nil {
+		return nil, fmt.Errorf("reading layer contents: %w", err)
+	}
+	defer layerReader.Close()
+
+	var status []byte
+	lists := map[string][]byte{}
+	conffiles := map[string][]byte{}
+
+	extracted := limits.start()
+	tarReader := tar.NewReader(layerReader)
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading tar: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := Normalize(header.Name)
+		dirname, basename := path.Split(name)
+		switch {
+		case name == dpkgStatusPath:
+			status, err = extracted.readAll(tarReader, name)
+		case path.Clean(dirname) == dpkgInfoDir && strings.HasSuffix(basename, ".list"):
+			lists[strings.TrimSuffix(basename, ".list")], err = extracted.readAll(tarReader, name)
+		case path.Clean(dirname) == dpkgInfoDir && strings.HasSuffix(basename, ".conffiles"):
+			conffiles[strings.TrimSuffix(basename, ".conffiles")], err = extracted.readAll(tarReader, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", name, err)
+		}
+	}
+
+	if status == nil {
+		return nil, os.ErrNotExist
+	}
+
+	pkgs, err := parseDpkgStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]string{}
+	for _, pkg := range pkgs {
+		// info files are named for the package, optionally qualified with
+		// its architecture for multi-arch packages.
+		key := pkg.name
+		if _, ok := lists[key]; !ok {
+			key = pkg.name + ":" + pkg.arch
+		}
+
+		excluded := map[string]struct{}{}
+		for _, file := range splitLines(conffiles[key]) {
+			excluded[Normalize(file)] = struct{}{}
+		}
+		for _, file := range splitLines(lists[key]) {
+			file = Normalize(file)
+			if _, found := excluded[file]; found || file == "." {
+				continue
+			}
+			m[file] = fmt.Sprintf("%s_%s", pkg.name, pkg.version)
+		}
+	}
+	return m, nil
+}
+
+type dpkgPackage struct {
+	name    string
+	version string
+	arch    string
+}
+
+// parseDpkgStatus returns the installed packages in a dpkg status file. It is
+// an ErrInvalidPackageDB if the file cannot be read in full, as with a line
+// longer than the scanner allows, rather than omitting the packages after it.
+func parseDpkgStatus(b []byte) ([]dpkgPackage, error) {
+	var pkgs []dpkgPackage
+	var pkg dpkgPackage
+	var installed bool
+	flush := func() {
+		if installed && pkg.name != "" {
+			pkgs = append(pkgs, pkg)
+		}
+		pkg, installed = dpkgPackage{}, false
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			flush()
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok || strings.HasPrefix(line, " ") {
+			// continuation of a multi-line field
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "Package":
+			pkg.name = value
+		case "Version":
+			pkg.version = value
+		case "Architecture":
+			pkg.arch = value
+		case "Status":
+			installed = strings.HasSuffix(value, " installed")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("reading %s: %w", dpkgStatusPath, err))
+	}
+	flush()
+
+	return pkgs, nil
+}
+
+func splitLines(b []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
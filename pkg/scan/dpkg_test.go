@@ -0,0 +1,77 @@
+package scan
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+const testDpkgStatus = `Package: base-files
+Status: install ok installed
+Architecture: amd64
+Version: 12.4+deb12u1
+Description: Debian base system miscellaneous files
+ This package contains the basic filesystem hierarchy.
+
+Package: libc6
+Status: install ok installed
+Architecture: amd64
+Multi-Arch: same
+Version: 2.36-9
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+`
+
+func TestExtractDpkgDB(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "var/lib/dpkg/", typeflag: tar.TypeDir},
+		testEntry{name: "var/lib/dpkg/status", content: testDpkgStatus},
+		testEntry{name: "var/lib/dpkg/info/base-files.list", content: "/.\n/etc\n/etc/debian_version\n/usr/lib/os-release\n"},
+		testEntry{name: "var/lib/dpkg/info/base-files.conffiles", content: "/etc/debian_version\n"},
+		testEntry{name: "var/lib/dpkg/info/libc6:amd64.list", content: "/usr/lib/x86_64-linux-gnu/libc.so.6\n"},
+		testEntry{name: "var/lib/dpkg/info/removed.list", content: "/usr/bin/removed\n"},
+	)
+
+	expected := map[string]string{
+		"etc":                                "base-files_12.4+deb12u1",
+		"usr/lib/os-release":                 "base-files_12.4+deb12u1",
+		"usr/lib/x86_64-linux-gnu/libc.so.6": "libc6_2.36-9",
+	}
+	actual, err := ExtractDpkgDB(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestExtractDpkgDBMissing(t *testing.T) {
+	layer := testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"})
+
+	_, err := ExtractDpkgDB(context.Background(), layer)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want=%v, got=%v", os.ErrNotExist, err)
+	}
+}
+
+func TestFindDpkgDBLongLine(t *testing.T) {
+	// a line longer than the scanner allows must not hide the packages after
+	// it.
+	status := "Package: long\nDescription: " + strings.Repeat("x", 2*1024*1024) + "\n\n" + testDpkgStatus
+	layers := []v1.Layer{testLayer(t, testEntry{name: "var/lib/dpkg/status", content: status})}
+
+	_, _, err := FindDpkgDB(context.Background(), layers)
+	if !errors.Is(err, ErrInvalidPackageDB) || errors.Is(err, ErrLayerRead) {
+		t.Fatalf("want=%v, got=%v", ErrInvalidPackageDB, err)
+	}
+}
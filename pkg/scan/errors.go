@@ -0,0 +1,71 @@
+package scan
+
+import "errors"
+
+var (
+	// ErrImagePull is returned when the image or its manifest could not be
+	// retrieved.
+	ErrImagePull = errors.New("unable to pull image")
+	// ErrInvalidReference is returned, along with ErrImagePull, when the
+	// container reference cannot be parsed or uses an unsupported transport.
+	ErrInvalidReference = errors.New("invalid container reference")
+	// ErrPlatformRequired is returned when the reference to be pulled is an
+	// image index and no platform was given to select an image from it.
+	ErrPlatformRequired = errors.New("reference is an image index, a platform must be specified")
+	// ErrDigestMismatch is returned when the manifest pulled for a reference
+	// pinned to a digest does not have that digest.
+	ErrDigestMismatch = errors.New("pulled manifest does not match the digest of the reference")
+	// ErrRPMDBNotFound is returned when no layer of the image contains a
+	// valid RPMDB.
+	ErrRPMDBNotFound = errors.New("unable to find valid RPMDB in any layer of the image")
+	// ErrDpkgDBNotFound is returned when no layer of the image contains a
+	// valid dpkg database.
+	ErrDpkgDBNotFound = errors.New("unable to find valid dpkg database in any layer of the image")
+	// ErrApkDBNotFound is returned when no layer of the image contains a
+	// valid apk database.
+	ErrApkDBNotFound = errors.New("unable to find valid apk database in any layer of the image")
+	// ErrNoPackageDB is returned when no layer of the image contains a
+	// database for any supported package manager.
+	ErrNoPackageDB = errors.New("unable to find a valid package database in any layer of the image")
+	// ErrEmptyPackageDB is returned when the package database that was found
+	// lists no packages, such as one that was only initialized or is corrupt,
+	// unless WithEmptyPackageDB allows it.
+	ErrEmptyPackageDB = errors.New("package database found but contained no packages")
+	// ErrInvalidPackageDB is returned when the package database that was
+	// found lists no files, cannot be parsed, or cannot be used to scan the
+	// image.
+	ErrInvalidPackageDB = errors.New("unable to use the package database of the image")
+	// ErrLayerRead is returned when the contents of a layer could not be read.
+	ErrLayerRead = errors.New("unable to read layer")
+	// ErrExtractionLimit is returned, along with ErrLayerRead, when the
+	// package database in a layer is larger than the extraction limits allow.
+	ErrExtractionLimit = errors.New("extraction limit exceeded")
+)
+
+// Error associates an underlying error with one of the exported error kinds,
+// such as ErrImagePull or ErrLayerRead, so that callers can use errors.Is
+// against the kind while still unwrapping to the cause, or errors.As to find
+// the kind of an error.
+type Error struct {
+	// Kind is one of the exported errors of this package.
+	Kind error
+	// Err is the cause.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of e.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func wrap(kind, err error) error {
+	return &Error{Kind: kind, Err: err}
+}
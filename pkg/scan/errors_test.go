@@ -0,0 +1,42 @@
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestWrappedErrors(t *testing.T) {
+	err := wrap(ErrLayerRead, io.ErrUnexpectedEOF)
+	if !errors.Is(err, ErrLayerRead) {
+		t.Fatalf("expected %v to be %v", err, ErrLayerRead)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected %v to wrap %v", err, io.ErrUnexpectedEOF)
+	}
+	if errors.Is(err, ErrImagePull) {
+		t.Fatalf("did not expect %v to be %v", err, ErrImagePull)
+	}
+}
+
+func TestErrorAs(t *testing.T) {
+	err := fmt.Errorf("scanning: %w", wrap(ErrImagePull, io.ErrUnexpectedEOF))
+	var scanErr *Error
+	if !errors.As(err, &scanErr) {
+		t.Fatalf("expected %v to be an *Error", err)
+	}
+	if scanErr.Kind != ErrImagePull || scanErr.Err != io.ErrUnexpectedEOF {
+		t.Fatalf("want=%v, got=%v", ErrImagePull, scanErr.Kind)
+	}
+}
+
+func TestScanInvalidReference(t *testing.T) {
+	for _, ref := range []string{"ftp://example.com/image", "Invalid Reference"} {
+		_, err := Scan(context.Background(), ref, WithOutput(io.Discard))
+		if !errors.Is(err, ErrInvalidReference) || !errors.Is(err, ErrImagePull) {
+			t.Fatalf("want=%v, got=%v for %s", ErrInvalidReference, err, ref)
+		}
+	}
+}
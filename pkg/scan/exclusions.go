@@ -0,0 +1,208 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Exclusions lists the directories and paths that may be modified without
+// being reported.
+type Exclusions struct {
+	// Directories excludes a directory and any file contained in that
+	// directory.
+	Directories []string `json:"directories"`
+	// Paths excludes the paths as written, or any path matching a glob. See
+	// CompilePathPatterns for the supported syntax.
+	Paths []string `json:"paths"`
+}
+
+// DefaultExclusions returns the built-in exclusions.
+func DefaultExclusions() Exclusions {
+	return Exclusions{
+		Directories: []string{
+			"etc",
+			"var",
+			"run",
+		},
+		Paths: []string{
+			"etc/resolv.conf",
+			"etc/hostname",
+			// etc and etc/ are both required as both can present the directory
+			// in a tarball. Same goes for other directories.
+			"etc",
+			"etc/",
+			"run",
+			"run/",
+		},
+	}
+}
+
+// LoadExclusions reads exclusions from the JSON file at name. Entries are
+// normalized so that they can be written as absolute or relative paths.
+func LoadExclusions(name string) (Exclusions, error) {
+	var e Exclusions
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return e, fmt.Errorf("reading exclusions: %w", err)
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, fmt.Errorf("parsing exclusions file %s: %w", name, err)
+	}
+
+	for i, d := range e.Directories {
+		e.Directories[i] = Normalize(d)
+	}
+	for i, p := range e.Paths {
+		e.Paths[i] = Normalize(p)
+	}
+	if _, err := CompilePathPatterns(e.Paths); err != nil {
+		return e, fmt.Errorf("parsing exclusions file %s: %w", name, err)
+	}
+	return e, nil
+}
+
+// Merge returns the exclusions in e augmented with those in other.
+func (e Exclusions) Merge(other Exclusions) Exclusions {
+	return Exclusions{
+		Directories: append(append([]string{}, e.Directories...), other.Directories...),
+		Paths:       append(append([]string{}, e.Paths...), other.Paths...),
+	}
+}
+
+// DirectoryIsExcluded excludes a directory and any file contained in that directory.
+// It compiles the directories on every call, so checking many paths against
+// the same exclusions is better done with CompileDirectoryPatterns and
+// DirectoryIsExcludedBy.
+func (e Exclusions) DirectoryIsExcluded(s string) bool {
+	return DirectoryIsExcludedBy(s, CompileDirectoryPatterns(e.Directories))
+}
+
+// DirectoryPattern is a compiled directory exclusion.
+type DirectoryPattern struct {
+	dir    string
+	prefix string
+}
+
+// CompileDirectoryPatterns compiles directory exclusions for use with
+// DirectoryIsExcludedBy. A directory only contains the paths under it, and not
+// those of its siblings that share its name as a prefix, such as usr/lib64 for
+// usr/lib.
+func CompileDirectoryPatterns(dirs []string) []DirectoryPattern {
+	compiled := make([]DirectoryPattern, 0, len(dirs))
+	for _, d := range dirs {
+		d = path.Clean(d)
+		compiled = append(compiled, DirectoryPattern{dir: d, prefix: d + "/"})
+	}
+	return compiled
+}
+
+// DirectoryIsExcludedBy checks if s is, or is contained in, any of the
+// directories in patterns.
+func DirectoryIsExcludedBy(s string, patterns []DirectoryPattern) bool {
+	for _, p := range patterns {
+		if strings.HasPrefix(s, p.prefix) || p.dir == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PathPattern is a compiled path exclusion.
+type PathPattern struct {
+	literal  string
+	segments []string
+}
+
+// CompilePathPatterns compiles path exclusions for use with PathIsExcluded.
+//
+// A pattern that contains any of the metacharacters *, ?, [ or \ is matched
+// as a glob, and any other pattern must match the path exactly as written.
+// Globs are matched one path segment at a time using the syntax of
+// path.Match, so * and ? never match a /. A segment that is exactly ** matches
+// zero or more whole segments, so **/*.pyc matches a .pyc file in any
+// directory, including the root. A ** that is part of a longer segment, as in
+// foo**, behaves like a single *. Because [ is a metacharacter, a literal path
+// containing one must escape it with a backslash.
+func CompilePathPatterns(patterns []string) ([]PathPattern, error) {
+	compiled := make([]PathPattern, 0, len(patterns))
+	for _, p := range patterns {
+		if !strings.ContainsAny(p, `*?[\`) {
+			compiled = append(compiled, PathPattern{literal: p})
+			continue
+		}
+
+		segments := strings.Split(Normalize(p), "/")
+		for _, segment := range segments {
+			if _, err := path.Match(segment, ""); err != nil {
+				return nil, fmt.Errorf("invalid path pattern %q: %w", p, err)
+			}
+		}
+		compiled = append(compiled, PathPattern{segments: segments})
+	}
+	return compiled, nil
+}
+
+// PathIsExcluded checks if s is excluded by any of patterns.
+func PathIsExcluded(s string, patterns []PathPattern) bool {
+	for _, p := range patterns {
+		if p.segments == nil {
+			if p.literal == s {
+				return true
+			}
+			continue
+		}
+
+		if matchSegments(p.segments, s, false) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchSegments reports whether the remaining path segments in name match the
+// glob segments in pattern. The segments are cut from name as they are
+// matched rather than split up front, so that matching does not allocate. end
+// is set once no segments remain, which differs from a single empty segment.
+func matchSegments(pattern []string, name string, end bool) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for {
+				if matchSegments(pattern[1:], name, end) {
+					return true
+				}
+				if end {
+					return false
+				}
+				_, rest, more := strings.Cut(name, "/")
+				name, end = rest, !more
+			}
+		}
+
+		if end {
+			return false
+		}
+		segment, rest, more := strings.Cut(name, "/")
+		if ok, _ := path.Match(pattern[0], segment); !ok {
+			return false
+		}
+		pattern, name, end = pattern[1:], rest, !more
+	}
+
+	return end
+}
+
+// Normalize will clean a path of extraneous characters like ./, //, etc. and
+// strip a leading slash. E.g. /foo/../baz --> baz. Paths in a layer are always
+// slash-separated, so this does not depend on the host's path separator.
+func Normalize(s string) string {
+	// for the root path, return the root path.
+	if s == "/" {
+		return s
+	}
+	return path.Clean(strings.TrimPrefix(s, "/"))
+}
@@ -0,0 +1,217 @@
+package scan
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectoryExclusion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"etc/myconfig.txt", true},
+		{"opt/myconfig", false},
+		{"var", true},
+		{"run/foo/bar/baz", true},
+		{"etcetera/x", false},
+		{"variable", false},
+	}
+
+	patterns := CompileDirectoryPatterns(DefaultExclusions().Directories)
+	for _, test := range tests {
+		actual := DefaultExclusions().DirectoryIsExcluded(test.input)
+		if actual != test.expected {
+			t.Fatalf("want=%t, got=%t for input %s", test.expected, actual, test.input)
+			t.Fail()
+		}
+		if actual := DirectoryIsExcludedBy(test.input, patterns); actual != test.expected {
+			t.Fatalf("want=%t, got=%t for compiled input %s", test.expected, actual, test.input)
+		}
+	}
+}
+
+func TestDirectoryExclusionSiblings(t *testing.T) {
+	patterns := CompileDirectoryPatterns([]string{"usr/lib", "opt/app/"})
+	for input, expected := range map[string]bool{
+		"usr/lib":             true,
+		"usr/lib/libc.so.6":   true,
+		"usr/lib64/libc.so.6": false,
+		"usr/libexec/foo":     false,
+		"opt/app":             true,
+		"opt/app/bin/app":     true,
+		"opt/application":     false,
+	} {
+		if actual := DirectoryIsExcludedBy(input, patterns); actual != expected {
+			t.Fatalf("want=%t, got=%t for input %s", expected, actual, input)
+		}
+	}
+}
+
+func TestFileExclusion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"etc/myconfig.txt", false},
+		{"etc/resolv.conf", true},
+	}
+
+	for _, test := range tests {
+		actual := PathIsExcluded(test.input, mustCompilePathPatterns(t, DefaultExclusions().Paths))
+		if actual != test.expected {
+			t.Fatalf("want=%t, got=%t for input %s", test.expected, actual, test.input)
+			t.Fail()
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"/my/path", "my/path"},
+		{"./this/that", "this/that"},
+		{"this/that/../foo", "this/foo"},
+		{"this/../that", "that"},
+		{"/", "/"},
+		{"./usr//lib/../bin/foo", "usr/bin/foo"},
+	}
+
+	for _, test := range tests {
+		actual := Normalize(test.input)
+		if actual != test.expected {
+			t.Fatalf(`want="%s", got="%s" for input "%s"`, test.expected, actual, test.input)
+			t.Fail()
+		}
+	}
+}
+
+func TestLoadExclusions(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "exclusions.json")
+	content := `{"directories": ["/opt/app/"], "paths": ["usr/bin/foo"]}`
+	if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadExclusions(name)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		exclusions Exclusions
+		input      string
+		expected   bool
+	}{
+		{loaded, "opt/app/bin/app", true},
+		{loaded, "usr/bin/foo", true},
+		{loaded, "etc/myconfig.txt", false},
+		{DefaultExclusions().Merge(loaded), "opt/app/bin/app", true},
+		{DefaultExclusions().Merge(loaded), "etc/myconfig.txt", true},
+	}
+
+	for _, test := range tests {
+		actual := PathIsExcluded(test.input, mustCompilePathPatterns(t, test.exclusions.Paths)) || test.exclusions.DirectoryIsExcluded(test.input)
+		if actual != test.expected {
+			t.Fatalf("want=%t, got=%t for input %s", test.expected, actual, test.input)
+		}
+	}
+}
+
+func TestGlobExclusion(t *testing.T) {
+	patterns := mustCompilePathPatterns(t, []string{
+		"etc/hostname",
+		"var/log/*",
+		"**/*.pyc",
+		"usr/lib/python3.*/site-packages/*.pyc",
+		"opt/**/cache",
+	})
+
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"etc/hostname", true},
+		{"etc/hostname.bak", false},
+		{"var/log/messages", true},
+		{"var/log/nested/messages", false},
+		{"foo.pyc", true},
+		{"usr/lib/python3.9/site-packages/foo.pyc", true},
+		{"usr/lib/python3.9/site-packages/foo.py", false},
+		{"opt/cache", true},
+		{"opt/app/data/cache", true},
+		{"opt/app/data/cache/file", false},
+	}
+
+	for _, test := range tests {
+		actual := PathIsExcluded(test.input, patterns)
+		if actual != test.expected {
+			t.Fatalf("want=%t, got=%t for input %s", test.expected, actual, test.input)
+		}
+	}
+}
+
+func TestCompilePathPatternsInvalid(t *testing.T) {
+	if _, err := CompilePathPatterns([]string{"usr/lib/[a-"}); err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
+
+func mustCompilePathPatterns(t *testing.T, patterns []string) []PathPattern {
+	t.Helper()
+	compiled, err := CompilePathPatterns(patterns)
+	if err != nil {
+		t.Fatalf("compiling %v: %v", patterns, err)
+	}
+	return compiled
+}
+
+func BenchmarkDirectoryExclusion(b *testing.B) {
+	exclusions := DefaultExclusions().Merge(Exclusions{Directories: []string{"usr/share/doc", "usr/share/man", "opt/app/cache", "srv"}})
+	paths := []string{"usr/bin/bash", "usr/share/doc/bash/README", "etc/passwd", "usr/lib64/libc.so.6", "opt/app/bin/app"}
+
+	b.Run("uncompiled", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for _, p := range paths {
+				exclusions.DirectoryIsExcluded(p)
+			}
+		}
+	})
+	b.Run("compiled", func(b *testing.B) {
+		b.ReportAllocs()
+		patterns := CompileDirectoryPatterns(exclusions.Directories)
+		for i := 0; i < b.N; i++ {
+			for _, p := range paths {
+				DirectoryIsExcludedBy(p, patterns)
+			}
+		}
+	})
+}
+
+func BenchmarkPathExclusion(b *testing.B) {
+	paths := []string{"usr/bin/bash", "usr/share/doc/bash/README", "etc/hostname", "usr/lib64/libc.so.6", "opt/app/lib/app.pyc"}
+	for _, test := range []struct {
+		name     string
+		patterns []string
+	}{
+		{"literal", DefaultExclusions().Paths},
+		{"glob", append(DefaultExclusions().Paths, "**/*.pyc", "usr/share/doc/*/README")},
+	} {
+		patterns, err := CompilePathPatterns(test.patterns)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(test.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, p := range paths {
+					PathIsExcluded(p, patterns)
+				}
+			}
+		})
+	}
+}
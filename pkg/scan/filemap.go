@@ -0,0 +1,144 @@
+package scan
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+// FileMapOptions controls which installed files BuildFileMap includes.
+type FileMapOptions struct {
+	// ExcludeModifiable omits files with any of ModifiableFlags, as they are
+	// expected to be modified.
+	ExcludeModifiable bool
+	// ModifiableFlags are the RPM file flags that make a file modifiable.
+	ModifiableFlags int32
+}
+
+// DefaultFileMapOptions omits files with any of the DefaultModifiableFlags.
+func DefaultFileMapOptions() FileMapOptions {
+	return FileMapOptions{ExcludeModifiable: true, ModifiableFlags: DefaultModifiableFlags}
+}
+
+// BuildFileMap gets a map of installed filenames that have been cleaned
+// of extra slashes, dotslashes, and leading slashes, to the
+// name-version-release of the package that owns them. It also returns the
+// files that were omitted because of their file flags, mapped to those flags.
+func BuildFileMap(pkgs []*rpmdb.PackageInfo, opts FileMapOptions) (map[string]string, map[string]rpmdb.FileFlags, error) {
+	m := map[string]string{}
+	flagged := map[string]rpmdb.FileFlags{}
+	for _, pkg := range pkgs {
+		files, err := pkg.InstalledFiles()
+		if err != nil {
+			return m, flagged, err
+		}
+
+		for _, file := range files {
+			if opts.ExcludeModifiable && int32(file.Flags)&opts.ModifiableFlags > 0 {
+				// It is one of the ok flags. Skip it.
+				flagged[Normalize(file.Path)] = file.Flags
+				continue
+			}
+			m[Normalize(file.Path)] = packageNVR(pkg)
+		}
+	}
+	return m, flagged, nil
+}
+
+// rpmFileTypeMask and rpmFileTypeDir are the bits of the mode of an installed
+// RPM file giving its type, and their value for a directory.
+const (
+	rpmFileTypeMask = 0170000
+	rpmFileTypeDir  = 0040000
+)
+
+// installedDirs returns the installed files of pkgs that are directories,
+// cleaned as in BuildFileMap.
+func installedDirs(pkgs []*rpmdb.PackageInfo) (map[string]struct{}, error) {
+	dirs := map[string]struct{}{}
+	for _, pkg := range pkgs {
+		files, err := pkg.InstalledFiles()
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			if file.Mode&rpmFileTypeMask == rpmFileTypeDir {
+				dirs[Normalize(file.Path)] = struct{}{}
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// parentDirs returns the files in filemap that are directories because another
+// of its files is under them, for the databases that do not record the type
+// of a file.
+func parentDirs(filemap map[string]string) map[string]struct{} {
+	dirs := map[string]struct{}{}
+	for file := range filemap {
+		for dir := path.Dir(file); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			if _, found := dirs[dir]; found {
+				// its parents have already been looked for.
+				break
+			}
+			if _, owned := filemap[dir]; owned {
+				dirs[dir] = struct{}{}
+			}
+		}
+	}
+	return dirs
+}
+
+// packageNVR returns the name-version-release of pkg, which identifies the
+// package that owns a file in a filemap.
+func packageNVR(pkg *rpmdb.PackageInfo) string {
+	return fmt.Sprintf("%s-%s-%s", pkg.Name, pkg.Version, pkg.Release)
+}
+
+// DefaultModifiableFlags are the RPM file flags that make a file modifiable
+// unless configured otherwise. A %ghost file is owned by its package but not
+// shipped in it, as it is expected to be created or modified at runtime, such
+// as a log file or a cache, so whatever a layer writes to it is not the
+// package's content.
+const DefaultModifiableFlags = rpmdb.RPMFILE_CONFIG |
+	rpmdb.RPMFILE_DOC |
+	rpmdb.RPMFILE_GHOST |
+	rpmdb.RPMFILE_LICENSE |
+	rpmdb.RPMFILE_MISSINGOK |
+	rpmdb.RPMFILE_README
+
+// fileFlagNames maps the names accepted by ParseFileFlags to their flags.
+var fileFlagNames = map[string]int32{
+	"config":    rpmdb.RPMFILE_CONFIG,
+	"doc":       rpmdb.RPMFILE_DOC,
+	"icon":      rpmdb.RPMFILE_ICON,
+	"missingok": rpmdb.RPMFILE_MISSINGOK,
+	"noreplace": rpmdb.RPMFILE_NOREPLACE,
+	"specfile":  rpmdb.RPMFILE_SPECFILE,
+	"ghost":     rpmdb.RPMFILE_GHOST,
+	"license":   rpmdb.RPMFILE_LICENSE,
+	"readme":    rpmdb.RPMFILE_README,
+	"pubkey":    rpmdb.RPMFILE_PUBKEY,
+	"artifact":  rpmdb.RPMFILE_ARTIFACT,
+}
+
+// ParseFileFlags parses a comma separated list of RPM file flag names, such as
+// "config,doc,ghost", into a bitmask. The names are those of the %config,
+// %doc, %ghost, etc. directives in a spec file.
+func ParseFileFlags(s string) (int32, error) {
+	var flags int32
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		flag, ok := fileFlagNames[name]
+		if !ok {
+			return 0, fmt.Errorf("unknown rpm file flag %q", name)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
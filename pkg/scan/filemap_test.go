@@ -0,0 +1,80 @@
+package scan
+
+import (
+	"reflect"
+	"testing"
+
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+func TestParseFileFlags(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int32
+	}{
+		{"config,doc,ghost,license,missingok,readme", DefaultModifiableFlags},
+		{"config, GHOST", rpmdb.RPMFILE_CONFIG | rpmdb.RPMFILE_GHOST},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseFileFlags(test.input)
+		if err != nil {
+			t.Fatalf("unexpected error for input %s: %v", test.input, err)
+		}
+		if actual != test.expected {
+			t.Fatalf("want=%d, got=%d for input %s", test.expected, actual, test.input)
+		}
+	}
+
+	if _, err := ParseFileFlags("config,bogus"); err == nil {
+		t.Fatal("expected an error for an unknown flag")
+	}
+}
+
+func TestBuildFileMap(t *testing.T) {
+	pkgs := []*rpmdb.PackageInfo{{
+		Name:       "foo",
+		Version:    "1.0",
+		Release:    "1",
+		BaseNames:  []string{"foo", "foo.conf", "README", "foo.log"},
+		DirIndexes: []int32{0, 1, 2, 3},
+		DirNames:   []string{"/usr/bin/", "//etc/", "/usr/share/doc/foo/", "/var/log/"},
+		FileFlags:  []int32{0, rpmdb.RPMFILE_CONFIG, rpmdb.RPMFILE_DOC, rpmdb.RPMFILE_GHOST},
+	}}
+
+	tests := []struct {
+		opts            FileMapOptions
+		expected        map[string]string
+		expectedFlagged map[string]rpmdb.FileFlags
+	}{
+		{
+			FileMapOptions{},
+			map[string]string{"usr/bin/foo": "foo-1.0-1", "etc/foo.conf": "foo-1.0-1", "usr/share/doc/foo/README": "foo-1.0-1", "var/log/foo.log": "foo-1.0-1"},
+			map[string]rpmdb.FileFlags{},
+		},
+		{
+			DefaultFileMapOptions(),
+			map[string]string{"usr/bin/foo": "foo-1.0-1"},
+			map[string]rpmdb.FileFlags{"etc/foo.conf": rpmdb.FileFlags(rpmdb.RPMFILE_CONFIG), "usr/share/doc/foo/README": rpmdb.FileFlags(rpmdb.RPMFILE_DOC), "var/log/foo.log": rpmdb.FileFlags(rpmdb.RPMFILE_GHOST)},
+		},
+		{
+			FileMapOptions{ExcludeModifiable: true, ModifiableFlags: rpmdb.RPMFILE_DOC},
+			map[string]string{"usr/bin/foo": "foo-1.0-1", "etc/foo.conf": "foo-1.0-1", "var/log/foo.log": "foo-1.0-1"},
+			map[string]rpmdb.FileFlags{"usr/share/doc/foo/README": rpmdb.FileFlags(rpmdb.RPMFILE_DOC)},
+		},
+	}
+
+	for _, test := range tests {
+		actual, flagged, err := BuildFileMap(pkgs, test.opts)
+		if err != nil {
+			t.Fatalf("unexpected error for %+v: %v", test.opts, err)
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("want=%v, got=%v for %+v", test.expected, actual, test.opts)
+		}
+		if !reflect.DeepEqual(flagged, test.expectedFlagged) {
+			t.Fatalf("want=%v, got=%v for %+v", test.expectedFlagged, flagged, test.opts)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package scan
+
+import (
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// layerCommands returns the command that created each of the n layers of img,
+// as recorded in the created_by of its history, or nil if the history cannot
+// be matched to the layers. History entries for instructions that created no
+// layer, such as ENV, are skipped, and the history is only matched if one
+// entry remains for each layer, as images built by some tools record no
+// history or history for only some of their layers.
+func layerCommands(img v1.Image, n int) []string {
+	cfg, err := img.ConfigFile()
+	if err != nil || cfg == nil {
+		return nil
+	}
+	commands := make([]string, 0, n)
+	for _, h := range cfg.History {
+		if !h.EmptyLayer {
+			commands = append(commands, h.CreatedBy)
+		}
+	}
+	if len(commands) != n {
+		return nil
+	}
+	return commands
+}
@@ -0,0 +1,86 @@
+package scan
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+// withHistory returns img with history as the history in its config.
+func withHistory(t *testing.T, img v1.Image, history ...v1.History) v1.Image {
+	t.Helper()
+
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.History = history
+	img, err = mutate.ConfigFile(img, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func TestLayerCommands(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	tests := []struct {
+		history  []v1.History
+		expected []string
+	}{
+		{
+			[]v1.History{
+				{CreatedBy: "ADD rootfs.tar /"},
+				{CreatedBy: "ENV PATH=/bin", EmptyLayer: true},
+				{CreatedBy: "RUN sed -i s/foo/bar/ /bin/busybox"},
+				{CreatedBy: "CMD [\"/bin/sh\"]", EmptyLayer: true},
+			},
+			[]string{"ADD rootfs.tar /", "RUN sed -i s/foo/bar/ /bin/busybox"},
+		},
+		{
+			[]v1.History{{CreatedBy: "ADD rootfs.tar /"}},
+			nil,
+		},
+		{nil, nil},
+	}
+
+	for _, test := range tests {
+		actual := layerCommands(withHistory(t, img, test.history...), 2)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("want=%q, got=%q for %v", test.expected, actual, test.history)
+		}
+	}
+}
+
+func TestScanImageCreatedBy(t *testing.T) {
+	img := withHistory(t, testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	),
+		v1.History{CreatedBy: "ADD rootfs.tar /"},
+		v1.History{CreatedBy: "ENV PATH=/bin", EmptyLayer: true},
+		v1.History{CreatedBy: "RUN sed -i s/foo/bar/ /bin/busybox"},
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "RUN sed -i s/foo/bar/ /bin/busybox"
+	if len(report.Layers) != 1 || report.Layers[0].CreatedBy != expected {
+		t.Fatalf("want=%q, got=%v", expected, report.Layers)
+	}
+	mods := report.Modifications()
+	if len(mods) != 1 || mods[0].CreatedBy != expected {
+		t.Fatalf("want=%q, got=%v", expected, mods)
+	}
+}
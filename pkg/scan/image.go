@@ -0,0 +1,259 @@
+package scan
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/cache"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// Prefixes of references to images stored locally rather than in a registry.
+const (
+	OCILayoutPrefix     = "oci-layout://"
+	DockerArchivePrefix = "docker-archive://"
+	// ContainerExportPrefix is a tarball of the filesystem of a container,
+	// as written by docker export, which is read as a single layer.
+	ContainerExportPrefix = "container-export://"
+)
+
+// Prefixes of references in the transport syntax of skopeo, so that the
+// references used with it can be scanned as they are. A reference prefixed
+// with DockerTransportPrefix is pulled from a registry, and the others are
+// read from the local filesystem like OCILayoutPrefix and DockerArchivePrefix.
+const (
+	DockerTransportPrefix        = "docker://"
+	OCITransportPrefix           = "oci:"
+	DirTransportPrefix           = "dir:"
+	DockerArchiveTransportPrefix = "docker-archive:"
+)
+
+// supportedTransports lists the prefixes accepted by loadImage for errors.
+var supportedTransports = strings.Join([]string{
+	DockerTransportPrefix, OCITransportPrefix, DirTransportPrefix, DockerArchiveTransportPrefix, OCILayoutPrefix, DockerArchivePrefix, ContainerExportPrefix,
+}, ", ")
+
+// IsLocalReference reports whether ref is read from the local filesystem
+// rather than pulled from a registry, as the references prefixed with
+// OCILayoutPrefix, DockerArchivePrefix, ContainerExportPrefix, or a skopeo
+// transport other than DockerTransportPrefix are.
+func IsLocalReference(ref string) bool {
+	for _, prefix := range []string{OCILayoutPrefix, DockerArchivePrefix, ContainerExportPrefix, OCITransportPrefix, DirTransportPrefix, DockerArchiveTransportPrefix} {
+		if strings.HasPrefix(ref, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsupportedTransports are other transports of skopeo, which, unlike a URL
+// scheme, cannot otherwise be told apart from a registry reference.
+var unsupportedTransports = []string{"containers-storage:", "docker-daemon:", "oci-archive:"}
+
+// urlScheme matches a reference that starts with a URL scheme.
+var urlScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*://`)
+
+// loadImage resolves ref to an image. References prefixed with
+// OCILayoutPrefix, DockerArchivePrefix, or one of the skopeo transport
+// prefixes other than DockerTransportPrefix are read from the local
+// filesystem, and anything else is pulled from a registry. A prefix that is
+// not supported is an error.
+func (o *options) loadImage(ctx context.Context, ref string) (v1.Image, error) {
+	switch {
+	case strings.HasPrefix(ref, OCILayoutPrefix):
+		return loadOCILayout(strings.TrimPrefix(ref, OCILayoutPrefix))
+	case strings.HasPrefix(ref, DockerArchivePrefix):
+		return crane.Load(strings.TrimPrefix(ref, DockerArchivePrefix))
+	case strings.HasPrefix(ref, ContainerExportPrefix):
+		return loadContainerExport(strings.TrimPrefix(ref, ContainerExportPrefix))
+	case strings.HasPrefix(ref, DockerArchiveTransportPrefix):
+		return crane.Load(transportPath(strings.TrimPrefix(ref, DockerArchiveTransportPrefix)))
+	case strings.HasPrefix(ref, OCITransportPrefix):
+		return loadOCILayout(transportPath(strings.TrimPrefix(ref, OCITransportPrefix)))
+	case strings.HasPrefix(ref, DirTransportPrefix):
+		return loadOCILayout(strings.TrimPrefix(ref, DirTransportPrefix))
+	case strings.HasPrefix(ref, DockerTransportPrefix):
+		ref = strings.TrimPrefix(ref, DockerTransportPrefix)
+	case urlScheme.MatchString(ref):
+		return nil, fmt.Errorf("%w: unsupported transport in %s, one of: %s", ErrInvalidReference, ref, supportedTransports)
+	}
+	for _, transport := range unsupportedTransports {
+		if strings.HasPrefix(ref, transport) {
+			return nil, fmt.Errorf("%w: unsupported transport in %s, one of: %s", ErrInvalidReference, ref, supportedTransports)
+		}
+	}
+
+	keychain := o.keychain
+	switch {
+	case keychain != nil:
+	case o.dockerConfig != "":
+		var err error
+		keychain, err = NewDockerConfigKeychain(o.dockerConfig)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		keychain = authn.DefaultKeychain
+	}
+
+	craneOpts := []crane.Option{crane.WithAuthFromKeychain(keychain), crane.WithContext(ctx)}
+	if o.platform != nil {
+		craneOpts = append(craneOpts, crane.WithPlatform(o.platform))
+	}
+	if o.insecure {
+		craneOpts = append(craneOpts, crane.Insecure)
+	}
+	transport, err := o.transport()
+	if err != nil {
+		return nil, err
+	}
+	craneOpts = append(craneOpts, crane.WithTransport(transport))
+	img, err := pull(ref, o.platform != nil, craneOpts...)
+	if err != nil {
+		return nil, err
+	}
+	if o.cacheDir != "" {
+		img = cache.Image(img, newLayerCache(o.cacheDir))
+	}
+	return img, nil
+}
+
+// transport returns the transport registries are reached with. Unless it is
+// configured otherwise, this is the default transport, which honors the
+// HTTPS_PROXY and HTTP_PROXY environment variables, retrying transient errors.
+func (o *options) transport() (http.RoundTripper, error) {
+	var transport http.RoundTripper = remote.DefaultTransport
+	if o.insecure || o.proxy != nil || o.caCertFile != "" {
+		t := remote.DefaultTransport.(*http.Transport).Clone()
+		if o.proxy != nil {
+			t.Proxy = http.ProxyURL(o.proxy)
+		}
+		t.TLSClientConfig = &tls.Config{}
+		if o.caCertFile != "" {
+			pem, err := os.ReadFile(o.caCertFile)
+			if err != nil {
+				return nil, fmt.Errorf("reading CA certificates: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no CA certificates found in %s", o.caCertFile)
+			}
+			t.TLSClientConfig.RootCAs = pool
+		}
+		t.TLSClientConfig.InsecureSkipVerify = o.insecure
+		transport = t
+	}
+
+	if o.maxRetries > 0 {
+		transport = &retryTransport{inner: transport, maxRetries: o.maxRetries, backoff: o.retryBackoff}
+	}
+	return transport, nil
+}
+
+// pull fetches the image at ref, verifying that its manifest has the digest
+// ref is pinned to, if any. If ref is an image index, the image for the
+// platform in opts is selected from it, unless hasPlatform is false, in which
+// case ErrPlatformRequired is returned along with the available platforms
+// rather than guessing.
+func pull(ref string, hasPlatform bool, opts ...crane.Option) (v1.Image, error) {
+	o := crane.GetOptions(opts...)
+	r, err := name.ParseReference(ref, o.Name...)
+	if err != nil {
+		return nil, wrap(ErrInvalidReference, fmt.Errorf("parsing reference %q: %w", ref, err))
+	}
+	desc, err := remote.Get(r, o.Remote...)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyDigest(r, desc.Digest); err != nil {
+		return nil, err
+	}
+	if !hasPlatform && desc.MediaType.IsIndex() {
+		idx, err := desc.ImageIndex()
+		if err != nil {
+			return nil, err
+		}
+		manifest, err := idx.IndexManifest()
+		if err != nil {
+			return nil, err
+		}
+		var platforms []string
+		for _, m := range manifest.Manifests {
+			if m.Platform != nil {
+				platforms = append(platforms, m.Platform.String())
+			}
+		}
+		return nil, fmt.Errorf("%w, one of: %s", ErrPlatformRequired, strings.Join(platforms, ", "))
+	}
+	return desc.Image()
+}
+
+// verifyDigest returns ErrDigestMismatch if ref is pinned to a digest other
+// than pulled, the digest of the manifest that was pulled for it.
+func verifyDigest(ref name.Reference, pulled v1.Hash) error {
+	d, ok := ref.(name.Digest)
+	if !ok || d.DigestStr() == pulled.String() {
+		return nil
+	}
+	return fmt.Errorf("%w: %s was pinned, but %s was pulled", ErrDigestMismatch, d.DigestStr(), pulled)
+}
+
+// loadOCILayout reads the only image in the OCI image layout at path.
+// transportPath returns the path in the skopeo reference s, which may be
+// followed by a colon and the name of the image at the path. As a path is
+// expected to hold a single image, the name is dropped. The path is the
+// shortest part of s before a colon that exists, or s if none does.
+func transportPath(s string) string {
+	for i := range s {
+		if s[i] != ':' {
+			continue
+		}
+		if _, err := os.Stat(s[:i]); err == nil {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// loadContainerExport returns an image whose only layer is the container
+// filesystem in the tarball at path.
+func loadContainerExport(path string) (v1.Image, error) {
+	layer, err := tarball.LayerFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return mutate.AppendLayers(empty.Image, layer)
+}
+
+func loadOCILayout(path string) (v1.Image, error) {
+	idx, err := layout.ImageIndexFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := idx.IndexManifest()
+	if err != nil {
+		return nil, err
+	}
+	if len(manifest.Manifests) != 1 {
+		return nil, fmt.Errorf("expected exactly one image in OCI layout %s, found %d", path, len(manifest.Manifests))
+	}
+
+	return idx.Image(manifest.Manifests[0].Digest)
+}
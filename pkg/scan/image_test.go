@@ -0,0 +1,374 @@
+package scan
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/crane"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// recordingKeychain is an anonymous keychain that records the registries it
+// resolves credentials for.
+type recordingKeychain struct {
+	registries []string
+}
+
+func (k *recordingKeychain) Resolve(r authn.Resource) (authn.Authenticator, error) {
+	k.registries = append(k.registries, r.RegistryStr())
+	return authn.Anonymous, nil
+}
+
+func TestScanLocalImages(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	dir := t.TempDir()
+	layoutPath := filepath.Join(dir, "layout")
+	p, err := layout.Write(layoutPath, empty.Index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.AppendImage(img); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(dir, "image.tar")
+	if err := crane.Save(img, "example.com/image:latest", archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	refs := []string{
+		OCILayoutPrefix + layoutPath,
+		DockerArchivePrefix + archivePath,
+		OCITransportPrefix + layoutPath,
+		OCITransportPrefix + layoutPath + ":latest",
+		DirTransportPrefix + layoutPath,
+		DockerArchiveTransportPrefix + archivePath,
+		DockerArchiveTransportPrefix + archivePath + ":example.com/image:latest",
+	}
+	for _, ref := range refs {
+		report, err := Scan(context.Background(), ref, WithOutput(io.Discard))
+		if err != nil {
+			t.Fatalf("unexpected error scanning %s: %v", ref, err)
+		}
+		if _, found := report.DisallowedModifications["bin/busybox"]; !found {
+			t.Fatalf("expected bin/busybox to be reported for %s, got %v", ref, report.DisallowedModifications)
+		}
+	}
+}
+
+// writeLayerFile writes the uncompressed contents of layer to a file, as a
+// container export would be, returning its path.
+func writeLayerFile(t *testing.T, layer v1.Layer) string {
+	t.Helper()
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(t.TempDir(), "export.tar")
+	if err := os.WriteFile(name, b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestScanContainerExport(t *testing.T) {
+	export := writeLayerFile(t, testLayer(t,
+		testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)},
+		testEntry{name: "usr/bin/bash", content: "modified"},
+		testEntry{name: "opt/app", content: "new"},
+	))
+
+	report, err := Scan(context.Background(), ContainerExportPrefix+export, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.NoModifiablePossible || len(report.Layers) != 1 {
+		t.Fatalf("expected the export to be checked as a single layer, got %v", report.Layers)
+	}
+	kinds := map[string]ChangeKind{}
+	for _, mod := range report.Modifications() {
+		kinds[mod.File] = mod.Kind
+	}
+	if kinds["usr/bin/bash"] != ChangeModified {
+		t.Fatalf("want=%s, got=%s for usr/bin/bash", ChangeModified, kinds["usr/bin/bash"])
+	}
+	// every other installed file is missing from the export.
+	if len(kinds) < 2 {
+		t.Fatalf("expected the missing installed files to be reported as deleted, got %v", kinds)
+	}
+	for file, kind := range kinds {
+		if file != "usr/bin/bash" && kind != ChangeDeleted {
+			t.Fatalf("want=%s, got=%s for %s", ChangeDeleted, kind, file)
+		}
+	}
+
+	export = writeLayerFile(t, testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}))
+	if _, err := Scan(context.Background(), ContainerExportPrefix+export, WithOutput(io.Discard)); err == nil {
+		t.Fatal("expected an export without an rpm database to be an error")
+	}
+}
+
+func TestScanUnsupportedTransport(t *testing.T) {
+	for _, ref := range []string{"ftp://example.com/image", "containers-storage:example.com/image:latest"} {
+		_, err := Scan(context.Background(), ref, WithOutput(io.Discard))
+		if err == nil || !strings.Contains(err.Error(), "unsupported transport") || !strings.Contains(err.Error(), DockerTransportPrefix) {
+			t.Fatalf("expected the supported transports to be listed for %s, got %v", ref, err)
+		}
+		if !errors.Is(err, ErrImagePull) {
+			t.Fatalf("want=%v, got=%v", ErrImagePull, err)
+		}
+	}
+}
+
+func TestIsLocalReference(t *testing.T) {
+	for ref, expected := range map[string]bool{
+		"quay.io/example/image:latest":        false,
+		"docker://quay.io/example/image":      false,
+		"oci-layout:///path/to/layout":        true,
+		"docker-archive:///path/to/image.tar": true,
+		"container-export:///path/to/fs.tar":  true,
+		"oci:/path/to/layout:latest":          true,
+		"dir:/path/to/layout":                 true,
+		"docker-archive:/path/to/image.tar":   true,
+	} {
+		if actual := IsLocalReference(ref); actual != expected {
+			t.Fatalf("want=%v, got=%v for %s", expected, actual, ref)
+		}
+	}
+}
+
+func TestScanImageIndex(t *testing.T) {
+	amd64 := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+	arm64 := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "etc/securetty", content: "modified"}),
+	)
+	idx := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "http://") + "/example/image:latest"
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.WriteIndex(r, idx); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Scan(context.Background(), ref, WithOutput(io.Discard))
+	if !errors.Is(err, ErrPlatformRequired) {
+		t.Fatalf("want=%v, got=%v", ErrPlatformRequired, err)
+	}
+	if !strings.Contains(err.Error(), "linux/amd64, linux/arm64") {
+		t.Fatalf("expected the available platforms to be listed, got %v", err)
+	}
+
+	report, err := Scan(context.Background(), ref, WithOutput(io.Discard), WithPlatform(&v1.Platform{OS: "linux", Architecture: "arm64"}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, found := report.DisallowedModifications["bin/busybox"]; found {
+		t.Fatalf("expected the linux/arm64 image to be scanned, got %v", report.DisallowedModifications)
+	}
+}
+
+func TestScanResolvesDigest(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+	digest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	repo := strings.TrimPrefix(server.URL, "http://") + "/example/image"
+	if err := crane.Push(img, repo+":latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{repo + ":latest", repo + "@" + digest.String(), DockerTransportPrefix + repo + ":latest"} {
+		report, err := Scan(context.Background(), ref, WithOutput(io.Discard))
+		if err != nil {
+			t.Fatalf("unexpected error scanning %s: %v", ref, err)
+		}
+		if report.ImageDigest != digest.String() {
+			t.Fatalf("want=%s, got=%s for %s", digest, report.ImageDigest, ref)
+		}
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	pinned, err := name.NewDigest("example.com/image@sha256:" + strings.Repeat("a", 64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := name.NewTag("example.com/image:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matching := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("a", 64)}
+	other := v1.Hash{Algorithm: "sha256", Hex: strings.Repeat("b", 64)}
+
+	if err := verifyDigest(pinned, matching); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := verifyDigest(tag, other); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := verifyDigest(pinned, other); !errors.Is(err, ErrDigestMismatch) {
+		t.Fatalf("want=%v, got=%v", ErrDigestMismatch, err)
+	}
+}
+
+func TestScanInsecureRegistry(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	server := httptest.NewTLSServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "https://") + "/example/image:latest"
+	if err := crane.Push(img, ref, crane.WithTransport(server.Client().Transport)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Scan(context.Background(), ref, WithOutput(io.Discard)); !errors.Is(err, ErrImagePull) {
+		t.Fatalf("expected the self-signed certificate to be rejected, got %v", err)
+	}
+	if _, err := Scan(context.Background(), ref, WithOutput(io.Discard), WithInsecure(true)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestScanKeychain(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+	if err := crane.Push(img, host+"/example/image:latest"); err != nil {
+		t.Fatal(err)
+	}
+
+	keychain := &recordingKeychain{}
+	if _, err := Scan(context.Background(), host+"/example/image:latest", WithOutput(io.Discard), WithKeychain(keychain)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(keychain.registries) == 0 || keychain.registries[0] != host {
+		t.Fatalf("expected credentials for %s to be resolved by the keychain, got %v", host, keychain.registries)
+	}
+}
+
+func TestScanCACertFile(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	server := httptest.NewTLSServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "https://") + "/example/image:latest"
+	if err := crane.Push(img, ref, crane.WithTransport(server.Client().Transport)); err != nil {
+		t.Fatal(err)
+	}
+
+	caCertFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caCertFile, cert, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Scan(context.Background(), ref, WithOutput(io.Discard), WithCACertFile(caCertFile)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := os.WriteFile(caCertFile, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Scan(context.Background(), ref, WithOutput(io.Discard), WithCACertFile(caCertFile)); err == nil || !strings.Contains(err.Error(), "no CA certificates") {
+		t.Fatalf("expected the CA certificates to be rejected, got %v", err)
+	}
+}
+
+func TestScanProxy(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	server := httptest.NewServer(registry.New(registry.Logger(log.New(io.Discard, "", 0))))
+	defer server.Close()
+	ref := strings.TrimPrefix(server.URL, "http://") + "/example/image:latest"
+	if err := crane.Push(img, ref); err != nil {
+		t.Fatal(err)
+	}
+
+	var proxied int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&proxied, 1)
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Scan(context.Background(), ref, WithOutput(io.Discard), WithProxy(proxyURL)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if atomic.LoadInt32(&proxied) == 0 {
+		t.Fatalf("expected the registry to be reached through the proxy")
+	}
+}
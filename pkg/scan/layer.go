@@ -0,0 +1,274 @@
+package scan
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"golang.org/x/sync/errgroup"
+)
+
+const whiteoutPrefix = ".wh."
+
+// whiteoutMetaPrefix prefixes the names reserved for whiteout metadata rather
+// than the removal of a file named after the whiteout prefix.
+const whiteoutMetaPrefix = whiteoutPrefix + whiteoutPrefix
+
+// opaqueWhiteout marks a directory whose contents in lower layers have all been
+// removed.
+const opaqueWhiteout = whiteoutMetaPrefix + ".opq"
+
+// ChangeKind describes how a layer changed a path.
+type ChangeKind string
+
+// Kinds of change a layer can make to a path.
+const (
+	// ChangeAdded is content written at a path. A layer represents an added
+	// file and a modified one in the same way, so GenerateChangesFor reports
+	// both as ChangeAdded.
+	ChangeAdded ChangeKind = "added"
+	// ChangeModified is content written over a package-owned file. It is
+	// reported by Scan, which knows which files were installed.
+	ChangeModified ChangeKind = "modified"
+	// ChangeDeleted is a path removed by a whiteout.
+	ChangeDeleted ChangeKind = "deleted"
+	// ChangeSymlink is a symlink written at a path.
+	ChangeSymlink ChangeKind = "symlink"
+	// ChangeOpaque is a directory whose contents in lower layers were all
+	// removed by an opaque whiteout.
+	ChangeOpaque ChangeKind = "opaque"
+	// ChangeDevice is a character or block device node written at a path.
+	ChangeDevice ChangeKind = "device"
+	// ChangeFifo is a named pipe written at a path.
+	ChangeFifo ChangeKind = "fifo"
+	// ChangeDirectory is a directory written at a path. Scan only reports one
+	// that replaces a package-owned file that is not a directory.
+	ChangeDirectory ChangeKind = "directory"
+	// ChangeReplacedDirectory is anything other than a directory written over
+	// a package-owned directory. It is reported by Scan, which knows which
+	// directories were installed.
+	ChangeReplacedDirectory ChangeKind = "replacedDirectory"
+)
+
+// Change is a single change made by a layer.
+type Change struct {
+	Path string     `json:"path"`
+	Kind ChangeKind `json:"kind"`
+	// Linkname is the target of a symlink or hardlink.
+	Linkname string `json:"linkname,omitempty"`
+	// Digests are the hex digests of the content of a regular file, keyed by
+	// algorithm. They are only computed when digest verification is enabled.
+	Digests map[string]string `json:"digests,omitempty"`
+}
+
+// GenerateChangesFor will check layer for file changes, and will return a list of those.
+// A symlink or hardlink is reported by its own path rather than that of its
+// target, as it is the link that replaces whatever previously existed at that
+// path.
+func GenerateChangesFor(ctx context.Context, layer v1.Layer) ([]Change, error) {
+	changes, _, err := readChanges(ctx, layer, nil)
+	return changes, err
+}
+
+// readChanges is GenerateChangesFor, additionally digesting the content of
+// each regular file with each of algorithms. It also returns the names, as
+// written, of the entries with a ".." element, which Clean may resolve to a
+// path other than the one the entry appears to name.
+func readChanges(ctx context.Context, layer v1.Layer, algorithms []string) ([]Change, []string, error) {
+	layerReader, err := layer.Uncompressed()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading layer contents: %w", err)
+	}
+	defer layerReader.Close()
+	tarReader := tar.NewReader(layerReader)
+	var changes []Change
+	var traversals []string
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading tar: %w", err)
+		}
+
+		if hasParentElement(header.Name) {
+			traversals = append(traversals, header.Name)
+		}
+		// Some tools prepend everything with "./", so if we don't Clean the
+		// name, we may have duplicate entries, which angers tar-split.
+		header.Name = path.Clean(header.Name)
+		if isRootName(header.Name) {
+			continue
+		}
+		// force PAX format to remove Name/Linkname length limit of 100 characters
+		// required by USTAR and to not depend on internal tar package guess which
+		// prefers USTAR over PAX
+		header.Format = tar.FormatPAX
+
+		name, kind, ok := ClassifyEntry(header)
+		if !ok {
+			continue
+		}
+		change := Change{Path: name, Kind: kind}
+		switch {
+		case kind == ChangeSymlink:
+			change.Linkname = header.Linkname
+		case kind == ChangeAdded && header.Typeflag == tar.TypeLink:
+			// a hardlink replaces whatever was at its name with the content of
+			// its target, so it is the name that has been modified.
+			change.Linkname = header.Linkname
+		case kind == ChangeAdded && len(algorithms) > 0:
+			if change.Digests, err = digestContent(tarReader, algorithms); err != nil {
+				return nil, nil, fmt.Errorf("reading %s: %w", header.Name, err)
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, traversals, nil
+}
+
+// ClassifyEntry returns the path, relative to the root of the image, that the
+// tar entry header of a layer changes, and the kind of change it makes. A
+// leading "./" or "/" is removed from the path, and a whiteout is reported as
+// the deletion of the path it is named after whatever its type. ok is false
+// for the root directory, the metadata AUFS keeps under the reserved whiteout
+// prefix, and any other entry that does not change a path.
+func ClassifyEntry(header *tar.Header) (name string, kind ChangeKind, ok bool) {
+	name = path.Clean(header.Name)
+	basename := path.Base(name)
+	dirname := path.Dir(name)
+	if basename == opaqueWhiteout {
+		dir := strings.TrimPrefix(dirname, "/")
+		if dir == "" {
+			// an opaque whiteout of the root, however it is named.
+			dir = "."
+		}
+		return dir, ChangeOpaque, true
+	}
+	// AUFS keeps its own metadata, such as the hardlinks of .wh..wh.plnk,
+	// under names with the reserved prefix, which are not files of the image.
+	if inWhiteoutMeta(name) {
+		return "", "", false
+	}
+	// only the last element of a name is a whiteout. A whiteout is a whiteout
+	// whatever its type, as AUFS tooling may write one as a hardlink to a
+	// single empty file.
+	if strings.HasPrefix(basename, whiteoutPrefix) {
+		return strings.TrimPrefix(path.Join(dirname, basename[len(whiteoutPrefix):]), "/"), ChangeDeleted, true
+	}
+	if isRootName(name) {
+		// the root of the layer is always a directory, whatever the type of
+		// the entry that names it.
+		return "", "", false
+	}
+	name = strings.TrimPrefix(name, "/")
+	switch header.Typeflag {
+	case tar.TypeReg, tar.TypeLink:
+		return name, ChangeAdded, true
+	case tar.TypeSymlink:
+		return name, ChangeSymlink, true
+	case tar.TypeChar, tar.TypeBlock:
+		// a device node in place of a package-owned file is rarely
+		// legitimate, so it is reported rather than ignored.
+		return name, ChangeDevice, true
+	case tar.TypeFifo:
+		return name, ChangeFifo, true
+	case tar.TypeDir:
+		return name, ChangeDirectory, true
+	default:
+		// any other entries do not replace a file.
+		return "", "", false
+	}
+}
+
+// isRootName reports whether name, a cleaned tar entry name, is the root of
+// the layer, as the "./", "." and "/" entries some tools write are, rather
+// than a path within it.
+func isRootName(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	return name == "" || name == "."
+}
+
+// hasParentElement reports whether any element of name, a tar entry name as
+// written, is "..".
+func hasParentElement(name string) bool {
+	for more := true; more; {
+		var element string
+		element, name, more = strings.Cut(name, "/")
+		if element == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// inWhiteoutMeta reports whether any element of name has the prefix reserved
+// for whiteout metadata.
+func inWhiteoutMeta(name string) bool {
+	for more := true; more; {
+		var element string
+		element, name, more = strings.Cut(name, "/")
+		if strings.HasPrefix(element, whiteoutMetaPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// generateChanges reads the changes made by each of layers using up to
+// concurrency workers, digesting regular files with each of algorithms and
+// reporting each layer to p as it is read. The changes are returned in the same order as layers
+// regardless of the order in which the workers complete, along with the names
+// of the entries of each layer with a ".." element, and the first error
+// cancels any layers that have not yet been started.
+func generateChanges(ctx context.Context, layers []v1.Layer, concurrency int, algorithms []string, p *progress) ([][]Change, [][]string, error) {
+	changes := make([][]Change, len(layers))
+	traversals := make([][]string, len(layers))
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(concurrency)
+	for i, layer := range layers {
+		i, layer := i, layer
+		g.Go(func() error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			id, _ := layer.Digest()
+			c, t, err := readChanges(ctx, layer, algorithms)
+			if err != nil {
+				return wrap(ErrLayerRead, fmt.Errorf("getting files from layer %s: %w", id, err))
+			}
+			changes[i], traversals[i] = c, t
+			p.layerRead(id)
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, nil, err
+	}
+	return changes, traversals, nil
+}
+
+// opaqueRemovals returns the paths in present that are removed by an opaque
+// whiteout of dir, sorted.
+func opaqueRemovals(present map[string]struct{}, dir string) []string {
+	var removed []string
+	for path := range present {
+		if dir == "." || strings.HasPrefix(path, dir+"/") {
+			removed = append(removed, path)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
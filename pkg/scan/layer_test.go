@@ -0,0 +1,295 @@
+package scan
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestClassifyEntry(t *testing.T) {
+	tests := []struct {
+		header *tar.Header
+		name   string
+		kind   ChangeKind
+		ok     bool
+	}{
+		{&tar.Header{Name: "./usr/bin/foo", Typeflag: tar.TypeReg}, "usr/bin/foo", ChangeAdded, true},
+		{&tar.Header{Name: "/usr/bin/foo", Typeflag: tar.TypeLink}, "usr/bin/foo", ChangeAdded, true},
+		{&tar.Header{Name: "usr/lib/foo.so", Typeflag: tar.TypeSymlink}, "usr/lib/foo.so", ChangeSymlink, true},
+		{&tar.Header{Name: "dev/null", Typeflag: tar.TypeChar}, "dev/null", ChangeDevice, true},
+		{&tar.Header{Name: "dev/sda", Typeflag: tar.TypeBlock}, "dev/sda", ChangeDevice, true},
+		{&tar.Header{Name: "run/pipe", Typeflag: tar.TypeFifo}, "run/pipe", ChangeFifo, true},
+		{&tar.Header{Name: "usr/bin/", Typeflag: tar.TypeDir}, "usr/bin", ChangeDirectory, true},
+		{&tar.Header{Name: "./", Typeflag: tar.TypeDir}, "", "", false},
+		{&tar.Header{Name: "/", Typeflag: tar.TypeDir}, "", "", false},
+		{&tar.Header{Name: ".", Typeflag: tar.TypeReg}, "", "", false},
+		{&tar.Header{Name: "usr/bin/.wh.foo", Typeflag: tar.TypeReg}, "usr/bin/foo", ChangeDeleted, true},
+		{&tar.Header{Name: "usr/bin/.wh.foo", Typeflag: tar.TypeLink}, "usr/bin/foo", ChangeDeleted, true},
+		{&tar.Header{Name: ".wh.foo", Typeflag: tar.TypeReg}, "foo", ChangeDeleted, true},
+		{&tar.Header{Name: "usr/.wh.foo/bar", Typeflag: tar.TypeReg}, "usr/.wh.foo/bar", ChangeAdded, true},
+		{&tar.Header{Name: "usr/lib/.wh..wh..opq", Typeflag: tar.TypeReg}, "usr/lib", ChangeOpaque, true},
+		{&tar.Header{Name: "./.wh..wh..opq", Typeflag: tar.TypeReg}, ".", ChangeOpaque, true},
+		{&tar.Header{Name: ".wh..wh.plnk/12.34", Typeflag: tar.TypeReg}, "", "", false},
+		{&tar.Header{Name: "usr/.wh..wh.aufs", Typeflag: tar.TypeReg}, "", "", false},
+	}
+
+	for _, test := range tests {
+		name, kind, ok := ClassifyEntry(test.header)
+		if name != test.name || kind != test.kind || ok != test.ok {
+			t.Fatalf("want=%q %q %v, got=%q %q %v for %s", test.name, test.kind, test.ok, name, kind, ok, test.header.Name)
+		}
+	}
+}
+
+func TestGenerateChangesForHardlink(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "opt/evil", content: "evil"},
+		testEntry{name: "usr/bin/bash", typeflag: tar.TypeLink, linkname: "opt/evil"},
+	)
+
+	expected := []Change{
+		{Path: "opt/evil", Kind: ChangeAdded},
+		{Path: "usr/bin/bash", Kind: ChangeAdded, Linkname: "opt/evil"},
+	}
+	actual, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesForSymlink(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "opt/evil", content: "evil"},
+		testEntry{name: "usr/bin/bash", typeflag: tar.TypeSymlink, linkname: "/opt/evil"},
+		testEntry{name: "opt/sh", typeflag: tar.TypeSymlink, linkname: "/usr/bin/sh"},
+	)
+
+	expected := []Change{
+		{Path: "opt/evil", Kind: ChangeAdded},
+		{Path: "usr/bin/bash", Kind: ChangeSymlink, Linkname: "/opt/evil"},
+		{Path: "opt/sh", Kind: ChangeSymlink, Linkname: "/usr/bin/sh"},
+	}
+	actual, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesForSpecialFiles(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "dev/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/bin/bash", typeflag: tar.TypeChar},
+		testEntry{name: "dev/sda", typeflag: tar.TypeBlock},
+		testEntry{name: "run/pipe", typeflag: tar.TypeFifo},
+	)
+
+	expected := []Change{
+		{Path: "dev", Kind: ChangeDirectory},
+		{Path: "usr/bin/bash", Kind: ChangeDevice},
+		{Path: "dev/sda", Kind: ChangeDevice},
+		{Path: "run/pipe", Kind: ChangeFifo},
+	}
+	actual, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesForWhiteouts(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "usr/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/share/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/share/doc/.wh.foo"},
+		testEntry{name: "./usr/lib/.wh..wh..opq"},
+		testEntry{name: "usr/lib/nested/deeper/file", content: "new"},
+		testEntry{name: ".wh.opt", typeflag: tar.TypeDir},
+		testEntry{name: "/.wh..wh..opq"},
+	)
+
+	expected := []Change{
+		{Path: "usr", Kind: ChangeDirectory},
+		{Path: "usr/share", Kind: ChangeDirectory},
+		{Path: "usr/share/doc/foo", Kind: ChangeDeleted},
+		{Path: "usr/lib", Kind: ChangeOpaque},
+		{Path: "usr/lib/nested/deeper/file", Kind: ChangeAdded},
+		{Path: "opt", Kind: ChangeDeleted},
+		{Path: ".", Kind: ChangeOpaque},
+	}
+	actual, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesForWhiteoutEdgeCases(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "usr/share/foo.wh.bar", content: "not a whiteout"},
+		testEntry{name: "usr/share/.wh.dir/file", content: "not a whiteout either"},
+		testEntry{name: ".wh..wh.plnk/", typeflag: tar.TypeDir},
+		testEntry{name: ".wh..wh.plnk/123.456", content: ""},
+		testEntry{name: ".wh..wh.aufs", content: ""},
+		testEntry{name: "usr/bin/.wh.foo", typeflag: tar.TypeLink, linkname: ".wh..wh.plnk/123.456"},
+		testEntry{name: "usr/lib/.wh.libfoo.so", typeflag: tar.TypeSymlink, linkname: "libfoo.so.1"},
+	)
+
+	expected := []Change{
+		{Path: "usr/share/foo.wh.bar", Kind: ChangeAdded},
+		{Path: "usr/share/.wh.dir/file", Kind: ChangeAdded},
+		{Path: "usr/bin/foo", Kind: ChangeDeleted},
+		{Path: "usr/lib/libfoo.so", Kind: ChangeDeleted},
+	}
+	actual, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesForUncleanPaths(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "./usr//lib/../bin/foo", content: "foo"},
+		testEntry{name: "/usr/./share/doc/.wh.foo"},
+		testEntry{name: "usr/bin//bar", typeflag: tar.TypeSymlink, linkname: "foo"},
+	)
+
+	expected := []Change{
+		{Path: "usr/bin/foo", Kind: ChangeAdded},
+		{Path: "usr/share/doc/foo", Kind: ChangeDeleted},
+		{Path: "usr/bin/bar", Kind: ChangeSymlink, Linkname: "foo"},
+	}
+	actual, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesForRootEntries(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "./", typeflag: tar.TypeDir},
+		testEntry{name: ".", typeflag: tar.TypeDir},
+		testEntry{name: "/", typeflag: tar.TypeDir},
+		testEntry{name: "."},
+		testEntry{name: "./usr/bin/foo", content: "foo"},
+	)
+
+	expected := []Change{{Path: "usr/bin/foo", Kind: ChangeAdded}}
+	actual, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesOrder(t *testing.T) {
+	var layers []v1.Layer
+	var expected [][]Change
+	for i := 0; i < 8; i++ {
+		name := fmt.Sprintf("usr/bin/file%d", i)
+		layers = append(layers, testLayer(t, testEntry{name: name, content: name}))
+		expected = append(expected, []Change{{Path: name, Kind: ChangeAdded}})
+	}
+
+	actual, _, err := generateChanges(context.Background(), layers, 4, nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestGenerateChangesError(t *testing.T) {
+	layers := []v1.Layer{
+		testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"}),
+		truncatedLayer(t),
+		testLayer(t, testEntry{name: "usr/bin/bar", content: "bar"}),
+	}
+
+	_, _, err := generateChanges(context.Background(), layers, 2, nil, nil)
+	if !errors.Is(err, ErrLayerRead) {
+		t.Fatalf("want=%v, got=%v", ErrLayerRead, err)
+	}
+}
+
+func TestGenerateChangesCanceled(t *testing.T) {
+	layers := []v1.Layer{testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := generateChanges(ctx, layers, 1, nil, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want=%v, got=%v", context.Canceled, err)
+	}
+}
+
+func BenchmarkGenerateChanges(b *testing.B) {
+	var layers []v1.Layer
+	for i := 0; i < 32; i++ {
+		var entries []testEntry
+		for j := 0; j < 2000; j++ {
+			name := fmt.Sprintf("usr/lib/layer%d/file%d", i, j)
+			entries = append(entries, testEntry{name: name, content: name})
+		}
+		layers = append(layers, testLayer(b, entries...))
+	}
+
+	for _, concurrency := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency=%d", concurrency), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, _, err := generateChanges(context.Background(), layers, concurrency, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpaqueRemovals(t *testing.T) {
+	present := map[string]struct{}{
+		"usr/lib":        {},
+		"usr/lib/a.so":   {},
+		"usr/lib/sub/b":  {},
+		"usr/lib64/c.so": {},
+		"usr/bin/foo":    {},
+	}
+
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"usr/lib", []string{"usr/lib/a.so", "usr/lib/sub/b"}},
+		{"usr/share", nil},
+		{".", []string{"usr/bin/foo", "usr/lib", "usr/lib/a.so", "usr/lib/sub/b", "usr/lib64/c.so"}},
+	}
+
+	for _, test := range tests {
+		actual := opaqueRemovals(present, test.input)
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Fatalf("want=%v, got=%v for input %s", test.expected, actual, test.input)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package scan
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Defaults for WithExtractionLimits.
+const (
+	DefaultMaxExtractedFileSize  = 1 << 30
+	DefaultMaxExtractedLayerSize = 2 << 30
+)
+
+// extractionLimits bounds the bytes copied out of a layer when its package
+// database is extracted, so that an image with an enormous database, or with
+// entries that only claim to be one, cannot exhaust memory or disk. A limit of
+// 0 disables it.
+type extractionLimits struct {
+	// file is the most bytes copied from a single entry.
+	file int64
+	// layer is the most bytes copied from all the entries of a layer.
+	layer int64
+}
+
+var defaultExtractionLimits = extractionLimits{file: DefaultMaxExtractedFileSize, layer: DefaultMaxExtractedLayerSize}
+
+// start returns an extraction from a single layer within l.
+func (l extractionLimits) start() *extraction {
+	return &extraction{limits: l}
+}
+
+// extraction tracks the bytes copied out of a layer against its limits.
+type extraction struct {
+	limits extractionLimits
+	copied int64
+}
+
+// copy copies the entry name from r to w, returning an error wrapping
+// ErrExtractionLimit, without copying the rest of it, if it is larger than the
+// limits allow.
+func (e *extraction) copy(w io.Writer, r io.Reader, name string) error {
+	limit := e.limits.file
+	if e.limits.layer > 0 && (limit == 0 || e.limits.layer-e.copied < limit) {
+		limit = e.limits.layer - e.copied
+	}
+	if e.limits.file > 0 || e.limits.layer > 0 {
+		// one byte more than the limit is enough to know it was exceeded.
+		r = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(w, r)
+	e.copied += n
+	if err != nil {
+		return err
+	}
+	if e.limits.file > 0 && n > e.limits.file {
+		return fmt.Errorf("%w: %s is larger than %d bytes", ErrExtractionLimit, name, e.limits.file)
+	}
+	if e.limits.layer > 0 && e.copied > e.limits.layer {
+		return fmt.Errorf("%w: more than %d bytes extracted from the layer by %s", ErrExtractionLimit, e.limits.layer, name)
+	}
+	return nil
+}
+
+// readAll is like copy, but returns the content of the entry.
+func (e *extraction) readAll(r io.Reader, name string) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := e.copy(&buf, r, name); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
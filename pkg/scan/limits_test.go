@@ -0,0 +1,48 @@
+package scan
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestExtractionLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   extractionLimits
+		files    []string
+		expected error
+	}{
+		{"within", extractionLimits{file: 4, layer: 8}, []string{"abcd", "efgh"}, nil},
+		{"file too large", extractionLimits{file: 4, layer: 8}, []string{"abcde"}, ErrExtractionLimit},
+		{"layer too large", extractionLimits{file: 4, layer: 8}, []string{"abcd", "efgh", "i"}, ErrExtractionLimit},
+		{"no file limit", extractionLimits{layer: 8}, []string{"abcdefgh"}, nil},
+		{"unlimited", extractionLimits{}, []string{strings.Repeat("a", 1<<16)}, nil},
+	}
+
+	for _, test := range tests {
+		extracted := test.limits.start()
+		var err error
+		for _, file := range test.files {
+			if err = extracted.copy(io.Discard, strings.NewReader(file), "file"); err != nil {
+				break
+			}
+		}
+		if !errors.Is(err, test.expected) {
+			t.Fatalf("want=%v, got=%v for %s", test.expected, err, test.name)
+		}
+	}
+}
+
+func TestExtractionLimitsStopCopying(t *testing.T) {
+	var buf bytes.Buffer
+	err := extractionLimits{file: 4}.start().copy(&buf, strings.NewReader(strings.Repeat("a", 1<<20)), "file")
+	if !errors.Is(err, ErrExtractionLimit) {
+		t.Fatalf("want=%v, got=%v", ErrExtractionLimit, err)
+	}
+	if buf.Len() > 5 {
+		t.Fatalf("expected at most 5 bytes to be copied, got %d", buf.Len())
+	}
+}
@@ -0,0 +1,63 @@
+package scan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Logger receives the messages describing the progress of a scan and the
+// reason for each of its decisions.
+type Logger interface {
+	// Log logs a single message made of args, which are formatted as by
+	// fmt.Sprintln.
+	Log(args ...any)
+}
+
+// NewTextLogger returns a Logger that writes each message to w as a line of
+// human readable text.
+func NewTextLogger(w io.Writer) Logger {
+	return textLogger{w: w}
+}
+
+type textLogger struct {
+	w io.Writer
+}
+
+func (l textLogger) Log(args ...any) {
+	fmt.Fprintln(l.w, args...)
+}
+
+// NewJSONLogger returns a Logger that writes each message to w as a JSON
+// object on its own line, with the time it was logged. Styling and
+// indentation are removed from the message.
+func NewJSONLogger(w io.Writer) Logger {
+	return jsonLogger{w: w, now: time.Now}
+}
+
+type jsonLogger struct {
+	w   io.Writer
+	now func() time.Time
+}
+
+// ansiEscape matches the terminal escape sequences used to style messages.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+type jsonLogEntry struct {
+	Time    string `json:"time"`
+	Message string `json:"msg"`
+}
+
+func (l jsonLogger) Log(args ...any) {
+	msg := strings.TrimSpace(ansiEscape.ReplaceAllString(fmt.Sprintln(args...), ""))
+	b, err := json.Marshal(jsonLogEntry{Time: l.now().UTC().Format(time.RFC3339Nano), Message: msg})
+	if err != nil {
+		return
+	}
+	// the entry is written with a single call so that entries logged at the
+	// same time are not interleaved.
+	l.w.Write(append(b, '\n'))
+}
@@ -0,0 +1,44 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := jsonLogger{w: &buf, now: func() time.Time { return time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC) }}
+	l.Log("\t", "usr/bin/foo", "was excluded by", "\x1b[38;2;0;0;255mfile\x1b[0m", "exclusions")
+
+	expected := `{"time":"2023-01-02T03:04:05Z","msg":"usr/bin/foo was excluded by file exclusions"}` + "\n"
+	if buf.String() != expected {
+		t.Fatalf("want=%q, got=%q", expected, buf.String())
+	}
+}
+
+func TestScanImageJSONLogger(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	var buf bytes.Buffer
+	if _, err := ScanImage(context.Background(), img, WithLogger(NewJSONLogger(&buf)), WithVerbose(true)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for _, line := range lines {
+		var entry jsonLogEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected every line to be a JSON object, got %q: %v", line, err)
+		}
+	}
+	if !strings.Contains(buf.String(), "bin/busybox is owned by busybox-1.36.1-r5") {
+		t.Fatalf("expected the decision for bin/busybox to be logged, got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,54 @@
+package scan
+
+import (
+	"fmt"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Metadata describes the image that was scanned, as recorded in its manifest
+// and config, so that a report records exactly what it applies to. Its layers
+// and package database are described by the LayerCount, RPMDBLayerIndex and
+// PackageManager of the Report itself.
+type Metadata struct {
+	// OS and Architecture are the platform of the image, from its config.
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	// Variant is the variant of the architecture, such as v8 for arm64, if the
+	// config records one.
+	Variant   string `json:"variant,omitempty"`
+	OSVersion string `json:"osVersion,omitempty"`
+	// Created is when the image was created, if its config records it.
+	Created *time.Time `json:"created,omitempty"`
+	// MediaType is the media type of the manifest.
+	MediaType string `json:"mediaType"`
+	// ConfigDigest is the digest of the config, which identifies the image
+	// regardless of the format of its manifest.
+	ConfigDigest string `json:"configDigest"`
+}
+
+// imageMetadata returns the metadata of img from its manifest and config.
+func imageMetadata(img v1.Image) (Metadata, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return Metadata{}, wrap(ErrImagePull, fmt.Errorf("getting manifest: %w", err))
+	}
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		return Metadata{}, wrap(ErrImagePull, fmt.Errorf("getting config: %w", err))
+	}
+	md := Metadata{
+		OS:           cfg.OS,
+		Architecture: cfg.Architecture,
+		Variant:      cfg.Variant,
+		OSVersion:    cfg.OSVersion,
+		MediaType:    string(manifest.MediaType),
+		ConfigDigest: manifest.Config.Digest.String(),
+	}
+	if !cfg.Created.IsZero() {
+		created := cfg.Created.Time.UTC()
+		md.Created = &created
+	}
+	return md, nil
+}
@@ -0,0 +1,49 @@
+package scan
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+)
+
+func TestScanImageMetadata(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "etc/motd", content: "hello"}),
+	)
+	cfg, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg = cfg.DeepCopy()
+	cfg.OS, cfg.Architecture, cfg.Variant = "linux", "arm64", "v8"
+	cfg.Created = v1.Time{Time: time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)}
+	if img, err = mutate.ConfigFile(img, cfg); err != nil {
+		t.Fatal(err)
+	}
+	configDigest, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := newOptions().scanImage(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	md := report.Metadata
+	if md.OS != "linux" || md.Architecture != "arm64" || md.Variant != "v8" {
+		t.Fatalf("want=%s, got=%s", "linux/arm64/v8", md.OS+"/"+md.Architecture+"/"+md.Variant)
+	}
+	if md.Created == nil || !md.Created.Equal(cfg.Created.Time) {
+		t.Fatalf("want=%v, got=%v", cfg.Created.Time, md.Created)
+	}
+	if md.ConfigDigest != configDigest.String() || md.MediaType == "" {
+		t.Fatalf("want=%s, got=%+v", configDigest, md)
+	}
+	if report.LayerCount != 2 || report.RPMDBLayerIndex != 0 || report.PackageManager != PackageManagerApk {
+		t.Fatalf("want=%v, got=%v", []any{2, 0, PackageManagerApk}, []any{report.LayerCount, report.RPMDBLayerIndex, report.PackageManager})
+	}
+}
@@ -0,0 +1,93 @@
+package scan
+
+import (
+	"context"
+	"runtime"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// ModifiedFiles returns, for each file in baseline that is changed by layers,
+// the digests of the layers that changed it in the order they are provided.
+// baseline maps package-owned files to the package that owns them, as returned
+// by BuildFileMap. Paths written through a directory symlinked by one of
+// layers are resolved to the path they refer to. Unlike Scan, no exclusions
+// are applied: every write, whiteout, or opaque whiteout affecting a file in
+// baseline is reported. As in Scan, a directory written over a file in
+// baseline that is itself a directory, because another file in baseline is
+// under it, does not modify it, as a layer writes one for each directory it
+// writes into.
+func ModifiedFiles(baseline map[string]string, layers []v1.Layer) (map[string][]string, error) {
+	changes, _, err := generateChanges(context.Background(), layers, runtime.GOMAXPROCS(0), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	owned := make(map[string]string, len(baseline))
+	for path, pkg := range baseline {
+		owned[Normalize(path)] = pkg
+	}
+	// dirs tracks the owned paths that are directories as of each layer.
+	dirs := parentDirs(owned)
+	// present tracks the package-owned paths that still exist as of each layer
+	// so that opaque whiteouts can be expanded to the files they remove.
+	present := make(map[string]struct{}, len(owned))
+	for path := range owned {
+		present[path] = struct{}{}
+	}
+
+	links := symlinks{}
+	modified := map[string][]string{}
+	for i, layer := range layers {
+		id, err := layer.Digest()
+		if err != nil {
+			return nil, wrap(ErrLayerRead, err)
+		}
+		// a layer that both writes a file and removes it with an opaque
+		// whiteout of its parent is recorded once.
+		seen := map[string]struct{}{}
+		record := func(path string) {
+			if _, found := seen[path]; found {
+				return
+			}
+			seen[path] = struct{}{}
+			modified[path] = append(modified[path], id.String())
+		}
+
+		for j, change := range changes[i] {
+			changes[i][j].Path = links.canonical(change.Path)
+			links.apply(changes[i][j])
+		}
+		for _, change := range changes[i] {
+			if change.Kind != ChangeOpaque {
+				continue
+			}
+			for _, removed := range opaqueRemovals(present, change.Path) {
+				delete(present, removed)
+				record(removed)
+			}
+		}
+		for _, change := range changes[i] {
+			if _, found := owned[change.Path]; !found || change.Kind == ChangeOpaque {
+				continue
+			}
+			_, dir := dirs[change.Path]
+			switch {
+			case change.Kind == ChangeDirectory && dir:
+				continue
+			case change.Kind == ChangeDirectory:
+				dirs[change.Path] = struct{}{}
+			case dir && change.Kind != ChangeDeleted:
+				delete(dirs, change.Path)
+			}
+			if change.Kind == ChangeDeleted {
+				delete(present, change.Path)
+			} else {
+				present[change.Path] = struct{}{}
+			}
+			record(change.Path)
+		}
+	}
+
+	return modified, nil
+}
@@ -0,0 +1,81 @@
+package scan
+
+import (
+	"archive/tar"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestModifiedFiles(t *testing.T) {
+	baseline := map[string]string{
+		"/usr/bin/foo": "foo-1.0-1",
+		"usr/bin/bar":  "bar-1.0-1",
+		"usr/lib/a.so": "foo-1.0-1",
+		"etc/foo.conf": "foo-1.0-1",
+	}
+	layers := []v1.Layer{
+		testLayer(t,
+			testEntry{name: "./usr/bin/foo", content: "modified"},
+			testEntry{name: "opt/app", content: "unowned"},
+		),
+		testLayer(t,
+			testEntry{name: "usr/bin/.wh.foo"},
+			testEntry{name: "usr/bin/bar", typeflag: tar.TypeSymlink, linkname: "/opt/app"},
+		),
+		testLayer(t,
+			testEntry{name: "usr/lib/.wh..wh..opq"},
+			testEntry{name: "etc/foo.conf", content: "modified"},
+		),
+	}
+
+	digest := func(i int) string {
+		id, err := layers[i].Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id.String()
+	}
+	expected := map[string][]string{
+		"usr/bin/foo":  {digest(0), digest(1)},
+		"usr/bin/bar":  {digest(1)},
+		"usr/lib/a.so": {digest(2)},
+		"etc/foo.conf": {digest(2)},
+	}
+	actual, err := ModifiedFiles(baseline, layers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestModifiedFilesDirectories(t *testing.T) {
+	baseline := map[string]string{
+		"usr/bin":      "filesystem-1.0-1",
+		"usr/bin/foo":  "foo-1.0-1",
+		"etc/foo.conf": "foo-1.0-1",
+	}
+	layer := testLayer(t,
+		testEntry{name: "usr/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/bin/", typeflag: tar.TypeDir},
+		testEntry{name: "usr/bin/newtool", content: "new"},
+		testEntry{name: "etc/foo.conf/", typeflag: tar.TypeDir},
+	)
+	id, err := layer.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the directory written in place of a file is a modification.
+	expected := map[string][]string{"etc/foo.conf": {id.String()}}
+	actual, err := ModifiedFiles(baseline, []v1.Layer{layer})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
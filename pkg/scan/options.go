@@ -0,0 +1,546 @@
+package scan
+
+import (
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+// Option configures a Scan.
+type Option func(*options)
+
+type options struct {
+	log        Logger
+	exclusions Exclusions
+	regexps    []*regexp.Regexp
+	// includeOnly limits the files checked to those matching one of the
+	// patterns, if any.
+	includeOnly []string
+	// topLayers, if positive, is the number of layers at the top of the image
+	// that are checked.
+	topLayers int
+	// alwaysFail are the patterns of the files whose modification is always
+	// disallowed.
+	alwaysFail []string
+	// profile is the name of the built-in exclusion profile merged with
+	// exclusions, if any.
+	profile string
+	// keychain resolves registry credentials instead of the default keychain.
+	keychain authn.Keychain
+	// dockerConfig is the path to a docker config file to read credentials
+	// from instead of the default keychain.
+	dockerConfig string
+	concurrency  int
+	verbose      bool
+	// resolveSymlinks resolves the symlinked directories among the parents
+	// of each path written by a layer before it is looked up in the filemap.
+	resolveSymlinks bool
+	// allowEmptyPackageDB reports a package database that lists no packages
+	// rather than failing with ErrEmptyPackageDB.
+	allowEmptyPackageDB bool
+	// rpmdbSelection chooses between the layers containing an RPMDB.
+	rpmdbSelection string
+	// rpmdbLocation is where the RPMDB is looked for in each layer.
+	rpmdbLocation rpmdbLocation
+	// baseline, if set, is checked against instead of the package database
+	// found in the image.
+	baseline *InstalledFiles
+	// extraRPMDBLocations are the additional RPMDBs whose packages are merged
+	// with those of the RPMDB at rpmdbLocation.
+	extraRPMDBLocations []rpmdbLocation
+	// extractionLimits bound the bytes copied out of a layer when its package
+	// database is extracted.
+	extractionLimits extractionLimits
+	// tempDir is the directory in which package databases are extracted, or
+	// the default directory for temporary files if empty.
+	tempDir string
+	// rpmdbCache memoizes the packages listed by the RPMDB in each layer.
+	rpmdbCache *RPMDBCache
+	// insecure allows registries to be reached over plain HTTP or with TLS
+	// certificates that cannot be verified.
+	insecure bool
+	// proxy is the proxy registries are reached through instead of the one
+	// from the environment, if any.
+	proxy *url.URL
+	// caCertFile is a PEM file of CA certificates trusted in addition to the
+	// system's, if any.
+	caCertFile string
+	// maxRetries is the number of times a request to a registry is retried
+	// after a transient error, waiting retryBackoff before the first retry
+	// and twice as long before each one after it.
+	maxRetries   int
+	retryBackoff time.Duration
+	// platform selects the image to scan from an image index.
+	platform *v1.Platform
+	// cacheDir is the directory pulled layers are cached in, if any.
+	cacheDir string
+	// verifyDigests compares the content of modified files against the
+	// digests recorded in the RPMDB.
+	verifyDigests bool
+	// allowModifiedSymlinks allows symlinks written at package-owned paths.
+	allowModifiedSymlinks bool
+	// strictNormalization reports the entries of the layers checked whose
+	// names have a ".." element.
+	strictNormalization bool
+	// configAdvisories records the modifications to config files that are
+	// allowed because of their file flags.
+	configAdvisories bool
+	// checkInstallLayer compares the content of the files in the layer
+	// containing the RPMDB against the digests recorded in it.
+	checkInstallLayer bool
+	// containerExport is set when the image is the flat filesystem of a
+	// container rather than a stack of layers, so that the package-owned
+	// files missing from it have been removed.
+	containerExport bool
+	// progress is where the layers read so far are reported, if anywhere.
+	progress        io.Writer
+	progressInPlace bool
+	// onModification is called with each disallowed modification as it is
+	// found, if set.
+	onModification func(Modification)
+	// modifiableFlags are the RPM file flags that make a file modifiable.
+	modifiableFlags int32
+	// allowedPackages are the names of the packages whose files may be
+	// modified.
+	allowedPackages map[string]struct{}
+	// onlyPackages are the names of the packages whose files are the only
+	// ones checked, if any.
+	onlyPackages map[string]struct{}
+
+	// pathPatterns are the compiled exclusions.Paths.
+	pathPatterns []PathPattern
+	// directoryPatterns are the compiled exclusions.Directories.
+	directoryPatterns []DirectoryPattern
+	// includeDirs and includePatterns are the includeOnly patterns that are
+	// directories and globs respectively.
+	includeDirs     []string
+	includePatterns []PathPattern
+	// alwaysFailPatterns are the compiled alwaysFail patterns.
+	alwaysFailPatterns []PathPattern
+}
+
+func newOptions(opts ...Option) *options {
+	o := &options{
+		log:         NewTextLogger(os.Stdout),
+		exclusions:  DefaultExclusions(),
+		concurrency: runtime.GOMAXPROCS(0),
+
+		resolveSymlinks: true,
+
+		rpmdbSelection: RPMDBSelectionFirst,
+		rpmdbLocation:  defaultRPMDBLocation,
+		rpmdbCache:     NewRPMDBCache(),
+
+		extractionLimits: defaultExtractionLimits,
+
+		maxRetries:   DefaultMaxRetries,
+		retryBackoff: DefaultRetryBackoff,
+
+		modifiableFlags: DefaultModifiableFlags,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithOutput writes progress messages to w as human readable text. Defaults
+// to os.Stdout.
+func WithOutput(w io.Writer) Option {
+	return WithLogger(NewTextLogger(w))
+}
+
+// WithLogger sets the Logger progress messages are logged to, such as one
+// returned by NewJSONLogger. Defaults to a text logger writing to os.Stdout.
+func WithLogger(l Logger) Option {
+	return func(o *options) {
+		o.log = l
+	}
+}
+
+// WithExclusions sets the directories and paths that may be modified without
+// being reported. Defaults to DefaultExclusions.
+func WithExclusions(e Exclusions) Option {
+	return func(o *options) {
+		o.exclusions = e
+	}
+}
+
+// WithProfile merges the exclusions of the built-in profile name, one of
+// ProfileNames or ProfileAuto, with those set by WithExclusions. ProfileAuto
+// selects the profile for the package manager whose database is found.
+func WithProfile(name string) Option {
+	return func(o *options) {
+		o.profile = name
+	}
+}
+
+// WithIncludeOnly only checks the package-owned files matching one of
+// patterns, ignoring modifications to any other file. A pattern that is a glob,
+// with the syntax described by CompilePathPatterns, must match the path, and
+// any other pattern matches the path and everything under it. Files that are
+// included are still subject to the exclusions.
+func WithIncludeOnly(patterns ...string) Option {
+	return func(o *options) {
+		for _, p := range patterns {
+			o.includeOnly = append(o.includeOnly, Normalize(p))
+		}
+	}
+}
+
+// WithTopLayers only checks the last n layers of the image that follow the
+// package database, such as those a build added on top of a trusted base, and
+// does not read the layers below them, other than the one containing the
+// package database when it is checked. This trades completeness for speed: the
+// modifications made by the layers that are skipped are not found, and the
+// symlinks they create are not resolved. A value of 0, the default, or one
+// that is at least the number of layers following the package database checks
+// them all.
+func WithTopLayers(n int) Option {
+	return func(o *options) {
+		o.topLayers = n
+	}
+}
+
+// WithAlwaysFailPaths disallows any modification to the package-owned files
+// matching one of patterns, with the syntax described by CompilePathPatterns,
+// even if WithIncludeOnly, the exclusions, or WithAllowedPackages would allow
+// it, as they are checked first. Files that are modifiable because of their
+// RPM file flags are not package-owned files in this sense.
+func WithAlwaysFailPaths(patterns ...string) Option {
+	return func(o *options) {
+		for _, p := range patterns {
+			o.alwaysFail = append(o.alwaysFail, Normalize(p))
+		}
+	}
+}
+
+// WithRegexpExclusions excludes any path matching one of res, in addition to
+// the directory and path exclusions.
+func WithRegexpExclusions(res ...*regexp.Regexp) Option {
+	return func(o *options) {
+		o.regexps = append(o.regexps, res...)
+	}
+}
+
+// WithDockerConfig reads registry credentials from the docker config file at
+// path instead of the ambient docker configuration.
+func WithDockerConfig(path string) Option {
+	return func(o *options) {
+		o.dockerConfig = path
+	}
+}
+
+// WithKeychain resolves registry credentials using keychain, such as one of
+// the cloud provider credential helpers, instead of authn.DefaultKeychain. It
+// takes precedence over WithDockerConfig.
+func WithKeychain(keychain authn.Keychain) Option {
+	return func(o *options) {
+		o.keychain = keychain
+	}
+}
+
+// WithConcurrency sets the number of layers that are read for changes at the
+// same time. Defaults to GOMAXPROCS.
+func WithConcurrency(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.concurrency = n
+		}
+	}
+}
+
+// WithVerbose logs the reason a modification was or was not allowed for every
+// file changed by a layer.
+func WithVerbose(verbose bool) Option {
+	return func(o *options) {
+		o.verbose = verbose
+	}
+}
+
+// WithSymlinkResolution sets whether each path written by a layer is resolved
+// through the symlinked directories written by the layers up to it, such as
+// lib when it links to usr/lib, before it is looked up in the filemap, so that
+// a file written through one is checked as the package-owned file it replaces.
+// A symlink written at a package-owned path is reported either way. Defaults
+// to true.
+func WithSymlinkResolution(resolve bool) Option {
+	return func(o *options) {
+		o.resolveSymlinks = resolve
+	}
+}
+
+// WithModificationHandler calls handle with each disallowed modification as
+// soon as it is found, from the goroutine running the scan, so that results
+// can be processed before the scan completes. A file modified by several
+// layers is passed to handle once for each of them, with the Layers that have
+// modified it so far, as the report records every one of them.
+func WithModificationHandler(handle func(Modification)) Option {
+	return func(o *options) {
+		o.onModification = handle
+	}
+}
+
+// WithModifiableFileFlags sets the RPM file flags that make a file modifiable.
+// Defaults to DefaultModifiableFlags.
+func WithModifiableFileFlags(flags int32) Option {
+	return func(o *options) {
+		o.modifiableFlags = flags
+	}
+}
+
+// WithCacheDir caches the layers of pulled images in dir, keyed by their
+// digest, so that repeated scans of the same image do not download them again.
+// Images read from the local filesystem are not cached.
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.cacheDir = dir
+	}
+}
+
+// WithRPMDBSelection sets which of the layers containing an RPMDB is used as
+// the baseline of the scan, one of RPMDBSelectionFirst or RPMDBSelectionLast.
+// Defaults to RPMDBSelectionFirst.
+func WithRPMDBSelection(selection string) Option {
+	return func(o *options) {
+		o.rpmdbSelection = selection
+	}
+}
+
+// WithEmptyPackageDB sets whether a package database that lists no packages,
+// such as one that was only initialized or is corrupt, is reported with
+// Report.EmptyPackageDB set rather than failing the scan with
+// ErrEmptyPackageDB. Defaults to false.
+func WithEmptyPackageDB(allow bool) Option {
+	return func(o *options) {
+		o.allowEmptyPackageDB = allow
+	}
+}
+
+// WithRPMDBPath looks for the RPMDB in dir in each layer, instead of
+// /var/lib/rpm and /usr/lib/sysimage/rpm, for images that relocate it.
+func WithRPMDBPath(dir string) Option {
+	return func(o *options) {
+		o.rpmdbLocation = rpmdbLocationAt(dir)
+	}
+}
+
+// WithAdditionalRPMDBPaths merges the packages listed by the RPMDB in each of
+// dirs with those of the RPMDB found in the usual place, for images that keep
+// several, such as a per-user database or one for a root installed into with
+// rpm --root. Each is read as of the layer the main RPMDB is taken from, and
+// one that is not found there is skipped. The files listed by a database kept
+// in /var/lib/rpm or /usr/lib/sysimage/rpm under another directory are taken
+// to be installed under that directory. When two databases list the same file,
+// it is owned by the package in the main RPMDB, and then by the one in the
+// database given first.
+func WithAdditionalRPMDBPaths(dirs ...string) Option {
+	return func(o *options) {
+		for _, dir := range dirs {
+			o.extraRPMDBLocations = append(o.extraRPMDBLocations, rpmdbLocationAt(dir))
+		}
+	}
+}
+
+// WithBaseline checks the layers following the layer files.Layer against
+// files, as written by the filemap mode for an image sharing that layer, such
+// as its base image, instead of reading the package database in the image,
+// which saves extracting it from each of many images built from the same base.
+// It is an ErrInvalidPackageDB if the image has no such layer. The files
+// modifiable because of the flags recorded for them are those with any of the
+// flags given to WithModifiableFileFlags; a baseline written before the flags
+// of every file were recorded only lists those modifiable when it was written.
+func WithBaseline(files *InstalledFiles) Option {
+	return func(o *options) {
+		o.baseline = files
+	}
+}
+
+// WithRPMDBCache memoizes the packages listed by the RPMDB in each layer in
+// cache, which may be shared by several scans, such as those of a batch of
+// images with a common base layer, so that the RPMDB in a layer is only parsed
+// once. Defaults to a cache used by the scan alone, and a nil cache parses the
+// RPMDB every time it is read.
+func WithRPMDBCache(cache *RPMDBCache) Option {
+	return func(o *options) {
+		o.rpmdbCache = cache
+	}
+}
+
+// WithExtractionLimits limits the bytes copied out of a layer when its package
+// database is extracted to maxFile for any single file and maxLayer in total,
+// failing the read of a layer that exceeds them with ErrExtractionLimit. This
+// bounds the memory and disk used to scan untrusted images. Defaults to
+// DefaultMaxExtractedFileSize and DefaultMaxExtractedLayerSize, and a limit of
+// 0 disables it.
+func WithExtractionLimits(maxFile, maxLayer int64) Option {
+	return func(o *options) {
+		o.extractionLimits = extractionLimits{file: maxFile, layer: maxLayer}
+	}
+}
+
+// WithTempDir extracts package databases to temporary directories in dir,
+// which must exist, rather than the default directory for temporary files, so
+// that a large database can be extracted to a volume with room for it.
+func WithTempDir(dir string) Option {
+	return func(o *options) {
+		o.tempDir = dir
+	}
+}
+
+// WithInsecure allows images to be pulled from registries served over plain
+// HTTP, or whose TLS certificates cannot be verified, such as those that are
+// self-signed.
+func WithInsecure(insecure bool) Option {
+	return func(o *options) {
+		o.insecure = insecure
+	}
+}
+
+// WithProxy reaches registries through the proxy at u rather than the one
+// given by the HTTPS_PROXY or HTTP_PROXY environment variables.
+func WithProxy(u *url.URL) Option {
+	return func(o *options) {
+		o.proxy = u
+	}
+}
+
+// WithCACertFile trusts the PEM encoded CA certificates in the file at path,
+// in addition to the system's, when verifying registry TLS certificates.
+func WithCACertFile(path string) Option {
+	return func(o *options) {
+		o.caCertFile = path
+	}
+}
+
+// Defaults for WithRetries.
+const (
+	DefaultMaxRetries   = 3
+	DefaultRetryBackoff = time.Second
+)
+
+// WithRetries retries a request to a registry up to maxRetries times if it
+// fails with a network error, rate limiting, or a registry that is
+// temporarily unavailable, waiting backoff before the first retry and twice as
+// long before each one after it. A Retry-After header asking to wait longer
+// is respected. Defaults to DefaultMaxRetries and DefaultRetryBackoff, and a
+// maxRetries of 0 disables retries.
+func WithRetries(maxRetries int, backoff time.Duration) Option {
+	return func(o *options) {
+		o.maxRetries = maxRetries
+		o.retryBackoff = backoff
+	}
+}
+
+// WithPlatform selects the image for platform when the pulled reference is an
+// image index. Without it, pulling an image index fails with
+// ErrPlatformRequired.
+func WithPlatform(platform *v1.Platform) Option {
+	return func(o *options) {
+		o.platform = platform
+	}
+}
+
+// WithDigestVerification compares the content of each package-owned file
+// written by a layer against the digest recorded for it in the RPMDB, and
+// only reports the file if its content differs from what was installed. It
+// has no effect on images whose package database is not an RPMDB.
+func WithDigestVerification(verify bool) Option {
+	return func(o *options) {
+		o.verifyDigests = verify
+	}
+}
+
+// WithModifiedSymlinksAllowed allows a symlink written by a layer at the path
+// of a package-owned file, such as the usrmerge symlinks of the filesystem
+// package that later layers often recreate, while other modifications to the
+// same files are still disallowed. A directory replaced by a symlink is still
+// disallowed, as are the paths given to WithAlwaysFailPaths.
+//
+// This is only safe when the symlinks written by the layers being checked are
+// trusted, as a symlink in place of a package-owned binary that points to a
+// different file is allowed as well.
+func WithModifiedSymlinksAllowed(allow bool) Option {
+	return func(o *options) {
+		o.allowModifiedSymlinks = allow
+	}
+}
+
+// WithStrictNormalization reports each entry of a layer being checked whose
+// name has a ".." element, such as usr/lib/../bin/sh, in the SuspiciousEntries
+// of the report, which fails the scan. Such a name is cleaned to the path it
+// resolves to like any other, so that it may modify a package-owned file
+// without appearing to, and no legitimate layer is built with one.
+func WithStrictNormalization(strict bool) Option {
+	return func(o *options) {
+		o.strictNormalization = strict
+	}
+}
+
+// WithConfigAdvisories records the modifications to files flagged as config
+// files in the RPMDB, which are allowed when config is one of the modifiable
+// file flags, as advisories in the report. Advisories do not fail the scan. It
+// has no effect on images whose package database is not an RPMDB.
+func WithConfigAdvisories(advise bool) Option {
+	return func(o *options) {
+		o.configAdvisories = advise
+	}
+}
+
+// WithInstallLayerCheck also checks the layer that contained the RPMDB, which
+// is otherwise assumed to be unmodified, by comparing the content of each
+// package-owned file in it against the digest recorded for it in the RPMDB.
+// This finds files modified by the same RUN instruction that installed them.
+// It has no effect on images whose package database is not an RPMDB.
+func WithInstallLayerCheck(check bool) Option {
+	return func(o *options) {
+		o.checkInstallLayer = check
+	}
+}
+
+// WithProgress reports each layer to w as it is read, along with the number
+// of layers read so far out of the total. If inPlace is true, which it should
+// only be when w is a terminal, a single line is redrawn rather than writing a
+// line per layer.
+func WithProgress(w io.Writer, inPlace bool) Option {
+	return func(o *options) {
+		o.progress = w
+		o.progressInPlace = inPlace
+	}
+}
+
+// WithAllowedPackages allows any modification to the files owned by the
+// packages named by names, matched against the name of the package rather than
+// its version. It is checked after the file flags and path exclusions, so it
+// only adds to what they allow.
+func WithAllowedPackages(names ...string) Option {
+	return func(o *options) {
+		if o.allowedPackages == nil {
+			o.allowedPackages = map[string]struct{}{}
+		}
+		for _, name := range names {
+			o.allowedPackages[name] = struct{}{}
+		}
+	}
+}
+
+// WithOnlyPackages restricts the files checked to those owned by the packages
+// named by names, matched against the name of the package rather than its
+// version, ignoring the files of every other package. InstalledFileMap then
+// only returns their files. It is an error if none of them own a file.
+func WithOnlyPackages(names ...string) Option {
+	return func(o *options) {
+		if o.onlyPackages == nil {
+			o.onlyPackages = map[string]struct{}{}
+		}
+		for _, name := range names {
+			o.onlyPackages[name] = struct{}{}
+		}
+	}
+}
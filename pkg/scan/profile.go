@@ -0,0 +1,55 @@
+package scan
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Built-in exclusion profiles, each listing the directories and paths that are
+// expected to change on top of a distribution's base image.
+const (
+	ProfileUBI    = "ubi"
+	ProfileDebian = "debian"
+	ProfileAlpine = "alpine"
+	// ProfileAuto selects the profile for the package manager whose database
+	// is found in the image.
+	ProfileAuto = "auto"
+)
+
+//go:embed profiles/*.json
+var profiles embed.FS
+
+// profileForManager maps each package manager to the profile ProfileAuto
+// selects for it.
+var profileForManager = map[string]string{
+	PackageManagerRPM:  ProfileUBI,
+	PackageManagerDpkg: ProfileDebian,
+	PackageManagerApk:  ProfileAlpine,
+}
+
+// ProfileNames returns the names of the built-in exclusion profiles, sorted.
+func ProfileNames() []string {
+	entries, _ := profiles.ReadDir("profiles")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProfileExclusions returns the exclusions of the built-in profile name.
+func ProfileExclusions(name string) (Exclusions, error) {
+	var e Exclusions
+	b, err := profiles.ReadFile("profiles/" + name + ".json")
+	if err != nil {
+		return e, fmt.Errorf("unknown profile %q, one of: %s", name, strings.Join(ProfileNames(), ", "))
+	}
+	if err := json.Unmarshal(b, &e); err != nil {
+		return e, fmt.Errorf("parsing profile %s: %w", name, err)
+	}
+	return e, nil
+}
@@ -0,0 +1,55 @@
+package scan
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestProfileExclusions(t *testing.T) {
+	expected := []string{ProfileAlpine, ProfileDebian, ProfileUBI}
+	if actual := ProfileNames(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+
+	for _, name := range ProfileNames() {
+		e, err := ProfileExclusions(name)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", name, err)
+		}
+		if len(e.Directories) == 0 || len(e.Paths) == 0 {
+			t.Fatalf("expected %s to have exclusions, got %+v", name, e)
+		}
+		if _, err := CompilePathPatterns(e.Paths); err != nil {
+			t.Fatalf("unexpected error compiling %s: %v", name, err)
+		}
+	}
+
+	if _, err := ProfileExclusions("gentoo"); err == nil {
+		t.Fatalf("expected an error for an unknown profile")
+	}
+}
+
+func TestScanImageProfile(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled + "F:usr/share/mime\nR:mime.cache\n"}),
+		testLayer(t, testEntry{name: "usr/share/mime/mime.cache", content: "updated"}),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, found := report.DisallowedModifications["usr/share/mime/mime.cache"]; !found {
+		t.Fatalf("expected usr/share/mime/mime.cache to be reported without a profile, got %v", report.DisallowedModifications)
+	}
+
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithProfile(ProfileAuto))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.DisallowedModifications) != 0 {
+		t.Fatalf("expected the alpine profile to exclude usr/share/mime/mime.cache, got %v", report.DisallowedModifications)
+	}
+}
@@ -0,0 +1,53 @@
+package scan
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/charmbracelet/lipgloss"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+var faint = lipgloss.NewStyle().Faint(true).Render
+
+// progress reports the layers that have been read out of the total. A nil
+// progress reports nothing.
+type progress struct {
+	w io.Writer
+	// inPlace redraws a single line rather than writing a line per layer,
+	// for when w is a terminal.
+	inPlace bool
+	total   int
+
+	mu   sync.Mutex
+	done int
+}
+
+func newProgress(w io.Writer, inPlace bool, total int) *progress {
+	if w == nil {
+		return nil
+	}
+	return &progress{w: w, inPlace: inPlace, total: total}
+}
+
+// layerRead reports that the layer with the digest id has been read.
+func (p *progress) layerRead(id v1.Hash) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	line := faint(fmt.Sprintf("layer %d/%d", p.done, p.total)) + " " + id.String()
+	if p.inPlace {
+		// return to the start of the line and clear it before redrawing.
+		fmt.Fprint(p.w, "\r\033[K", line)
+		if p.done == p.total {
+			fmt.Fprintln(p.w)
+		}
+		return
+	}
+	fmt.Fprintln(p.w, line)
+}
@@ -0,0 +1,35 @@
+package scan
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+)
+
+func TestProgress(t *testing.T) {
+	id := v1.Hash{Algorithm: "sha256", Hex: "abc"}
+
+	var buf bytes.Buffer
+	p := newProgress(&buf, false, 2)
+	p.layerRead(id)
+	p.layerRead(id)
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[1], "layer 2/2") || !strings.HasSuffix(lines[1], "sha256:abc") {
+		t.Fatalf("expected a line per layer, got %q", buf.String())
+	}
+
+	buf.Reset()
+	p = newProgress(&buf, true, 2)
+	p.layerRead(id)
+	if strings.Contains(buf.String(), "\n") {
+		t.Fatalf("expected the line to be redrawn in place, got %q", buf.String())
+	}
+	p.layerRead(id)
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Fatalf("expected the line to be ended after the last layer, got %q", buf.String())
+	}
+
+	// a nil progress reports nothing.
+	newProgress(nil, false, 1).layerRead(id)
+}
@@ -0,0 +1,105 @@
+package scan
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// retryStatusCodes are the registry responses that are retried, as they
+// report rate limiting or a registry that is temporarily unavailable.
+var retryStatusCodes = map[int]struct{}{
+	http.StatusTooManyRequests:    {},
+	http.StatusBadGateway:         {},
+	http.StatusServiceUnavailable: {},
+	http.StatusGatewayTimeout:     {},
+}
+
+// retryTransport retries requests that fail with a network error or one of
+// the retryStatusCodes, waiting backoff before the first retry and twice as
+// long before each one after it, or as long as the registry asks with a
+// Retry-After header if that is longer.
+type retryTransport struct {
+	inner      http.RoundTripper
+	maxRetries int
+	backoff    time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	wait := t.backoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+		resp, err := t.inner.RoundTrip(req)
+
+		retry, reason := retryable(resp, err)
+		// a request whose body cannot be replayed can only be sent once.
+		if !retry || (req.Body != nil && req.GetBody == nil) {
+			return resp, err
+		}
+		if attempt == t.maxRetries {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, fmt.Errorf("giving up on %s %s after %d retries: %s", req.Method, req.URL.Redacted(), t.maxRetries, reason)
+		}
+
+		delay := wait
+		if resp != nil {
+			if after := retryAfter(resp.Header.Get("Retry-After"), time.Now()); after > delay {
+				delay = after
+			}
+			// the body is drained so that the connection can be reused.
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		wait *= 2
+	}
+}
+
+// retryable reports whether a request that got resp or err should be retried,
+// along with the reason.
+func retryable(resp *http.Response, err error) (bool, string) {
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return true, err.Error()
+		}
+		return false, ""
+	}
+	if _, found := retryStatusCodes[resp.StatusCode]; found {
+		return true, resp.Status
+	}
+	return false, ""
+}
+
+// retryAfter returns how long the Retry-After header value asks to wait as of
+// now, which is either a number of seconds or an HTTP date.
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t.Sub(now)
+	}
+	return 0
+}
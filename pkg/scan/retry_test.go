@@ -0,0 +1,71 @@
+package scan
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &retryTransport{inner: http.DefaultTransport, maxRetries: 2, backoff: time.Millisecond}}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests != 3 {
+		t.Fatalf("expected to succeed on the third request, got %s after %d", resp.Status, requests)
+	}
+
+}
+
+func TestRetryTransportExhausted(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: &retryTransport{inner: http.DefaultTransport, maxRetries: 2, backoff: time.Millisecond}}
+	_, err := client.Get(server.URL)
+	if err == nil || !strings.Contains(err.Error(), "after 2 retries: 503 Service Unavailable") {
+		t.Fatalf("expected to give up after 2 retries, got %v", err)
+	}
+	if requests != 3 {
+		t.Fatalf("want=3 requests, got=%d", requests)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"30", 30 * time.Second},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{"soon", 0},
+	}
+
+	for _, test := range tests {
+		if actual := retryAfter(test.value, now); actual != test.expected {
+			t.Fatalf("want=%v, got=%v for %q", test.expected, actual, test.value)
+		}
+	}
+}
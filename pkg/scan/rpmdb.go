@@ -0,0 +1,496 @@
+package scan
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+// FindRPMDB attempts to extract a valid RPMDB from layers in the order they
+// are provided, returning the index of the first layer that contains one along
+// with its packages. A layer that cannot be read, or whose RPMDB cannot be,
+// is skipped, as a later layer may still contain a valid RPMDB. If no layer
+// contains one, this returns the ErrLayerRead or ErrInvalidPackageDB of the
+// first layer that was skipped, or ErrRPMDBNotFound if there was none.
+func FindRPMDB(ctx context.Context, layers []v1.Layer) (int, []*rpmdb.PackageInfo, error) {
+	return findRPMDB(ctx, layers, defaultRPMDBLocation, defaultExtractionLimits, "", nil, false, nil)
+}
+
+// FindLastRPMDB is like FindRPMDB, but returns the last layer that contains a
+// valid RPMDB. A layer that installs, removes, or upgrades packages writes a
+// new copy of the database, so the last copy is the one that describes the
+// packages installed in the final image.
+func FindLastRPMDB(ctx context.Context, layers []v1.Layer) (int, []*rpmdb.PackageInfo, error) {
+	return findRPMDB(ctx, layers, defaultRPMDBLocation, defaultExtractionLimits, "", nil, true, nil)
+}
+
+// findRPMDB implements FindRPMDB, extracting the RPMDB at loc within limits
+// into tempDir through cache, which may be nil, searching layers from the last if last is set, and calling
+// skipped, if not nil, with each layer that is skipped because it could not be
+// read.
+func findRPMDB(ctx context.Context, layers []v1.Layer, loc rpmdbLocation, limits extractionLimits, tempDir string, cache *RPMDBCache, last bool, skipped func(i int, err error)) (int, []*rpmdb.PackageInfo, error) {
+	var readErr error
+	for n := range layers {
+		i := n
+		if last {
+			i = len(layers) - 1 - n
+		}
+		pkglist, err := cache.extract(ctx, layers[i], loc, limits, tempDir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			// a canceled scan cannot find the RPMDB in any other layer.
+			if ctx.Err() != nil {
+				return 0, nil, err
+			}
+			if readErr == nil {
+				readErr = err
+			}
+			if skipped != nil {
+				skipped(i, err)
+			}
+			continue
+		}
+		return i, pkglist, nil
+	}
+
+	if readErr != nil {
+		return 0, nil, readErr
+	}
+	return 0, nil, ErrRPMDBNotFound
+}
+
+// RPMDBCache memoizes the packages listed by the RPMDB in each layer, keyed by
+// the digest of the layer, so that a layer read more than once, such as a base
+// layer shared by the images of a batch, is only parsed once. The packages it
+// returns are shared, and must not be modified. It is safe for concurrent use.
+type RPMDBCache struct {
+	mu      sync.Mutex
+	entries map[rpmdbCacheKey]*rpmdbCacheEntry
+}
+
+// rpmdbCacheKey identifies the RPMDB at a location, given by its dirs, in the
+// layer with a digest, as extracted within limits, which decide whether it
+// can be.
+type rpmdbCacheKey struct {
+	digest v1.Hash
+	dirs   string
+	limits extractionLimits
+}
+
+// rpmdbCacheEntry is the result of extracting an RPMDB, which is only done
+// once however many scans ask for it at the same time.
+type rpmdbCacheEntry struct {
+	once     sync.Once
+	packages []*rpmdb.PackageInfo
+	err      error
+}
+
+// NewRPMDBCache returns an empty RPMDBCache.
+func NewRPMDBCache() *RPMDBCache {
+	return &RPMDBCache{entries: map[rpmdbCacheKey]*rpmdbCacheEntry{}}
+}
+
+// extract is like extractRPMDBFrom, but returns the packages from an earlier
+// extraction of the same RPMDB if there was one. A nil cache extracts the
+// RPMDB every time. Errors reading the layer, which may only be because the
+// scan that extracted it was canceled or could not write to its tempDir, are
+// not kept, so that the layer is read again next time, unlike a database that
+// cannot be parsed. A scan waiting on the extraction of another that fails
+// this way extracts it again itself, rather than sharing the other's error.
+func (c *RPMDBCache) extract(ctx context.Context, layer v1.Layer, loc rpmdbLocation, limits extractionLimits, tempDir string) ([]*rpmdb.PackageInfo, error) {
+	if c == nil {
+		return extractRPMDBFrom(ctx, layer, loc, limits, tempDir)
+	}
+	digest, err := layer.Digest()
+	if err != nil {
+		return extractRPMDBFrom(ctx, layer, loc, limits, tempDir)
+	}
+	key := rpmdbCacheKey{digest: digest, dirs: strings.Join(loc.dirs, "\x00"), limits: limits}
+
+	for {
+		c.mu.Lock()
+		e, ok := c.entries[key]
+		if !ok {
+			e = &rpmdbCacheEntry{}
+			c.entries[key] = e
+		}
+		c.mu.Unlock()
+
+		var extracted bool
+		e.once.Do(func() {
+			extracted = true
+			e.packages, e.err = extractRPMDBFrom(ctx, layer, loc, limits, tempDir)
+			if e.err != nil && !errors.Is(e.err, os.ErrNotExist) && !errors.Is(e.err, ErrInvalidPackageDB) {
+				c.mu.Lock()
+				if c.entries[key] == e {
+					delete(c.entries, key)
+				}
+				c.mu.Unlock()
+			}
+		})
+		if extracted || e.err == nil || errors.Is(e.err, os.ErrNotExist) || errors.Is(e.err, ErrInvalidPackageDB) {
+			return e.packages, e.err
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// extractRPMDBFrom extracts the RPMDB at loc from layer within limits into
+// tempDir, wrapping any error other than os.ErrNotExist or
+// ErrInvalidPackageDB, for a database that is present but cannot be read, as
+// ErrLayerRead.
+func extractRPMDBFrom(ctx context.Context, layer v1.Layer, loc rpmdbLocation, limits extractionLimits, tempDir string) ([]*rpmdb.PackageInfo, error) {
+	pkglist, err := extractRPMDB(ctx, layer, loc, limits, tempDir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		id, _ := layer.Digest()
+		err = fmt.Errorf("extracting rpmdb from layer %s: %w", id, err)
+		if errors.Is(err, ErrInvalidPackageDB) {
+			return nil, err
+		}
+		return nil, wrap(ErrLayerRead, err)
+	}
+	return pkglist, err
+}
+
+// writesRPMDB reports whether changes write to an RPMDB directory at loc, as a
+// layer that installs, removes, or upgrades packages does.
+func writesRPMDB(changes []Change, loc rpmdbLocation) bool {
+	for _, change := range changes {
+		if change.Kind == ChangeDeleted {
+			continue
+		}
+		for _, dir := range loc.dirs {
+			if strings.HasPrefix(change.Path, dir+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// removesRPMDB reports whether changes remove the RPMDB at loc, by deleting
+// the directory it is kept in or one of its parents, or by replacing its
+// contents with an opaque whiteout, without writing a new copy of it. links are
+// the symlinks in the image before the changes.
+func removesRPMDB(changes []Change, links symlinks, loc rpmdbLocation) bool {
+	if writesRPMDB(changes, loc) {
+		return false
+	}
+	for _, change := range changes {
+		if change.Kind != ChangeDeleted && change.Kind != ChangeOpaque {
+			continue
+		}
+		removed := links.canonical(change.Path)
+		for _, dir := range loc.dirs {
+			// a symlink to the directory may be deleted without removing it.
+			dir = links.resolve(dir)
+			if removed == "." || dir == removed || strings.HasPrefix(dir, removed+"/") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rpmdbDirs are the directories GetPackageList looks for an rpm database in,
+// in order. Newer distributions keep the database in /usr/lib/sysimage/rpm,
+// with /var/lib/rpm as a symlink to it.
+var rpmdbDirs = []string{"var/lib/rpm", "usr/lib/sysimage/rpm"}
+
+// rpmdbFiles are the files in an RPMDB directory that GetPackageList may
+// open. Nothing else in the directory is needed to list the installed
+// packages.
+var rpmdbFiles = map[string]struct{}{
+	"rpmdb.sqlite": {},
+	"Packages":     {},
+	"Packages.db":  {},
+}
+
+// rpmdbLinks are the symlinks that may lead to the rpm database in
+// /var/lib/rpm, and so are recreated when it is extracted.
+var rpmdbLinks = map[string]struct{}{
+	"var":                      {},
+	"var/lib":                  {},
+	"var/lib/rpm":              {},
+	"var/lib/rpm/rpmdb.sqlite": {},
+	"var/lib/rpm/Packages":     {},
+	"var/lib/rpm/Packages.db":  {},
+}
+
+// rpmdbLocation is where an rpm database is looked for in a layer.
+type rpmdbLocation struct {
+	// dirs are the directories the database is looked for in, in order.
+	dirs []string
+	// links are the symlinks that may lead to the database, and so are
+	// recreated when it is extracted.
+	links map[string]struct{}
+}
+
+// defaultRPMDBLocation looks for the database in the rpmdbDirs.
+var defaultRPMDBLocation = rpmdbLocation{dirs: rpmdbDirs, links: rpmdbLinks}
+
+// rpmdbLocationAt returns the location of an rpm database kept in dir instead
+// of the rpmdbDirs, for images that relocate it. The symlinks that may lead to
+// it are dir, its parents, and the rpmdbFiles in it.
+func rpmdbLocationAt(dir string) rpmdbLocation {
+	dir = strings.TrimPrefix(path.Clean("/"+dir), "/")
+	loc := rpmdbLocation{dirs: []string{dir}, links: map[string]struct{}{}}
+	for p := dir; p != "." && p != ""; p = path.Dir(p) {
+		loc.links[p] = struct{}{}
+	}
+	for name := range rpmdbFiles {
+		loc.links[path.Join(dir, name)] = struct{}{}
+	}
+	return loc
+}
+
+// rpmdbRoot returns the directory the files listed by an rpm database kept in
+// dir are installed under: the root of a nested system if dir is one of the
+// rpmdbDirs under it, as when installing with rpm --root, and the root of the
+// image otherwise.
+func rpmdbRoot(dir string) string {
+	for _, d := range rpmdbDirs {
+		if root := strings.TrimSuffix(dir, "/"+d); root != dir {
+			return root
+		}
+	}
+	return ""
+}
+
+// mayHold reports whether dir, a directory in a layer, may hold the database,
+// because it has the same name as one of the dirs. A symlink to one of the
+// dirs may lead to any such directory.
+func (loc rpmdbLocation) mayHold(dir string) bool {
+	for _, d := range loc.dirs {
+		if path.Base(d) == path.Base(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractRPMDB copies the rpm database in /var/lib/rpm from the archive and
+// derives a list of packages from it. If the layer does not contain an rpm database, this returns
+// an error of type os.ErrNotExist.
+func ExtractRPMDB(ctx context.Context, layer v1.Layer) ([]*rpmdb.PackageInfo, error) {
+	return extractRPMDB(ctx, layer, defaultRPMDBLocation, defaultExtractionLimits, "")
+}
+
+// extractRPMDB is like ExtractRPMDB, but copies the rpm database at loc within
+// limits to a temporary directory in tempDir, or the default directory for
+// temporary files if it is empty.
+func extractRPMDB(ctx context.Context, layer v1.Layer, loc rpmdbLocation, limits extractionLimits, tempDir string) ([]*rpmdb.PackageInfo, error) {
+	// the temporary directory is removed however this returns, including by
+	// a panic while the database is being copied or read.
+	basepath, err := os.MkdirTemp(tempDir, "rpmdb-*")
+	if err != nil {
+		// not wrapped, as a missing tempDir is not a layer without an RPMDB.
+		return nil, fmt.Errorf("creating temporary directory: %v", err)
+	}
+	defer os.RemoveAll(basepath)
+
+	if err := extractRPMDBFiles(ctx, layer, basepath, loc, limits); err != nil {
+		return nil, err
+	}
+
+	packageList, err := getPackageList(ctx, basepath, loc.dirs)
+	if err != nil {
+		return nil, err
+	}
+
+	return packageList, nil
+}
+
+// extractRPMDBFiles copies the rpmdbFiles in any directory in layer that may
+// hold the database at loc to the same paths under basepath, and recreates the
+// links of loc so that they resolve to the same paths under basepath. Absolute
+// paths in the layer are treated as relative to its root, and a path that
+// escapes the root with .. is an error, as is one written through a link
+// already recreated, or copying more than limits allow.
+func extractRPMDBFiles(ctx context.Context, layer v1.Layer, basepath string, loc rpmdbLocation, limits extractionLimits) error {
+	layerReader, err := layer.Uncompressed()
+	if err != nil {
+		return fmt.Errorf("reading layer contents: %w", err)
+	}
+	defer layerReader.Close()
+
+	extracted := limits.start()
+	tarReader := tar.NewReader(layerReader)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("reading tar: %w", err)
+		}
+
+		// Some tools prepend everything with "./", so if we don't Clean the
+		// name, we may have duplicate entries, which angers tar-split.
+		header.Name = path.Clean(header.Name)
+		if isRootName(header.Name) {
+			continue
+		}
+		basename := path.Base(header.Name)
+		dirname := path.Dir(header.Name)
+		dest, err := safeJoin(basepath, header.Name)
+		if err != nil {
+			return err
+		}
+
+		if _, link := loc.links[strings.TrimPrefix(header.Name, "/")]; link && header.Typeflag == tar.TypeSymlink {
+			if err := checkSymlinkParents(basepath, dest, header.Name); err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			// the link replaces anything already extracted to its path.
+			os.Remove(dest)
+			if err := os.Symlink(relativeLinkTarget(header.Name, header.Linkname), dest); err != nil {
+				return fmt.Errorf("linking %s: %w", header.Name, err)
+			}
+			continue
+		}
+
+		// only the database files directly in a directory that may hold the
+		// database are needed. A whiteout is never one of them, as its name
+		// has the whiteout prefix.
+		if _, needed := rpmdbFiles[basename]; !needed || header.Typeflag != tar.TypeReg || !loc.mayHold(dirname) {
+			continue
+		}
+		if err := checkSymlinkParents(basepath, dest, header.Name); err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dest, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		err = func() error {
+			// closure here allows us to defer f.Close() in this iteration instead of
+			// waiting for the parent function to complete.
+			defer f.Close()
+			return extracted.copy(f, tarReader, header.Name)
+		}()
+		if err != nil {
+			return fmt.Errorf("copying %s: %w", header.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// safeJoin returns the path under basepath that name, a path in a layer,
+// would be extracted to. It returns an error if name escapes the root of the
+// layer, which would place it outside basepath.
+func safeJoin(basepath, name string) (string, error) {
+	name = path.Clean(strings.TrimPrefix(name, "/"))
+	if name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("tar entry %s escapes the root of the layer", name)
+	}
+	return filepath.Join(basepath, filepath.FromSlash(name)), nil
+}
+
+// checkSymlinkParents returns an error if any parent of dest, the path under
+// basepath that the tar entry name is extracted to, is a symlink. A link
+// recreated by extractRPMDBFiles resolves within basepath only from its own
+// path, so a chain of links written through one another could otherwise place
+// dest, or the links after it, outside basepath.
+func checkSymlinkParents(basepath, dest, name string) error {
+	rel, err := filepath.Rel(basepath, filepath.Dir(dest))
+	if err != nil {
+		return err
+	}
+	p := basepath
+	for _, element := range strings.Split(rel, string(filepath.Separator)) {
+		if element == "." {
+			continue
+		}
+		p = filepath.Join(p, element)
+		info, err := os.Lstat(p)
+		if err != nil {
+			// nothing has been extracted below a parent that does not exist.
+			return nil
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, _ := filepath.Rel(basepath, p)
+			return fmt.Errorf("tar entry %s is written through the symlink %s", name, filepath.ToSlash(link))
+		}
+	}
+	return nil
+}
+
+// relativeLinkTarget returns the target of the symlink at name in a layer as a
+// path relative to the symlink's directory. Absolute targets, and relative
+// targets with more .. than the symlink has parents, resolve against the root
+// of the layer, so the result never resolves outside of it.
+func relativeLinkTarget(name, target string) string {
+	dir := path.Dir(path.Join("/", name))
+	if !path.IsAbs(target) {
+		target = path.Join(dir, target)
+	}
+	rel, _ := filepath.Rel(filepath.FromSlash(dir), filepath.FromSlash(path.Clean(target)))
+	return rel
+}
+
+// GetPackageList returns the list of packages in the rpm database in the first
+// of rpmdbDirs under basePath that contains one. In each directory, it reads
+// rpmdb.sqlite, or Packages if the former does not exist, or the ndb database
+// Packages.db used by SUSE if neither does.
+// If none exists, this returns an error of type os.ErrNotExists, and if one
+// exists but cannot be opened or listed, as when it is corrupt, an
+// ErrInvalidPackageDB.
+// NOTE: Borrowed from existing preflight code.
+func GetPackageList(ctx context.Context, basePath string) ([]*rpmdb.PackageInfo, error) {
+	return getPackageList(ctx, basePath, rpmdbDirs)
+}
+
+// getPackageList is like GetPackageList, but looks in dirs instead of the
+// rpmdbDirs.
+func getPackageList(ctx context.Context, basePath string, dirs []string) ([]*rpmdb.PackageInfo, error) {
+	var err error
+	for _, dir := range dirs {
+		rpmdirPath := filepath.Join(basePath, filepath.FromSlash(dir))
+		for _, name := range []string{"rpmdb.sqlite", "Packages", "Packages.db"} {
+			rpmdbPath := filepath.Join(rpmdirPath, name)
+			if _, err = os.Stat(rpmdbPath); errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+
+			db, err := rpmdb.Open(rpmdbPath)
+			if err != nil {
+				return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("could not open rpm db %s: %v", path.Join(dir, name), err))
+			}
+			pkgList, err := db.ListPackages()
+			if err != nil {
+				return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("could not list packages in rpm db %s: %v", path.Join(dir, name), err))
+			}
+
+			return pkgList, nil
+		}
+	}
+
+	// if none of the paths exist - this probably isn't a RHEL or UBI based image
+	return nil, err
+}
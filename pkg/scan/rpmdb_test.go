@@ -0,0 +1,635 @@
+package scan
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+// truncatedLayer returns a layer whose var/lib/rpm/Packages entry ends before
+// its declared size.
+func truncatedLayer(t *testing.T) v1.Layer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "var/lib/rpm/Packages", Typeflag: tar.TypeReg, Mode: 0644, Size: 4096}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("partial")); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(b)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestFindRPMDBNotFound(t *testing.T) {
+	layers := []v1.Layer{
+		testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"}),
+		testLayer(t, testEntry{name: "var/lib/rpm/", typeflag: tar.TypeDir}),
+	}
+
+	for _, find := range []func(context.Context, []v1.Layer) (int, []*rpmdb.PackageInfo, error){FindRPMDB, FindLastRPMDB} {
+		_, _, err := find(context.Background(), layers)
+		if !errors.Is(err, ErrRPMDBNotFound) {
+			t.Fatalf("want=%v, got=%v", ErrRPMDBNotFound, err)
+		}
+	}
+}
+
+func TestFindRPMDBReadError(t *testing.T) {
+	layers := []v1.Layer{truncatedLayer(t)}
+
+	for _, find := range []func(context.Context, []v1.Layer) (int, []*rpmdb.PackageInfo, error){FindRPMDB, FindLastRPMDB} {
+		_, _, err := find(context.Background(), layers)
+		if errors.Is(err, ErrRPMDBNotFound) {
+			t.Fatalf("read error was misreported as %v", err)
+		}
+		if !errors.Is(err, ErrLayerRead) {
+			t.Fatalf("want=%v, got=%v", ErrLayerRead, err)
+		}
+	}
+}
+
+func TestFindRPMDBSkipsReadError(t *testing.T) {
+	valid := testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)})
+
+	for _, last := range []bool{false, true} {
+		layers := []v1.Layer{truncatedLayer(t), valid}
+		expected := 1
+		if last {
+			layers = []v1.Layer{valid, truncatedLayer(t)}
+			expected = 0
+		}
+
+		var skipped []int
+		i, pkglist, err := findRPMDB(context.Background(), layers, defaultRPMDBLocation, defaultExtractionLimits, "", nil, last, func(i int, err error) {
+			if !errors.Is(err, ErrLayerRead) {
+				t.Fatalf("want=%v, got=%v", ErrLayerRead, err)
+			}
+			skipped = append(skipped, i)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if i != expected || len(pkglist) == 0 {
+			t.Fatalf("want=layer %d with packages, got=layer %d with %d packages", expected, i, len(pkglist))
+		}
+		if len(skipped) != 1 || skipped[0] != 1-expected {
+			t.Fatalf("want=[%d], got=%v", 1-expected, skipped)
+		}
+	}
+}
+
+func TestFindRPMDBCorrupt(t *testing.T) {
+	corrupt := testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: strings.Repeat("not an rpmdb", 1024)})
+
+	_, _, err := FindRPMDB(context.Background(), []v1.Layer{corrupt})
+	if !errors.Is(err, ErrInvalidPackageDB) || errors.Is(err, ErrLayerRead) || errors.Is(err, ErrRPMDBNotFound) {
+		t.Fatalf("want=%v, got=%v", ErrInvalidPackageDB, err)
+	}
+
+	// it is still skipped for a valid RPMDB in a later layer, but is logged.
+	var buf bytes.Buffer
+	img := testImage(t,
+		corrupt,
+		testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		testLayer(t, testEntry{name: "etc/motd", content: "welcome"}),
+	)
+	report, err := ScanImage(context.Background(), img, WithOutput(&buf))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.RPMDBLayerIndex != 1 {
+		t.Fatalf("want=%v, got=%v", 1, report.RPMDBLayerIndex)
+	}
+	if !strings.Contains(buf.String(), "present but could not be parsed") {
+		t.Fatalf("expected the corrupt rpmdb to be logged, got %s", buf.String())
+	}
+}
+
+func TestFindRPMDBCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	layers := []v1.Layer{truncatedLayer(t), testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)})}
+
+	_, _, err := findRPMDB(ctx, layers, defaultRPMDBLocation, defaultExtractionLimits, "", nil, false, func(i int, err error) {
+		t.Fatalf("layer %d was skipped after the scan was canceled", i)
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want=%v, got=%v", context.Canceled, err)
+	}
+}
+
+func TestExtractRPMDBCopyError(t *testing.T) {
+	pkglist, err := ExtractRPMDB(context.Background(), truncatedLayer(t))
+	if err == nil {
+		t.Fatalf("expected an error, got %d packages", len(pkglist))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("copy error was misreported as %v", err)
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("want=%v, got=%v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestExtractRPMDBFilesEscape(t *testing.T) {
+	for _, name := range []string{"../../etc/passwd", "var/lib/rpm/../../../../Packages"} {
+		layer := testLayer(t, testEntry{name: name, content: "escaped"})
+
+		dir := filepath.Join(t.TempDir(), "base")
+		err := extractRPMDBFiles(context.Background(), layer, dir, defaultRPMDBLocation, defaultExtractionLimits)
+		if err == nil || !strings.Contains(err.Error(), "escapes the root") {
+			t.Fatalf("expected %s to be rejected, got %v", name, err)
+		}
+		if entries, _ := os.ReadDir(filepath.Dir(dir)); len(entries) != 0 {
+			t.Fatalf("expected nothing to be written for %s, got %v", name, entries)
+		}
+	}
+}
+
+func TestExtractRPMDBFilesSymlinkChain(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "var/lib", typeflag: tar.TypeSymlink, linkname: "/"},
+		testEntry{name: "var/lib/rpm", typeflag: tar.TypeSymlink, linkname: "/"},
+		testEntry{name: "var/lib/rpm/Packages", content: "escaped"},
+	)
+
+	dir := filepath.Join(t.TempDir(), "a", "b", "base")
+	err := extractRPMDBFiles(context.Background(), layer, dir, defaultRPMDBLocation, defaultExtractionLimits)
+	if err == nil || !strings.Contains(err.Error(), "through the symlink var/lib") {
+		t.Fatalf("expected the chain of links to be rejected, got %v", err)
+	}
+	for _, parent := range []string{filepath.Dir(dir), filepath.Dir(filepath.Dir(dir))} {
+		if entries, _ := os.ReadDir(parent); len(entries) != 1 {
+			t.Fatalf("expected nothing to be written outside %s, got %v in %s", dir, entries, parent)
+		}
+	}
+}
+
+func TestExtractRPMDBFiles(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "var/lib/rpm/", typeflag: tar.TypeDir},
+		testEntry{name: "var/lib/rpm/Packages", content: "packages"},
+		testEntry{name: "var/lib/rpm/Basenames", content: "index"},
+		testEntry{name: "var/lib/rpm/__db.001", content: "environment"},
+		testEntry{name: "./var/lib/rpm/rpmdb.sqlite", content: "sqlite"},
+		testEntry{name: "var/lib/rpm/sub/Packages", content: "nested"},
+		testEntry{name: "usr/bin/foo", content: "foo"},
+	)
+
+	dir := t.TempDir()
+	if err := extractRPMDBFiles(context.Background(), layer, dir, defaultRPMDBLocation, defaultExtractionLimits); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var actual []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			actual = append(actual, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"var/lib/rpm/Packages", "var/lib/rpm/rpmdb.sqlite"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestExtractRPMDBNDB(t *testing.T) {
+	layer := testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)})
+
+	pkglist, err := ExtractRPMDB(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var found bool
+	for _, pkg := range pkglist {
+		found = found || pkg.Name == "bash"
+	}
+	if !found {
+		t.Fatalf("expected bash to be installed, got %d packages", len(pkglist))
+	}
+}
+
+func TestExtractRPMDBRootEntries(t *testing.T) {
+	layer := testLayer(t,
+		testEntry{name: "./", typeflag: tar.TypeDir},
+		testEntry{name: ".", typeflag: tar.TypeDir},
+		testEntry{name: "/", typeflag: tar.TypeDir},
+		testEntry{name: "."},
+		testEntry{name: "./var/lib/rpm/Packages.db", content: testRPMDB(t)},
+	)
+
+	pkglist, err := ExtractRPMDB(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(pkglist) == 0 {
+		t.Fatalf("expected packages, got none")
+	}
+}
+
+func TestExtractRPMDBTempDir(t *testing.T) {
+	layer := testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)})
+
+	dir := t.TempDir()
+	if _, err := extractRPMDB(context.Background(), layer, defaultRPMDBLocation, defaultExtractionLimits, dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Fatalf("want=%v, got=%v %v", "no entries", entries, err)
+	}
+
+	missing := filepath.Join(dir, "missing")
+	if _, err := extractRPMDB(context.Background(), layer, defaultRPMDBLocation, defaultExtractionLimits, missing); err == nil || errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want=%v, got=%v", "an error creating the temporary directory", err)
+	}
+}
+
+func TestExtractRPMDBFixture(t *testing.T) {
+	db := testRPMDB(t)
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"plain", []testEntry{
+			{name: "var/lib/rpm/Packages.db", content: db},
+		}},
+		{"nested directories", []testEntry{
+			{name: "./", typeflag: tar.TypeDir},
+			{name: "./var/", typeflag: tar.TypeDir},
+			{name: "./var/lib/", typeflag: tar.TypeDir},
+			{name: "./var/lib/rpm/", typeflag: tar.TypeDir},
+			{name: "./var/lib/rpm/Packages.db", content: db},
+			{name: "./usr/bin/bash", content: "bash"},
+		}},
+		{"absolute", []testEntry{
+			{name: "/var/lib/rpm/Packages.db", content: db},
+		}},
+	}
+
+	for _, test := range tests {
+		pkglist, err := ExtractRPMDB(context.Background(), testLayer(t, test.entries...))
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		if len(pkglist) != 35 {
+			t.Fatalf("want=35 packages, got=%d for %s", len(pkglist), test.name)
+		}
+		nvrs := map[string]struct{}{}
+		for _, pkg := range pkglist {
+			nvrs[packageNVR(pkg)] = struct{}{}
+		}
+		if _, found := nvrs["bash-4.4-19.6.1"]; !found {
+			t.Fatalf("expected bash-4.4-19.6.1 to be installed for %s, got %v", test.name, nvrs)
+		}
+	}
+}
+
+func TestExtractRPMDBSymlink(t *testing.T) {
+	db := testRPMDB(t)
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"relative directory link", []testEntry{
+			{name: "usr/lib/sysimage/rpm/Packages.db", content: db},
+			{name: "var/lib/rpm", typeflag: tar.TypeSymlink, linkname: "../../usr/lib/sysimage/rpm"},
+		}},
+		{"absolute directory link", []testEntry{
+			{name: "var/lib/rpm", typeflag: tar.TypeSymlink, linkname: "/usr/lib/sysimage/rpm"},
+			{name: "usr/lib/sysimage/rpm/Packages.db", content: db},
+		}},
+		{"file link", []testEntry{
+			{name: "var/lib/rpm/Packages.db", typeflag: tar.TypeSymlink, linkname: "../../../opt/rpm/Packages.db"},
+			{name: "opt/rpm/Packages.db", content: db},
+		}},
+		{"link in a lower layer", []testEntry{
+			{name: "usr/lib/sysimage/rpm/Packages.db", content: db},
+		}},
+	}
+
+	for _, test := range tests {
+		pkglist, err := ExtractRPMDB(context.Background(), testLayer(t, test.entries...))
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		if len(pkglist) != 35 {
+			t.Fatalf("want=35 packages, got=%d for %s", len(pkglist), test.name)
+		}
+	}
+}
+
+func TestExtractRPMDBPath(t *testing.T) {
+	db := testRPMDB(t)
+	loc := rpmdbLocationAt("/opt/app/db/")
+
+	tests := []struct {
+		name    string
+		entries []testEntry
+	}{
+		{"relocated", []testEntry{
+			{name: "opt/app/db/Packages.db", content: db},
+		}},
+		{"relocated through a link", []testEntry{
+			{name: "opt/app", typeflag: tar.TypeSymlink, linkname: "/srv/app"},
+			{name: "srv/app/db/Packages.db", content: db},
+		}},
+	}
+
+	for _, test := range tests {
+		pkglist, err := extractRPMDB(context.Background(), testLayer(t, test.entries...), loc, defaultExtractionLimits, "")
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.name, err)
+		}
+		if len(pkglist) != 35 {
+			t.Fatalf("want=35 packages, got=%d for %s", len(pkglist), test.name)
+		}
+	}
+
+	// the default location is not looked in once the database is relocated.
+	_, err := extractRPMDB(context.Background(), testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: db}), loc, defaultExtractionLimits, "")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want=%v, got=%v", os.ErrNotExist, err)
+	}
+}
+
+func TestRelativeLinkTarget(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		expected string
+	}{
+		{"var/lib/rpm", "../../usr/lib/sysimage/rpm", "../../usr/lib/sysimage/rpm"},
+		{"var/lib/rpm", "/usr/lib/sysimage/rpm", "../../usr/lib/sysimage/rpm"},
+		{"var/lib/rpm", "../../../../../etc", "../../etc"},
+		{"var/lib/rpm/Packages", "/../../etc/passwd", "../../../etc/passwd"},
+		{"var", "/", "."},
+	}
+
+	for _, test := range tests {
+		if actual := relativeLinkTarget(test.name, test.target); actual != test.expected {
+			t.Fatalf("want=%s, got=%s for %s -> %s", test.expected, actual, test.name, test.target)
+		}
+	}
+}
+
+func TestExtractRPMDBWhiteout(t *testing.T) {
+	layer := testLayer(t, testEntry{name: "var/lib/rpm/.wh.Packages.db"})
+
+	_, err := ExtractRPMDB(context.Background(), layer)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want=%v, got=%v", os.ErrNotExist, err)
+	}
+}
+
+// countingLayer counts the number of times the contents of a layer are read.
+type countingLayer struct {
+	v1.Layer
+	reads *int32
+}
+
+func (l countingLayer) Uncompressed() (io.ReadCloser, error) {
+	atomic.AddInt32(l.reads, 1)
+	return l.Layer.Uncompressed()
+}
+
+func TestRPMDBCache(t *testing.T) {
+	var reads int32
+	layer := countingLayer{testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}), &reads}
+	cache := NewRPMDBCache()
+
+	var wg sync.WaitGroup
+	lists := make([][]*rpmdb.PackageInfo, 8)
+	for i := range lists {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			pkglist, err := cache.extract(context.Background(), layer, defaultRPMDBLocation, defaultExtractionLimits, "")
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			lists[i] = pkglist
+		}(i)
+	}
+	wg.Wait()
+	if reads != 1 {
+		t.Fatalf("want=%v, got=%v reads", 1, reads)
+	}
+	for _, pkglist := range lists {
+		if len(pkglist) == 0 || len(pkglist) != len(lists[0]) {
+			t.Fatalf("want=%v, got=%v packages", len(lists[0]), len(pkglist))
+		}
+	}
+
+	// a relocated RPMDB in the same layer is a different entry.
+	if _, err := cache.extract(context.Background(), layer, rpmdbLocationAt("opt/rpm"), defaultExtractionLimits, ""); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("want=%v, got=%v", os.ErrNotExist, err)
+	}
+	if reads != 2 {
+		t.Fatalf("want=%v, got=%v reads", 2, reads)
+	}
+}
+
+func TestRPMDBCacheCanceled(t *testing.T) {
+	var reads int32
+	layer := countingLayer{testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}), &reads}
+	cache := NewRPMDBCache()
+
+	// the extraction of a canceled scan is not shared with the next.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := cache.extract(ctx, layer, defaultRPMDBLocation, defaultExtractionLimits, ""); !errors.Is(err, context.Canceled) {
+		t.Fatalf("want=%v, got=%v", context.Canceled, err)
+	}
+	if pkglist, err := cache.extract(context.Background(), layer, defaultRPMDBLocation, defaultExtractionLimits, ""); err != nil || len(pkglist) == 0 {
+		t.Fatalf("want=packages, got=%v: %v", pkglist, err)
+	}
+
+	// nor is one within different limits.
+	if _, err := cache.extract(context.Background(), layer, defaultRPMDBLocation, extractionLimits{file: 1024}, ""); !errors.Is(err, ErrExtractionLimit) {
+		t.Fatalf("want=%v, got=%v", ErrExtractionLimit, err)
+	}
+	if pkglist, err := cache.extract(context.Background(), layer, defaultRPMDBLocation, defaultExtractionLimits, ""); err != nil || len(pkglist) == 0 {
+		t.Fatalf("want=packages, got=%v: %v", pkglist, err)
+	}
+}
+
+func TestRPMDBCacheReadError(t *testing.T) {
+	var reads int32
+	layer := countingLayer{truncatedLayer(t), &reads}
+	cache := NewRPMDBCache()
+
+	for i := 0; i < 2; i++ {
+		if _, err := cache.extract(context.Background(), layer, defaultRPMDBLocation, defaultExtractionLimits, ""); !errors.Is(err, ErrLayerRead) {
+			t.Fatalf("want=%v, got=%v", ErrLayerRead, err)
+		}
+	}
+	// a layer that could not be read is read again.
+	if reads != 2 {
+		t.Fatalf("want=%v, got=%v reads", 2, reads)
+	}
+}
+
+// BenchmarkScanBatch scans a batch of images sharing the layer containing the
+// RPMDB, reporting the number of times that layer is read per batch.
+func BenchmarkScanBatch(b *testing.B) {
+	var reads int32
+	base := countingLayer{testLayer(b, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(b)}), &reads}
+	var images []v1.Image
+	for _, app := range []string{"a", "b", "c", "d"} {
+		images = append(images, testImage(b, base, testLayer(b, testEntry{name: "opt/" + app, content: app})))
+	}
+
+	for _, test := range []struct {
+		name  string
+		cache func() *RPMDBCache
+	}{
+		{"uncached", func() *RPMDBCache { return nil }},
+		{"per-scan", NewRPMDBCache},
+		{"shared", nil},
+	} {
+		b.Run(test.name, func(b *testing.B) {
+			atomic.StoreInt32(&reads, 0)
+			for i := 0; i < b.N; i++ {
+				shared := NewRPMDBCache()
+				for _, img := range images {
+					cache := shared
+					if test.cache != nil {
+						cache = test.cache()
+					}
+					if _, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithRPMDBCache(cache)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt32(&reads))/float64(b.N), "rpmdb-layer-reads/op")
+		})
+	}
+}
+
+func TestRemovesRPMDB(t *testing.T) {
+	usrmerge := symlinks{"var/lib/rpm": "usr/lib/sysimage/rpm"}
+	tests := []struct {
+		name     string
+		links    symlinks
+		changes  []Change
+		expected bool
+	}{
+		{"deleted", symlinks{}, []Change{{Path: "var/lib/rpm", Kind: ChangeDeleted}}, true},
+		{"parent deleted", symlinks{}, []Change{{Path: "var/lib", Kind: ChangeDeleted}}, true},
+		{"opaque", symlinks{}, []Change{{Path: "var/lib/rpm", Kind: ChangeOpaque}}, true},
+		{"opaque root", symlinks{}, []Change{{Path: ".", Kind: ChangeOpaque}}, true},
+		{"file deleted", symlinks{}, []Change{{Path: "var/lib/rpm/Packages", Kind: ChangeDeleted}}, false},
+		{"rewritten", symlinks{}, []Change{
+			{Path: "var/lib/rpm", Kind: ChangeOpaque},
+			{Path: "var/lib/rpm/rpmdb.sqlite", Kind: ChangeModified},
+		}, false},
+		{"symlink deleted", usrmerge, []Change{{Path: "var/lib/rpm", Kind: ChangeDeleted}}, false},
+		{"symlink target deleted", usrmerge, []Change{{Path: "usr/lib/sysimage", Kind: ChangeDeleted}}, true},
+	}
+
+	for _, test := range tests {
+		if actual := removesRPMDB(test.changes, test.links, defaultRPMDBLocation); actual != test.expected {
+			t.Fatalf("want=%v, got=%v for %s", test.expected, actual, test.name)
+		}
+	}
+}
+
+func TestFindRPMDBExtractionLimit(t *testing.T) {
+	layers := []v1.Layer{testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)})}
+
+	_, _, err := findRPMDB(context.Background(), layers, defaultRPMDBLocation, extractionLimits{file: 1024}, "", nil, false, nil)
+	if !errors.Is(err, ErrExtractionLimit) || !errors.Is(err, ErrLayerRead) {
+		t.Fatalf("want=%v, got=%v", ErrExtractionLimit, err)
+	}
+}
+
+func TestRPMDBRoot(t *testing.T) {
+	for dir, expected := range map[string]string{
+		"var/lib/rpm":                      "",
+		"opt/db":                           "",
+		"mnt/sysroot/var/lib/rpm":          "mnt/sysroot",
+		"mnt/sysroot/usr/lib/sysimage/rpm": "mnt/sysroot",
+	} {
+		if actual := rpmdbRoot(dir); actual != expected {
+			t.Fatalf("want=%q, got=%q for %s", expected, actual, dir)
+		}
+	}
+}
+
+// cancelingLayer cancels a scan as soon as its contents are read.
+type cancelingLayer struct {
+	v1.Layer
+	cancel context.CancelFunc
+}
+
+func (l cancelingLayer) Uncompressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	return cancelingReader{rc, l.cancel}, nil
+}
+
+type cancelingReader struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r cancelingReader) Read(p []byte) (int, error) {
+	r.cancel()
+	return r.ReadCloser.Read(p)
+}
+
+func TestScanImageCanceledDuringLayerRead(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	img := testImage(t,
+		cancelingLayer{testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}), cancel},
+		testLayer(t, testEntry{name: "usr/bin/bash", content: "modified"}),
+	)
+
+	_, err := ScanImage(ctx, img, WithOutput(io.Discard))
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("want=%v, got=%v", context.Canceled, err)
+	}
+	// the directory the RPMDB was being extracted to is removed.
+	entries, err := os.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("want=%v, got=%v", 0, len(entries))
+	}
+}
@@ -0,0 +1,1166 @@
+// Package scan searches an image's layers for the first layer containing an
+// RPM, dpkg, or apk database, builds a list of files installed, then checks
+// subsequent layers for modifications to those files.
+package scan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+// Package managers whose databases can be used as the baseline of a scan.
+const (
+	PackageManagerRPM  = "rpm"
+	PackageManagerDpkg = "dpkg"
+	PackageManagerApk  = "apk"
+)
+
+// Selections of the layer containing an RPMDB to use as the baseline of a
+// scan when more than one layer contains one.
+const (
+	// RPMDBSelectionFirst uses the first layer, so that every later change to
+	// an installed file is reported, including those made by rpm itself.
+	RPMDBSelectionFirst = "first"
+	// RPMDBSelectionLast uses the last layer, whose database describes the
+	// packages installed in the final image. This is usually more correct, as
+	// packages installed or upgraded by later layers are part of the baseline
+	// rather than being reported as modifications.
+	RPMDBSelectionLast = "last"
+)
+
+// Report is the result of a scan.
+type Report struct {
+	// ImageDigest is the digest of the manifest of the image that was
+	// scanned, which, for an image index, is that of the selected platform.
+	ImageDigest string `json:"imageDigest"`
+	// PackageManager is the package manager whose database was found.
+	PackageManager string `json:"packageManager"`
+	// LayerCount is the number of layers in the image.
+	LayerCount int `json:"layerCount"`
+	// RPMDBLayerIndex is the index of the layer that contained the package
+	// database.
+	RPMDBLayerIndex int `json:"rpmdbLayerIndex"`
+	// RPMDBLayerDigest is the digest of the layer that contained the package
+	// database.
+	RPMDBLayerDigest string `json:"rpmdbLayerDigest"`
+	// Metadata describes the image that was scanned, from its manifest and
+	// config.
+	Metadata Metadata `json:"metadata"`
+	// Coverage records which layers were checked, and against how many
+	// package-owned files.
+	Coverage Coverage `json:"coverage"`
+	// FileMap maps each package-owned file to the package that owns it.
+	FileMap map[string]string `json:"filemap"`
+	// Layers holds the files changed by each layer following the RPMDB layer,
+	// preceded by the RPMDB layer itself if it was checked.
+	Layers []LayerResult `json:"layers"`
+	// DisallowedModifications maps each file with a disallowed modification
+	// to the digests of every layer that made one, in order, so that a file
+	// modified again by a later layer keeps the history of its modifications.
+	DisallowedModifications map[string][]string `json:"disallowedModifications"`
+	// Advisories are the modifications to config files that were allowed
+	// because of their file flags, if they were requested. They do not fail
+	// the scan.
+	Advisories []Modification `json:"advisories,omitempty"`
+	// NoModifiablePossible is true if the package database was in the last
+	// layer, so that no layer was checked because none could have modified
+	// the installed files. This distinguishes passing because nothing could
+	// be modified from passing because nothing was.
+	NoModifiablePossible bool `json:"noModifiablePossible"`
+	// SkippedLayers is the number of layers following the package database
+	// that were neither read nor checked, as only the top layers were.
+	SkippedLayers int `json:"skippedLayers,omitempty"`
+	// EmptyPackageDB is true if the package database lists no packages, so
+	// that no layer was checked, which WithEmptyPackageDB must allow.
+	EmptyPackageDB bool `json:"emptyPackageDB,omitempty"`
+	// RPMDBRemovedBy is the digest of the layer that removed the RPMDB, if a
+	// layer did and no later layer wrote a new copy of it. The packages it
+	// lists may then no longer describe the files in the image.
+	RPMDBRemovedBy string `json:"rpmdbRemovedBy,omitempty"`
+	// SuspiciousEntries are the entries of the layers checked whose names
+	// have a ".." element, in order, if WithStrictNormalization was given.
+	// They fail the scan whether or not they modified a package-owned file.
+	SuspiciousEntries []SuspiciousEntry `json:"suspiciousEntries,omitempty"`
+}
+
+// SuspiciousEntry is a tar entry of a layer whose name has a ".." element.
+type SuspiciousEntry struct {
+	// Layer is the digest of the layer containing the entry.
+	Layer string `json:"layer"`
+	// Name is the name of the entry as written in the layer.
+	Name string `json:"name"`
+}
+
+// LayerResult holds the files changed by a single layer.
+type LayerResult struct {
+	Digest string `json:"digest"`
+	// CreatedBy is the command that created the layer, such as the RUN
+	// instruction of a Dockerfile, if the history of the image records it.
+	CreatedBy string `json:"createdBy,omitempty"`
+	// Changes are the changes made by the layer, with any written over a
+	// package-owned file reported as ChangeModified.
+	Changes []Change `json:"changes"`
+	// Disallowed are the changes made by the layer to package-owned files
+	// that were not allowed, including the files removed by an opaque
+	// whiteout.
+	Disallowed []Change `json:"disallowed,omitempty"`
+	// UpdatedPackages maps the baseline name-version-release of each RPM
+	// whose version was changed by the layer to the name-version-release it
+	// installed, or to an empty string if the layer removed the package.
+	UpdatedPackages map[string]string `json:"updatedPackages,omitempty"`
+	// RemovedRPMDB is true if the layer removed the RPMDB without writing a
+	// new copy of it.
+	RemovedRPMDB bool `json:"removedRPMDB,omitempty"`
+}
+
+// Modification is a single disallowed modification to a package-owned file.
+type Modification struct {
+	File    string `json:"file"`
+	Package string `json:"package"`
+	// Layer is the last layer that modified File.
+	Layer string `json:"layer"`
+	// Layers are the digests of every layer that made a disallowed
+	// modification to File, in order, the last of which is Layer.
+	Layers []string `json:"layers"`
+	// Kind is how the layer changed the file.
+	Kind ChangeKind `json:"kind"`
+	// PackageChanged is true if the layer also upgraded, downgraded, or
+	// removed Package, in which case the modification was likely made by the
+	// package manager rather than being tampering.
+	PackageChanged bool `json:"packageChanged"`
+	// UpdatedPackage is the name-version-release of the package installed by
+	// the layer in place of Package, if any.
+	UpdatedPackage string `json:"updatedPackage,omitempty"`
+	// CreatedBy is the command that created Layer, if known.
+	CreatedBy string `json:"createdBy,omitempty"`
+}
+
+// Modifications returns the disallowed modifications in r, sorted by file,
+// along with the package that owns each file.
+func (r *Report) Modifications() []Modification {
+	kinds := map[string]ChangeKind{}
+	updates := map[string]map[string]string{}
+	commands := map[string]string{}
+	for _, layer := range r.Layers {
+		for _, change := range layer.Disallowed {
+			kinds[change.Path] = change.Kind
+		}
+		updates[layer.Digest] = layer.UpdatedPackages
+		commands[layer.Digest] = layer.CreatedBy
+	}
+
+	mods := make([]Modification, 0, len(r.DisallowedModifications))
+	for file, layers := range r.DisallowedModifications {
+		kind, found := kinds[file]
+		if !found {
+			kind = ChangeModified
+		}
+		var layer string
+		if len(layers) > 0 {
+			layer = layers[len(layers)-1]
+		}
+		mod := Modification{File: file, Package: r.FileMap[file], Layer: layer, Layers: layers, Kind: kind, CreatedBy: commands[layer]}
+		mod.UpdatedPackage, mod.PackageChanged = updates[layer][mod.Package]
+		mods = append(mods, mod)
+	}
+	sort.Slice(mods, func(i, j int) bool { return mods[i].File < mods[j].File })
+	return mods
+}
+
+// Summary counts the results of a scan.
+type Summary struct {
+	// Layers is the number of layers in the image.
+	Layers int `json:"layers"`
+	// LayersWithModifications is the number of layers that made at least one
+	// disallowed modification.
+	LayersWithModifications int `json:"layersWithModifications"`
+	// DisallowedModifications is the number of files with a disallowed
+	// modification.
+	DisallowedModifications int `json:"disallowedModifications"`
+	// PackagesAffected is the number of distinct packages owning a file with
+	// a disallowed modification.
+	PackagesAffected int `json:"packagesAffected"`
+	// SuspiciousEntries is the number of layer entries whose names have a
+	// ".." element.
+	SuspiciousEntries int `json:"suspiciousEntries,omitempty"`
+	// Passed is true if there were no disallowed modifications and no
+	// suspicious entries.
+	Passed bool `json:"passed"`
+}
+
+// Summary returns the counts of the results in r.
+func (r *Report) Summary() Summary {
+	s := Summary{
+		Layers:                  r.LayerCount,
+		DisallowedModifications: len(r.DisallowedModifications),
+		SuspiciousEntries:       len(r.SuspiciousEntries),
+		Passed:                  len(r.DisallowedModifications) == 0 && len(r.SuspiciousEntries) == 0,
+	}
+	for _, layer := range r.Layers {
+		if len(layer.Disallowed) > 0 {
+			s.LayersWithModifications++
+		}
+	}
+	packages := map[string]struct{}{}
+	for file := range r.DisallowedModifications {
+		packages[r.FileMap[file]] = struct{}{}
+	}
+	s.PackagesAffected = len(packages)
+	return s
+}
+
+// PackageCount is the number of files owned by a package that have a
+// disallowed modification.
+type PackageCount struct {
+	Package       string `json:"package"`
+	Modifications int    `json:"modifications"`
+}
+
+// PackageCounts returns the number of disallowed modifications to the files
+// of each package in r, from most to fewest, and by package among packages
+// with the same number. This shows whether the modifications cluster around a
+// single package or are spread out.
+func (r *Report) PackageCounts() []PackageCount {
+	counts := map[string]int{}
+	for file := range r.DisallowedModifications {
+		counts[r.FileMap[file]]++
+	}
+	packages := make([]PackageCount, 0, len(counts))
+	for pkg, n := range counts {
+		packages = append(packages, PackageCount{Package: pkg, Modifications: n})
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Modifications != packages[j].Modifications {
+			return packages[i].Modifications > packages[j].Modifications
+		}
+		return packages[i].Package < packages[j].Package
+	})
+	return packages
+}
+
+// Scan pulls the image at ref and checks it for modifications to files
+// installed by its package manager. ref may instead refer to an image on the
+// local filesystem by prefixing a path with OCILayoutPrefix or
+// DockerArchivePrefix, and may use the transport prefixes of skopeo.
+//
+// A container filesystem prefixed with ContainerExportPrefix has no layers to
+// compare, so its package-owned files are instead checked against the digests
+// recorded in its RPMDB, as by WithInstallLayerCheck, and those missing from
+// it are reported as deleted.
+func Scan(ctx context.Context, ref string, opts ...Option) (*Report, error) {
+	o := newOptions(opts...)
+	if strings.HasPrefix(ref, ContainerExportPrefix) {
+		o.checkInstallLayer = true
+		o.containerExport = true
+	}
+
+	img, err := o.loadImage(ctx, ref)
+	if err != nil {
+		return nil, wrap(ErrImagePull, err)
+	}
+
+	return o.scanImage(ctx, img)
+}
+
+// ScanImage checks img for modifications to files installed by its package
+// manager.
+func ScanImage(ctx context.Context, img v1.Image, opts ...Option) (*Report, error) {
+	return newOptions(opts...).scanImage(ctx, img)
+}
+
+// InstalledFiles are the files installed in an image by its package manager.
+type InstalledFiles struct {
+	ImageDigest    string `json:"imageDigest"`
+	PackageManager string `json:"packageManager"`
+	// Layer is the digest of the layer containing the package database.
+	Layer string `json:"layer"`
+	// Files maps each installed file to the package that owns it.
+	Files map[string]string `json:"files"`
+	// Flagged maps the files with any RPM file flags to those flags, so that
+	// the files that are modifiable because of them are not checked when the
+	// files are used as a baseline.
+	Flagged map[string]rpmdb.FileFlags `json:"flagged,omitempty"`
+}
+
+// InstalledFileMap pulls the image at ref, as Scan does, and returns the files
+// installed by its package manager without checking any layer for
+// modifications. Files that are modifiable because of their RPM file flags are
+// included.
+func InstalledFileMap(ctx context.Context, ref string, opts ...Option) (*InstalledFiles, error) {
+	o := newOptions(opts...)
+
+	img, err := o.loadImage(ctx, ref)
+	if err != nil {
+		return nil, wrap(ErrImagePull, err)
+	}
+
+	return o.installedFiles(ctx, img)
+}
+
+func (o *options) installedFiles(ctx context.Context, img v1.Image) (*InstalledFiles, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, wrap(ErrImagePull, fmt.Errorf("getting image digest: %w", err))
+	}
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, wrap(ErrImagePull, fmt.Errorf("getting layers: %w", err))
+	}
+	layers = zstdLayers(layers)
+
+	// the owners of the modifiable files are only recorded for config
+	// advisories. The files with any flags are recorded, so that a scan using
+	// them as a baseline applies its own modifiable flags.
+	o.configAdvisories = true
+	o.modifiableFlags = -1
+	db, err := o.findPackageDB(ctx, layers)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.restrictPackages(db); err != nil {
+		return nil, err
+	}
+	layerDigest, _ := layers[db.layerIndex].Digest()
+	files := db.owners
+	if files == nil {
+		files = db.filemap
+	}
+	return &InstalledFiles{
+		ImageDigest:    digest.String(),
+		PackageManager: db.manager,
+		Layer:          layerDigest.String(),
+		Files:          files,
+		Flagged:        db.flagged,
+	}, nil
+}
+
+func (o *options) scanImage(ctx context.Context, img v1.Image) (*Report, error) {
+	digest, err := img.Digest()
+	if err != nil {
+		return nil, wrap(ErrImagePull, fmt.Errorf("getting image digest: %w", err))
+	}
+	o.log.Log("Scanning image", digest)
+
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, wrap(ErrImagePull, fmt.Errorf("getting layers: %w", err))
+	}
+	layers = zstdLayers(layers)
+	metadata, err := imageMetadata(img)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := o.findPackageDB(ctx, layers)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.profile != "" {
+		name := o.profile
+		if name == ProfileAuto {
+			name = profileForManager[db.manager]
+		}
+		profile, err := ProfileExclusions(name)
+		if err != nil {
+			return nil, err
+		}
+		o.log.Log("Using the", name, "exclusion profile")
+		o.exclusions = o.exclusions.Merge(profile)
+	}
+	pathPatterns, err := CompilePathPatterns(o.exclusions.Paths)
+	if err != nil {
+		return nil, err
+	}
+	o.pathPatterns = pathPatterns
+	o.directoryPatterns = CompileDirectoryPatterns(o.exclusions.Directories)
+	if err := o.compileIncludes(); err != nil {
+		return nil, err
+	}
+	if o.alwaysFailPatterns, err = CompilePathPatterns(o.alwaysFail); err != nil {
+		return nil, err
+	}
+	layerIndex, filemap := db.layerIndex, db.filemap
+	id, _ := layers[layerIndex].Digest()
+	o.log.Log("layer", id, "contained the", db.manager, "database")
+
+	report := &Report{
+		ImageDigest:             digest.String(),
+		PackageManager:          db.manager,
+		LayerCount:              len(layers),
+		RPMDBLayerIndex:         layerIndex,
+		RPMDBLayerDigest:        id.String(),
+		Metadata:                metadata,
+		Coverage:                newCoverage(layers, layerIndex),
+		DisallowedModifications: map[string][]string{},
+	}
+
+	// A database that lists no packages, because it was only initialized or
+	// is corrupt, gives nothing to check the layers against.
+	if db.empty() {
+		if !o.allowEmptyPackageDB {
+			return nil, fmt.Errorf("%w: the %s database in layer %s", ErrEmptyPackageDB, db.manager, id)
+		}
+		o.log.Log("The", db.manager, "database contained no packages, so no layer was checked")
+		report.EmptyPackageDB = true
+		for i := layerIndex + 1; i < len(layers); i++ {
+			report.Coverage.skip(i, "the package database lists no packages")
+		}
+		return report, nil
+	}
+	if err := o.restrictPackages(db); err != nil {
+		return nil, err
+	}
+	filemap = db.filemap
+	report.Coverage.BaselineFiles, report.Coverage.ModifiableFiles = len(filemap), len(db.flagged)
+
+	// The layer that contained the package database was the last layer, so
+	// there is nothing left that could modify its files.
+	checkInstallLayer := o.checkInstallLayer && db.digests != nil
+	if o.containerExport && !checkInstallLayer {
+		return nil, fmt.Errorf("%w: a container export can only be checked against the digests in an rpm database, found a %s database", ErrInvalidPackageDB, db.manager)
+	}
+	if layerIndex == len(layers)-1 && !checkInstallLayer {
+		report.NoModifiablePossible = true
+		return report, nil
+	}
+
+	if len(filemap) == 0 {
+		return nil, fmt.Errorf("%w: filemap was empty", ErrInvalidPackageDB)
+	}
+	report.FileMap = filemap
+
+	// The layers up to and including the package database are read as well
+	// so that the symlinks they create can be resolved in later layers. If
+	// only the top layers are checked, the layers below them are not read at
+	// all, other than the one containing the package database when it is
+	// checked.
+	first := layerIndex + 1
+	if o.topLayers > 0 && len(layers)-o.topLayers > first {
+		first = len(layers) - o.topLayers
+		report.SkippedLayers = first - layerIndex - 1
+		o.log.Log("Only checking the top", o.topLayers, "layers, skipping", report.SkippedLayers, "layers after the one that contained the", db.manager, "database")
+		for i := layerIndex + 1; i < first; i++ {
+			report.Coverage.skip(i, fmt.Sprintf("only the top %d layers were checked", o.topLayers))
+		}
+	}
+	var readLayers []v1.Layer
+	var readIndexes []int
+	for i, layer := range layers {
+		if first > layerIndex+1 && i < first && !(checkInstallLayer && i == layerIndex) {
+			continue
+		}
+		readLayers = append(readLayers, layer)
+		readIndexes = append(readIndexes, i)
+	}
+	read, readTraversals, err := generateChanges(ctx, readLayers, o.concurrency, digestAlgorithms(db.digests), newProgress(o.progress, o.progressInPlace, len(readLayers)))
+	if err != nil {
+		return nil, err
+	}
+	allChanges := make([][]Change, len(layers))
+	traversals := make([][]string, len(layers))
+	for j, i := range readIndexes {
+		allChanges[i], traversals[i] = read[j], readTraversals[j]
+	}
+	// commands is nil if the history of the image does not match its layers.
+	commands := layerCommands(img, len(layers))
+	createdBy := func(i int) string {
+		if commands == nil {
+			return ""
+		}
+		return commands[i]
+	}
+	links := symlinks{}
+	for _, layerChanges := range allChanges[:layerIndex+1] {
+		for _, change := range layerChanges {
+			links.apply(change)
+		}
+	}
+	if checkInstallLayer {
+		result := LayerResult{Digest: id.String(), CreatedBy: createdBy(layerIndex)}
+		o.flagTraversals(report, result.Digest, traversals[layerIndex])
+		report.Layers = append(report.Layers, o.checkInstalledContent(db, links, result, allChanges[layerIndex], report))
+		report.Coverage.check(layerIndex, allChanges[layerIndex])
+	}
+	remainingLayers := layers[first:]
+	changes := allChanges[first:]
+
+	// present tracks the package-owned paths that still exist as of each layer
+	// so that opaque whiteouts can be expanded to the files they remove.
+	present := make(map[string]struct{}, len(filemap))
+	for path := range filemap {
+		present[path] = struct{}{}
+	}
+	// dirs tracks the package-owned paths that are directories as of each
+	// layer, so that a directory replacing one is only reported once.
+	dirs := make(map[string]struct{}, len(db.dirs))
+	for dir := range db.dirs {
+		dirs[dir] = struct{}{}
+	}
+
+	for i, layer := range remainingLayers {
+		id, _ := layer.Digest()
+		o.log.Log("Checking layer for disallowed modifications", id)
+		result := LayerResult{Digest: id.String(), CreatedBy: createdBy(first + i)}
+		if result.CreatedBy != "" {
+			o.log.Log("\tcreated by", result.CreatedBy)
+		}
+		o.flagTraversals(report, result.Digest, traversals[first+i])
+
+		// A layer that writes the RPMDB may have upgraded or removed the
+		// packages whose files it modifies.
+		if db.packages != nil && writesRPMDB(changes[i], o.rpmdbLocation) {
+			pkglist, err := o.rpmdbCache.extract(ctx, layer, o.rpmdbLocation, o.extractionLimits, o.tempDir)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return nil, err
+			}
+			if err == nil {
+				result.UpdatedPackages = db.applySnapshot(pkglist)
+				if report.RPMDBRemovedBy != "" {
+					o.log.Log("\ta new rpmdb was written, the installed packages are taken from it")
+					report.RPMDBRemovedBy = ""
+				}
+			}
+		}
+		// The filemap still describes the files installed before a layer
+		// removed the RPMDB, but whether they are still installed cannot be
+		// known, so this is reported rather than failing the scan.
+		if db.packages != nil && removesRPMDB(changes[i], links, o.rpmdbLocation) {
+			o.log.Log("\tthe rpmdb was removed by this layer, so the packages it lists may no longer be installed")
+			result.RemovedRPMDB = true
+			report.RPMDBRemovedBy = result.Digest
+		}
+
+		// Paths written through a symlinked directory are resolved to the path
+		// they refer to before they are looked up in the filemap.
+		layerChanges := make([]Change, 0, len(changes[i]))
+		for _, change := range changes[i] {
+			if o.resolveSymlinks {
+				change.Path = links.canonical(change.Path)
+			}
+			links.apply(change)
+			layerChanges = append(layerChanges, change)
+		}
+
+		// Opaque whiteouts apply to the contents of lower layers, so they are
+		// expanded before any of the files written by this layer are restored.
+		for _, change := range layerChanges {
+			if change.Kind != ChangeOpaque {
+				continue
+			}
+			for _, removed := range opaqueRemovals(present, change.Path) {
+				delete(present, removed)
+				if o.allowed(db, removed) {
+					continue
+				}
+				o.log.Log("\t", removed, "was removed by an opaque whiteout of", change.Path)
+				o.disallow(report, &result, filemap[removed], Change{Path: removed, Kind: ChangeDeleted})
+			}
+		}
+		for _, change := range layerChanges {
+			if change.Kind == ChangeOpaque {
+				result.Changes = append(result.Changes, change)
+				continue
+			}
+			// A layer writes a directory entry for each directory it writes
+			// into, so only a directory in place of a package-owned file that
+			// is not one modifies it, while anything else written in place of
+			// a package-owned directory replaces it.
+			_, owned := filemap[change.Path]
+			_, dir := dirs[change.Path]
+			switch {
+			case change.Kind == ChangeDirectory && (!owned || dir):
+				continue
+			case change.Kind == ChangeDirectory:
+				dirs[change.Path] = struct{}{}
+			case dir && change.Kind != ChangeDeleted:
+				delete(dirs, change.Path)
+				change.Kind = ChangeReplacedDirectory
+			}
+			if owned {
+				if change.Kind == ChangeDeleted {
+					delete(present, change.Path)
+				} else {
+					if change.Kind == ChangeAdded {
+						change.Kind = ChangeModified
+					}
+					present[change.Path] = struct{}{}
+				}
+			}
+			result.Changes = append(result.Changes, change)
+
+			if o.unchanged(db, change) || o.symlinkAllowed(db, change) || o.allowed(db, change.Path) {
+				if advisory, found := o.configAdvisory(db, change, id.String()); found {
+					report.Advisories = append(report.Advisories, advisory)
+				}
+				continue
+			}
+			o.disallow(report, &result, filemap[change.Path], change)
+			if nvr, updated := result.UpdatedPackages[filemap[change.Path]]; updated {
+				if nvr == "" {
+					o.log.Log("\t", change.Path, "was changed by the removal of", filemap[change.Path])
+				} else {
+					o.log.Log("\t", change.Path, "was changed by an update of", filemap[change.Path], "to", nvr)
+				}
+			}
+			switch change.Kind {
+			case ChangeDeleted:
+				o.log.Log("\t", change.Path, "was deleted")
+			case ChangeSymlink:
+				o.log.Log("\t", change.Path, "was replaced by a symlink to", change.Linkname)
+			case ChangeDevice:
+				o.log.Log("\t", change.Path, "was replaced by a device node")
+			case ChangeFifo:
+				o.log.Log("\t", change.Path, "was replaced by a fifo")
+			case ChangeDirectory:
+				o.log.Log("\t", change.Path, "was replaced by a directory")
+			case ChangeReplacedDirectory:
+				o.log.Log("\t", change.Path, "was a directory, and was replaced by a file")
+			}
+		}
+		report.Layers = append(report.Layers, result)
+		report.Coverage.check(first+i, changes[i])
+		if len(result.Disallowed) > 0 {
+			o.log.Log(red("\tfound disallowed modification in layer"))
+		}
+	}
+
+	return report, nil
+}
+
+// packageDB is the package database found in an image.
+type packageDB struct {
+	// manager is the package manager that owns the database.
+	manager string
+	// layerIndex is the index of the layer containing the database.
+	layerIndex int
+	// filemap maps each package-owned file to the package that owns it.
+	filemap map[string]string
+	// dirs are the package-owned files that are directories.
+	dirs map[string]struct{}
+	// flagged maps the files omitted from filemap because of their RPM file
+	// flags to those flags.
+	flagged map[string]rpmdb.FileFlags
+	// owners maps every installed file, including the flagged ones, to the
+	// package that owns it. It is only populated when config advisories are
+	// requested.
+	owners map[string]string
+	// packages maps the name of each installed RPM to its name-version-release
+	// in the baseline, which is the owner recorded in filemap.
+	packages map[string]string
+	// digests maps each installed regular file to the digest of its content
+	// recorded in the RPMDB. It is only populated when digests are verified.
+	digests map[string]fileDigest
+	// versions maps the baseline name-version-release of each installed RPM
+	// to the name-version-release installed as of the layer being checked, or
+	// an empty string if the package has since been removed.
+	versions map[string]string
+}
+
+// empty reports whether the database lists no packages. The filemaps of dpkg
+// and apk databases are built from their packages, so those that list no files
+// are taken to list no packages.
+func (db *packageDB) empty() bool {
+	if db.packages != nil {
+		return len(db.packages) == 0
+	}
+	return len(db.filemap) == 0
+}
+
+// newRPMPackageDB builds the packageDB for the RPMDB in layer i.
+func newRPMPackageDB(i int, pkglist []*rpmdb.PackageInfo, filemap map[string]string, flagged map[string]rpmdb.FileFlags) *packageDB {
+	db := &packageDB{
+		manager:    PackageManagerRPM,
+		layerIndex: i,
+		filemap:    filemap,
+		flagged:    flagged,
+		packages:   make(map[string]string, len(pkglist)),
+		versions:   make(map[string]string, len(pkglist)),
+	}
+	for _, pkg := range pkglist {
+		db.packages[pkg.Name] = packageNVR(pkg)
+		db.versions[packageNVR(pkg)] = packageNVR(pkg)
+	}
+	return db
+}
+
+// merge adds the files of other, a database of the packages installed under
+// root, to those of db. A file db already lists, whether or not it was omitted
+// from the filemap because of its flags, keeps the package db has for it. The
+// packages of other are not added to those of db, so that they are not taken
+// as removed by a later copy of the RPMDB of db.
+func (db *packageDB) merge(other *packageDB, root string) {
+	claimed := func(file string) bool {
+		_, owned := db.filemap[file]
+		_, flagged := db.flagged[file]
+		return owned || flagged
+	}
+	for file, flags := range other.flagged {
+		if file = path.Join(root, file); !claimed(file) {
+			db.flagged[file] = flags
+		}
+	}
+	for file, owner := range other.filemap {
+		rooted := path.Join(root, file)
+		if claimed(rooted) {
+			continue
+		}
+		db.filemap[rooted] = owner
+		if _, dir := other.dirs[file]; dir {
+			db.dirs[rooted] = struct{}{}
+		}
+	}
+	if db.owners != nil {
+		for file, owner := range other.owners {
+			if file = path.Join(root, file); db.owners[file] == "" {
+				db.owners[file] = owner
+			}
+		}
+	}
+	if db.digests != nil {
+		for file, digest := range other.digests {
+			if file = path.Join(root, file); db.digests[file] == (fileDigest{}) {
+				db.digests[file] = digest
+			}
+		}
+	}
+}
+
+// applySnapshot updates the installed versions of the baseline packages to
+// those in pkglist, a later copy of the RPMDB, and returns the baseline
+// name-version-release of each package whose version changed mapped to its new
+// name-version-release, or to an empty string if it was removed.
+func (db *packageDB) applySnapshot(pkglist []*rpmdb.PackageInfo) map[string]string {
+	installed := make(map[string]string, len(pkglist))
+	for _, pkg := range pkglist {
+		installed[pkg.Name] = packageNVR(pkg)
+	}
+
+	updated := map[string]string{}
+	for name, owner := range db.packages {
+		if nvr := installed[name]; nvr != db.versions[owner] {
+			updated[owner] = nvr
+			db.versions[owner] = nvr
+		}
+	}
+	return updated
+}
+
+// findPackageDB locates the package database in layers, trying RPM, dpkg, and
+// then apk, unless a baseline was given. Which RPMDB is used when several
+// layers contain one depends on the rpmdb selection.
+func (o *options) findPackageDB(ctx context.Context, layers []v1.Layer) (*packageDB, error) {
+	if o.baseline != nil {
+		return o.baselineDB(layers)
+	}
+	i, packages, err := findRPMDB(ctx, layers, o.rpmdbLocation, o.extractionLimits, o.tempDir, o.rpmdbCache, o.rpmdbSelection == RPMDBSelectionLast, o.skippedRPMDB)
+	if err == nil {
+		db, err := o.rpmPackageDB(i, packages)
+		if err != nil {
+			return nil, err
+		}
+		for _, loc := range o.extraRPMDBLocations {
+			if err := o.mergeRPMDB(ctx, db, layers[:i+1], loc); err != nil {
+				return nil, err
+			}
+		}
+		return db, nil
+	}
+	if !errors.Is(err, ErrRPMDBNotFound) {
+		return nil, err
+	}
+
+	i, filemap, err := findDpkgDB(ctx, layers, o.extractionLimits)
+	if err == nil {
+		return &packageDB{manager: PackageManagerDpkg, layerIndex: i, filemap: filemap, dirs: parentDirs(filemap)}, nil
+	}
+	if !errors.Is(err, ErrDpkgDBNotFound) {
+		return nil, err
+	}
+
+	i, filemap, err = findApkDB(ctx, layers, o.extractionLimits)
+	if err == nil {
+		return &packageDB{manager: PackageManagerApk, layerIndex: i, filemap: filemap, dirs: parentDirs(filemap)}, nil
+	}
+	if !errors.Is(err, ErrApkDBNotFound) {
+		return nil, err
+	}
+
+	return nil, ErrNoPackageDB
+}
+
+// skippedRPMDB logs that layer i was skipped while looking for the RPMDB
+// because of err. A database that is present but cannot be parsed is logged
+// even without verbose logging, so that it is not mistaken for there being
+// none.
+func (o *options) skippedRPMDB(i int, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidPackageDB):
+		o.log.Log(yellow("Skipping layer"), i, "as its rpmdb is present but could not be parsed:", err)
+	case o.verbose:
+		o.log.Log("Skipping layer", i, "as its rpmdb could not be read:", err)
+	}
+}
+
+// rpmPackageDB builds the packageDB for packages, listed by the RPMDB in layer
+// i, with the filemaps and digests the options need.
+func (o *options) rpmPackageDB(i int, packages []*rpmdb.PackageInfo) (*packageDB, error) {
+	filemap, flagged, err := BuildFileMap(packages, FileMapOptions{ExcludeModifiable: true, ModifiableFlags: o.modifiableFlags})
+	if err != nil {
+		return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("couldn't extract a filemap from the package list: %w", err))
+	}
+	db := newRPMPackageDB(i, packages, filemap, flagged)
+	if db.dirs, err = installedDirs(packages); err != nil {
+		return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("couldn't extract the directories from the package list: %w", err))
+	}
+	if o.configAdvisories {
+		if db.owners, _, err = BuildFileMap(packages, FileMapOptions{}); err != nil {
+			return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("couldn't extract a filemap from the package list: %w", err))
+		}
+	}
+	if o.verifyDigests || o.checkInstallLayer {
+		if db.digests, err = installedFileDigests(packages); err != nil {
+			return nil, wrap(ErrInvalidPackageDB, fmt.Errorf("couldn't extract file digests from the package list: %w", err))
+		}
+	}
+	return db, nil
+}
+
+// mergeRPMDB merges the files of the packages listed by the RPMDB at loc, as of
+// the last of layers that contains it, into db. The RPMDB is skipped if none of
+// layers contains it.
+func (o *options) mergeRPMDB(ctx context.Context, db *packageDB, layers []v1.Layer, loc rpmdbLocation) error {
+	i, packages, err := findRPMDB(ctx, layers, loc, o.extractionLimits, o.tempDir, o.rpmdbCache, true, o.skippedRPMDB)
+	if errors.Is(err, ErrRPMDBNotFound) {
+		o.log.Log("No rpm database was found in", "/"+loc.dirs[0])
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	extra, err := o.rpmPackageDB(i, packages)
+	if err != nil {
+		return err
+	}
+	id, _ := layers[i].Digest()
+	o.log.Log("layer", id, "contained the rpm database in", "/"+loc.dirs[0], "listing", len(packages), "packages")
+	db.merge(extra, rpmdbRoot(loc.dirs[0]))
+	return nil
+}
+
+// allowed reports whether a layer may modify s, either because no package
+// owns it, or, unless it always fails, because it is not included, because it
+// is excluded, or because its package is allowed. With
+// verbose logging, the reason for the decision is logged.
+func (o *options) allowed(db *packageDB, s string) bool {
+	owner, found := db.filemap[s]
+	if !found {
+		if !o.verbose {
+			return true
+		}
+		if flags, flagged := db.flagged[s]; flagged {
+			o.log.Log("\t", yellow(s), "is considered modifiable because of its file flags", flags)
+		} else {
+			o.log.Log("\t", s, "is not in the filemap")
+		}
+		return true
+	}
+
+	if PathIsExcluded(s, o.alwaysFailPatterns) {
+		if o.verbose {
+			o.log.Log("\t", s, "is owned by", owner, "and its modification is", red("always disallowed"))
+		}
+		return false
+	}
+
+	if !o.included(s) {
+		if o.verbose {
+			o.log.Log("\t", s, "is owned by", owner, "but is not included")
+		}
+		return true
+	}
+
+	if o.excluded(s) || o.packageAllowed(s, owner) {
+		if o.verbose {
+			o.log.Log("\t", s, "is owned by", owner, "and its modification is", blue("allowed"))
+		}
+		return true
+	}
+
+	if o.verbose {
+		o.log.Log("\t", s, "is owned by", owner, "and its modification is", red("disallowed"))
+	}
+	return false
+}
+
+// disallow records change to a file owned by owner, made by the layer whose
+// result is being built, as a disallowed modification, passing it to the
+// modification handler, if any. The layer is added to the history of the
+// file's modifications unless it already modified it.
+func (o *options) disallow(report *Report, result *LayerResult, owner string, change Change) {
+	layers := report.DisallowedModifications[change.Path]
+	if len(layers) == 0 || layers[len(layers)-1] != result.Digest {
+		layers = append(layers, result.Digest)
+		report.DisallowedModifications[change.Path] = layers
+	}
+	result.Disallowed = append(result.Disallowed, change)
+	if o.onModification != nil {
+		mod := Modification{File: change.Path, Package: owner, Layer: result.Digest, Layers: append([]string(nil), layers...), Kind: change.Kind, CreatedBy: result.CreatedBy}
+		mod.UpdatedPackage, mod.PackageChanged = result.UpdatedPackages[owner]
+		o.onModification(mod)
+	}
+}
+
+// checkInstalledContent checks the changes made by the layer containing the
+// RPMDB, whose result is built from result, for package-owned files whose
+// content differs from the digest recorded for them, adding any that are not
+// allowed to report.
+func (o *options) checkInstalledContent(db *packageDB, links symlinks, result LayerResult, changes []Change, report *Report) LayerResult {
+	o.log.Log("Checking the layer that contained the", db.manager, "database for files modified after they were installed", result.Digest)
+	for _, change := range changes {
+		if change.Kind == ChangeDirectory {
+			// the layer installs the directories as well as the files.
+			continue
+		}
+		if o.resolveSymlinks {
+			change.Path = links.canonical(change.Path)
+		}
+		if _, found := db.filemap[change.Path]; found && change.Kind == ChangeAdded {
+			change.Kind = ChangeModified
+		}
+		result.Changes = append(result.Changes, change)
+
+		if matches, recorded := db.matchesRecorded(change); matches || !recorded || o.allowed(db, change.Path) {
+			continue
+		}
+		o.log.Log("\t", change.Path, "differs from the content installed by", db.filemap[change.Path])
+		o.disallow(report, &result, db.filemap[change.Path], change)
+	}
+	if o.containerExport {
+		o.checkMissingContent(db, result.Changes, report, &result)
+	}
+	if len(result.Disallowed) > 0 {
+		o.log.Log(red("\tfound disallowed modification in layer"))
+	}
+	return result
+}
+
+// checkMissingContent adds to report the package-owned files with a recorded
+// digest that are not among the changes made by the flat filesystem of a
+// container, as they have been removed from it, unless they are allowed.
+func (o *options) checkMissingContent(db *packageDB, changes []Change, report *Report, result *LayerResult) {
+	written := make(map[string]struct{}, len(changes))
+	for _, change := range changes {
+		written[change.Path] = struct{}{}
+	}
+	var missing []string
+	for path := range db.digests {
+		if _, found := written[path]; !found {
+			missing = append(missing, path)
+		}
+	}
+	sort.Strings(missing)
+	for _, path := range missing {
+		if _, owned := db.filemap[path]; !owned || o.allowed(db, path) {
+			continue
+		}
+		o.log.Log("\t", path, "is missing from the container")
+		o.disallow(report, result, db.filemap[path], Change{Path: path, Kind: ChangeDeleted})
+	}
+}
+
+// configAdvisory returns the advisory for change, made by the layer whose
+// digest is id, if advisories were requested and it changed a file that was
+// allowed to be modified because it is flagged as a config file.
+func (o *options) configAdvisory(db *packageDB, change Change, id string) (Modification, bool) {
+	flags, flagged := db.flagged[change.Path]
+	if !o.configAdvisories || !flagged || int32(flags)&rpmdb.RPMFILE_CONFIG == 0 {
+		return Modification{}, false
+	}
+	kind := change.Kind
+	if kind == ChangeAdded {
+		kind = ChangeModified
+	}
+	owner := db.owners[change.Path]
+	o.log.Log("\t", change.Path, "is a config file owned by", owner, "and its modification is", yellow("advisory"))
+	return Modification{File: change.Path, Package: owner, Layer: id, Kind: kind}, true
+}
+
+// matchesRecorded reports whether change wrote the same content to a
+// package-owned file as the digest recorded for it in the RPMDB, and whether
+// there was a recorded digest to compare against at all.
+func (db *packageDB) matchesRecorded(change Change) (matches, recorded bool) {
+	digest, found := db.digests[change.Path]
+	if _, owned := db.filemap[change.Path]; !owned || !found || change.Kind != ChangeModified || change.Linkname != "" {
+		return false, false
+	}
+	return change.Digests[digest.algorithm] == digest.hex, true
+}
+
+// unchanged reports whether change wrote the same content to a package-owned
+// file as was installed by its package, according to the digest recorded for
+// it in the RPMDB. It is always false unless digests are verified.
+func (o *options) unchanged(db *packageDB, change Change) bool {
+	if matches, _ := db.matchesRecorded(change); !o.verifyDigests || !matches {
+		return false
+	}
+	o.log.Log("\t", change.Path, "has the same content as installed by", db.filemap[change.Path])
+	return true
+}
+
+// symlinkAllowed reports whether change is a symlink written at a
+// package-owned path that is allowed because modified symlinks are, unless the
+// path is one whose modification is always disallowed.
+func (o *options) symlinkAllowed(db *packageDB, change Change) bool {
+	owner, found := db.filemap[change.Path]
+	if !o.allowModifiedSymlinks || !found || change.Kind != ChangeSymlink || PathIsExcluded(change.Path, o.alwaysFailPatterns) {
+		return false
+	}
+	if o.verbose {
+		o.log.Log("\t", change.Path, "is owned by", owner, "and was replaced by a symlink, which is", blue("allowed"))
+	}
+	return true
+}
+
+// flagTraversals records each of names, the entries of layer with a ".."
+// element, as suspicious in report, if normalization is strict.
+func (o *options) flagTraversals(report *Report, layer string, names []string) {
+	if !o.strictNormalization {
+		return
+	}
+	for _, name := range names {
+		o.log.Log("\t", red("suspicious entry"), name, "has a parent directory element")
+		report.SuspiciousEntries = append(report.SuspiciousEntries, SuspiciousEntry{Layer: layer, Name: name})
+	}
+}
+
+// compileIncludes splits the includeOnly patterns into directories and
+// compiled globs.
+func (o *options) compileIncludes() error {
+	var globs []string
+	for _, p := range o.includeOnly {
+		if strings.ContainsAny(p, `*?[\`) {
+			globs = append(globs, p)
+		} else {
+			o.includeDirs = append(o.includeDirs, p)
+		}
+	}
+	patterns, err := CompilePathPatterns(globs)
+	if err != nil {
+		return err
+	}
+	o.includePatterns = patterns
+	return nil
+}
+
+// included reports whether s is one of the files to be checked, which is any
+// file unless WithIncludeOnly was given.
+func (o *options) included(s string) bool {
+	if len(o.includeOnly) == 0 {
+		return true
+	}
+	for _, dir := range o.includeDirs {
+		if s == dir || strings.HasPrefix(s, dir+"/") {
+			return true
+		}
+	}
+	return PathIsExcluded(s, o.includePatterns)
+}
+
+// packageAllowed reports whether owner, the name and version of the package
+// that owns s as recorded in the filemap, is one of the allowed packages,
+// logging the package that matched.
+func (o *options) packageAllowed(s, owner string) bool {
+	if name, allowed := packageNamed(owner, o.allowedPackages); allowed {
+		o.log.Log("\t", s, "was excluded by", blue("package"), name, "exclusions")
+		return true
+	}
+	return false
+}
+
+// packageNamed returns the name in names of owner, the name and version of a
+// package as recorded in the filemap, if it is one of them. As both names and
+// versions may contain dashes, owner matches a name if it is followed by a dash
+// and a version starting with a digit, or by the underscore separating those
+// of a dpkg package, though the name of an RPM may contain an underscore.
+func packageNamed(owner string, names map[string]struct{}) (string, bool) {
+	if name, _, found := strings.Cut(owner, "_"); found {
+		if _, named := names[name]; named {
+			return name, true
+		}
+	}
+	for i := 0; i < len(owner)-1; i++ {
+		if owner[i] != '-' || owner[i+1] < '0' || owner[i+1] > '9' {
+			continue
+		}
+		if _, found := names[owner[:i]]; found {
+			return owner[:i], true
+		}
+	}
+	return "", false
+}
+
+// restrictPackages removes the files owned by any package other than the
+// onlyPackages from the filemaps of db, if any are given, so that only their
+// files are checked. It is an error if none of them own a file.
+func (o *options) restrictPackages(db *packageDB) error {
+	if len(o.onlyPackages) == 0 {
+		return nil
+	}
+	restrict := func(files map[string]string) map[string]string {
+		restricted := map[string]string{}
+		for file, owner := range files {
+			if _, found := packageNamed(owner, o.onlyPackages); found {
+				restricted[file] = owner
+			}
+		}
+		return restricted
+	}
+	db.filemap = restrict(db.filemap)
+	if db.owners != nil {
+		db.owners = restrict(db.owners)
+	}
+	if len(db.filemap) == 0 && len(db.owners) == 0 {
+		names := make([]string, 0, len(o.onlyPackages))
+		for name := range o.onlyPackages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("%w: none of the packages %s own a file in the %s database", ErrInvalidPackageDB, strings.Join(names, ", "), db.manager)
+	}
+	o.log.Log("Only checking the files owned by", len(o.onlyPackages), "packages")
+	return nil
+}
+
+// excluded checks s against the path, directory, and regular expression
+// exclusions, logging the exclusion that applied.
+func (o *options) excluded(s string) bool {
+	switch {
+	case PathIsExcluded(s, o.pathPatterns):
+		o.log.Log("\t", s, "was excluded by", blue("file"), "exclusions")
+		return true
+	case DirectoryIsExcludedBy(s, o.directoryPatterns):
+		o.log.Log("\t", s, "was excluded by", yellow("directory"), "exclusions")
+		return true
+	}
+	for _, re := range o.regexps {
+		if re.MatchString(s) {
+			o.log.Log("\t", s, "was excluded by", blue("regex"), re, "exclusions")
+			return true
+		}
+	}
+	return false
+}
+
+var red = lipgloss.NewStyle().Foreground(lipgloss.Color("#D21404")).Render
+var yellow = lipgloss.NewStyle().Foreground(lipgloss.Color("#D6B85A")).Render
+var blue = lipgloss.NewStyle().Foreground(lipgloss.Color("#0000FF")).Render
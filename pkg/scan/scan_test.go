@@ -0,0 +1,923 @@
+package scan
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	rpmdb "github.com/knqyf263/go-rpmdb/pkg"
+)
+
+func TestModifications(t *testing.T) {
+	report := &Report{
+		FileMap: map[string]string{
+			"usr/bin/foo": "foo-1.0-1",
+			"usr/bin/bar": "bar-2.0-1",
+		},
+		DisallowedModifications: map[string][]string{
+			"usr/bin/foo": {"sha256:a", "sha256:b"},
+			"usr/bin/bar": {"sha256:a"},
+		},
+		Layers: []LayerResult{
+			{Digest: "sha256:a", Disallowed: []Change{
+				{Path: "usr/bin/foo", Kind: ChangeModified},
+				{Path: "usr/bin/bar", Kind: ChangeModified},
+			}},
+			{Digest: "sha256:b", Disallowed: []Change{
+				{Path: "usr/bin/foo", Kind: ChangeDeleted},
+			}, UpdatedPackages: map[string]string{"foo-1.0-1": ""}},
+		},
+	}
+
+	expected := []Modification{
+		{File: "usr/bin/bar", Package: "bar-2.0-1", Layer: "sha256:a", Layers: []string{"sha256:a"}, Kind: ChangeModified},
+		{File: "usr/bin/foo", Package: "foo-1.0-1", Layer: "sha256:b", Layers: []string{"sha256:a", "sha256:b"}, Kind: ChangeDeleted, PackageChanged: true},
+	}
+	actual := report.Modifications()
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	report := &Report{
+		LayerCount: 4,
+		FileMap: map[string]string{
+			"usr/bin/foo": "foo-1.0-1",
+			"usr/bin/bar": "foo-1.0-1",
+			"usr/bin/baz": "baz-2.0-1",
+		},
+		DisallowedModifications: map[string][]string{
+			"usr/bin/foo": {"sha256:b"},
+			"usr/bin/bar": {"sha256:b"},
+			"usr/bin/baz": {"sha256:c"},
+		},
+		Layers: []LayerResult{
+			{Digest: "sha256:a"},
+			{Digest: "sha256:b", Disallowed: []Change{
+				{Path: "usr/bin/foo", Kind: ChangeModified},
+				{Path: "usr/bin/bar", Kind: ChangeModified},
+			}},
+			{Digest: "sha256:c", Disallowed: []Change{
+				{Path: "usr/bin/baz", Kind: ChangeDeleted},
+			}},
+		},
+	}
+
+	expected := Summary{Layers: 4, LayersWithModifications: 2, DisallowedModifications: 3, PackagesAffected: 2}
+	if actual := report.Summary(); actual != expected {
+		t.Fatalf("want=%+v, got=%+v", expected, actual)
+	}
+
+	expected = Summary{Layers: 1, Passed: true}
+	if actual := (&Report{LayerCount: 1}).Summary(); actual != expected {
+		t.Fatalf("want=%+v, got=%+v", expected, actual)
+	}
+}
+
+func TestPackageCounts(t *testing.T) {
+	report := &Report{
+		FileMap: map[string]string{
+			"usr/bin/foo":  "foo-1.0-1",
+			"usr/bin/foo2": "foo-1.0-1",
+			"usr/bin/baz":  "baz-2.0-1",
+			"usr/bin/bar":  "bar-3.0-1",
+		},
+		DisallowedModifications: map[string][]string{
+			"usr/bin/foo":  {"sha256:a"},
+			"usr/bin/foo2": {"sha256:b"},
+			"usr/bin/baz":  {"sha256:b"},
+			"usr/bin/bar":  {"sha256:b"},
+		},
+	}
+
+	expected := []PackageCount{
+		{Package: "foo-1.0-1", Modifications: 2},
+		{Package: "bar-3.0-1", Modifications: 1},
+		{Package: "baz-2.0-1", Modifications: 1},
+	}
+	if actual := report.PackageCounts(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%+v, got=%+v", expected, actual)
+	}
+}
+
+func TestScanImageNoModifiablePossible(t *testing.T) {
+	images := map[string]v1.Image{
+		"single layer": testImage(t,
+			testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		),
+		"trailing database layer": testImage(t,
+			testLayer(t, testEntry{name: "opt/app", content: "app"}),
+			testLayer(t,
+				testEntry{name: "bin/busybox", content: "busybox"},
+				testEntry{name: "lib/apk/db/installed", content: testApkInstalled},
+			),
+		),
+	}
+	for name, img := range images {
+		report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", name, err)
+		}
+		if !report.NoModifiablePossible || len(report.Layers) != 0 {
+			t.Fatalf("expected no layers to be checked for %s, got %+v", name, report)
+		}
+		if !report.Summary().Passed {
+			t.Fatalf("expected %s to pass", name)
+		}
+	}
+
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "opt/app", content: "app"}),
+	)
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.NoModifiablePossible {
+		t.Fatalf("expected a layer following the database to be checked")
+	}
+}
+
+func TestApplySnapshot(t *testing.T) {
+	db := newRPMPackageDB(0, []*rpmdb.PackageInfo{
+		{Name: "foo", Version: "1.0", Release: "1"},
+		{Name: "bar", Version: "2.0", Release: "1"},
+		{Name: "baz", Version: "3.0", Release: "1"},
+	}, nil, nil)
+
+	snapshots := []struct {
+		pkglist  []*rpmdb.PackageInfo
+		expected map[string]string
+	}{
+		{
+			[]*rpmdb.PackageInfo{
+				{Name: "foo", Version: "1.1", Release: "1"},
+				{Name: "bar", Version: "2.0", Release: "1"},
+				{Name: "baz", Version: "3.0", Release: "1"},
+				{Name: "new", Version: "1.0", Release: "1"},
+			},
+			map[string]string{"foo-1.0-1": "foo-1.1-1"},
+		},
+		{
+			[]*rpmdb.PackageInfo{
+				{Name: "foo", Version: "1.1", Release: "1"},
+				{Name: "bar", Version: "2.0", Release: "2"},
+			},
+			map[string]string{"bar-2.0-1": "bar-2.0-2", "baz-3.0-1": ""},
+		},
+	}
+
+	for _, snapshot := range snapshots {
+		actual := db.applySnapshot(snapshot.pkglist)
+		if !reflect.DeepEqual(actual, snapshot.expected) {
+			t.Fatalf("want=%v, got=%v", snapshot.expected, actual)
+		}
+	}
+}
+
+func TestScanImageRegexpExclusions(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "bin/busybox", content: "modified"},
+			testEntry{name: "lib/ld-musl-x86_64.so.1", content: "modified"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img,
+		WithOutput(io.Discard),
+		WithRegexpExclusions(regexp.MustCompile(`^lib/ld-musl-.*\.so\.1$`)),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, found := report.DisallowedModifications["bin/busybox"]; !found {
+		t.Fatalf("expected bin/busybox to be reported, got %v", report.DisallowedModifications)
+	}
+	if _, found := report.DisallowedModifications["lib/ld-musl-x86_64.so.1"]; found {
+		t.Fatalf("expected lib/ld-musl-x86_64.so.1 to be excluded, got %v", report.DisallowedModifications)
+	}
+}
+
+func TestScanImageModificationHandler(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+		testLayer(t,
+			testEntry{name: "bin/.wh.busybox"},
+			testEntry{name: "lib/", typeflag: tar.TypeDir},
+			testEntry{name: "lib/.wh..wh..opq"},
+		),
+	)
+
+	var mods []Modification
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithModificationHandler(func(mod Modification) {
+		mods = append(mods, mod)
+	}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	first, second := report.Layers[0].Digest, report.Layers[1].Digest
+	expected := []Modification{
+		{File: "bin/busybox", Package: "busybox-1.36.1-r5", Layer: first, Layers: []string{first}, Kind: ChangeModified},
+		{File: "lib/ld-musl-x86_64.so.1", Package: "musl-1.2.4-r2", Layer: second, Layers: []string{second}, Kind: ChangeDeleted},
+		{File: "lib/libc.musl-x86_64.so.1", Package: "musl-1.2.4-r2", Layer: second, Layers: []string{second}, Kind: ChangeDeleted},
+		{File: "bin/busybox", Package: "busybox-1.36.1-r5", Layer: second, Layers: []string{first, second}, Kind: ChangeDeleted},
+	}
+	if !reflect.DeepEqual(mods, expected) {
+		t.Fatalf("want=%v, got=%v", expected, mods)
+	}
+	if history := report.DisallowedModifications["bin/busybox"]; !reflect.DeepEqual(history, []string{first, second}) {
+		t.Fatalf("want=%v, got=%v", []string{first, second}, history)
+	}
+}
+
+func TestScanImageAllowedPackages(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "bin/busybox", content: "modified"},
+			testEntry{name: "lib/ld-musl-x86_64.so.1", content: "modified"},
+		),
+		testLayer(t,
+			testEntry{name: "etc/", typeflag: tar.TypeDir},
+			testEntry{name: "etc/.wh..wh..opq"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img,
+		WithOutput(io.Discard),
+		WithAllowedPackages("busybox", "musl-1.2.4"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string][]string{"lib/ld-musl-x86_64.so.1": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+}
+
+func TestScanImageIncludeOnly(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "bin/busybox", content: "modified"},
+			testEntry{name: "lib/ld-musl-x86_64.so.1", content: "modified"},
+			testEntry{name: "lib/libc.musl-x86_64.so.1", content: "modified"},
+			testEntry{name: "etc/securetty", content: "modified"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img,
+		WithOutput(io.Discard),
+		WithIncludeOnly("/bin", "lib/ld-*", "etc"),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	digest := report.Layers[0].Digest
+	expected := map[string][]string{
+		"bin/busybox":             {digest},
+		"lib/ld-musl-x86_64.so.1": {digest},
+	}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+}
+
+func TestScanImageSymlinkShadowsOwnedFile(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "opt/evil", content: "evil"},
+			testEntry{name: "bin/busybox", typeflag: tar.TypeSymlink, linkname: "/opt/evil"},
+			testEntry{name: "opt/busybox", typeflag: tar.TypeSymlink, linkname: "/bin/busybox"},
+		),
+	)
+
+	for _, resolve := range []bool{true, false} {
+		report, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithSymlinkResolution(resolve))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		expected := []Change{{Path: "bin/busybox", Kind: ChangeSymlink, Linkname: "/opt/evil"}}
+		if actual := report.Layers[0].Disallowed; !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("want=%v, got=%v", expected, actual)
+		}
+		if len(report.DisallowedModifications) != 1 {
+			t.Fatalf("expected only bin/busybox to be reported, got %v", report.DisallowedModifications)
+		}
+	}
+}
+
+func TestScanImageModifiedSymlinksAllowed(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "lib/libc.musl-x86_64.so.1", typeflag: tar.TypeSymlink, linkname: "ld-musl-x86_64.so.1"},
+			testEntry{name: "bin/busybox", typeflag: tar.TypeSymlink, linkname: "/opt/evil"},
+			testEntry{name: "lib/ld-musl-x86_64.so.1", content: "modified"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithModifiedSymlinksAllowed(true), WithAlwaysFailPaths("/bin/busybox"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{"bin/busybox": {report.Layers[0].Digest}, "lib/ld-musl-x86_64.so.1": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+}
+
+func TestScanImageStrictNormalization(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "usr/../lib/ld-musl-x86_64.so.1", content: "modified"},
+			testEntry{name: "opt/app/../escape", content: "escape"},
+			testEntry{name: "opt/..data", content: "not a traversal"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.SuspiciousEntries != nil {
+		t.Fatalf("want=no suspicious entries unless strict, got=%v", report.SuspiciousEntries)
+	}
+
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithStrictNormalization(true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	layer := report.Layers[0].Digest
+	expected := []SuspiciousEntry{{Layer: layer, Name: "usr/../lib/ld-musl-x86_64.so.1"}, {Layer: layer, Name: "opt/app/../escape"}}
+	if !reflect.DeepEqual(report.SuspiciousEntries, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.SuspiciousEntries)
+	}
+	// the entry is still checked against the path it resolves to.
+	if _, found := report.DisallowedModifications["lib/ld-musl-x86_64.so.1"]; !found {
+		t.Fatalf("want=%s disallowed, got=%v", "lib/ld-musl-x86_64.so.1", report.DisallowedModifications)
+	}
+	if s := report.Summary(); s.Passed || s.SuspiciousEntries != 2 {
+		t.Fatalf("want=a failing summary with 2 suspicious entries, got=%+v", s)
+	}
+}
+
+func TestScanImageDeviceNode(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "bin/busybox", typeflag: tar.TypeChar},
+			testEntry{name: "opt/pipe", typeflag: tar.TypeFifo},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []Modification{{File: "bin/busybox", Package: report.FileMap["bin/busybox"], Layer: report.Layers[0].Digest, Layers: []string{report.Layers[0].Digest}, Kind: ChangeDevice}}
+	if actual := report.Modifications(); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestScanImageOpaqueWhiteout(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "lib/", typeflag: tar.TypeDir},
+			testEntry{name: "lib/.wh..wh..opq"},
+			testEntry{name: "lib/unrelated.so", content: "new"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	digest := report.Layers[0].Digest
+	expected := map[string][]string{
+		"lib/ld-musl-x86_64.so.1":   {digest},
+		"lib/libc.musl-x86_64.so.1": {digest},
+	}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+}
+
+func TestScanImageWhiteouts(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "bin/.wh.busybox"},
+			testEntry{name: "lib/ld-musl-x86_64.so.1", content: "modified"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]ChangeKind{
+		"bin/busybox":             ChangeDeleted,
+		"lib/ld-musl-x86_64.so.1": ChangeModified,
+	}
+	actual := map[string]ChangeKind{}
+	for _, mod := range report.Modifications() {
+		actual[mod.File] = mod.Kind
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+
+	img = testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t, testEntry{name: ".wh.bin", typeflag: tar.TypeDir}),
+	)
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mods := report.Modifications(); len(mods) != 1 || mods[0].Kind != ChangeDeleted {
+		t.Fatalf("expected the bin directory whiteout to be a deletion, got %v", report.Modifications())
+	}
+}
+
+func TestScanImageVerbose(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "opt/app", content: "new"},
+			testEntry{name: "etc/securetty", content: "modified"},
+			testEntry{name: "bin/busybox", content: "modified"},
+		),
+	)
+
+	var out bytes.Buffer
+	if _, err := ScanImage(context.Background(), img, WithOutput(&out), WithVerbose(true)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, expected := range []string{
+		"opt/app is not in the filemap",
+		"etc/securetty was excluded by",
+		"etc/securetty is owned by busybox-1.36.1-r5 and its modification is",
+		"bin/busybox is owned by busybox-1.36.1-r5 and its modification is",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Fatalf("expected output to contain %q, got:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestScanImageSymlinkedDirectory(t *testing.T) {
+	installed := strings.ReplaceAll(testApkInstalled, "F:lib\n", "F:usr/lib\n")
+	img := testImage(t,
+		testLayer(t,
+			testEntry{name: "lib", typeflag: tar.TypeSymlink, linkname: "usr/lib"},
+			testEntry{name: "lib/apk/db/installed", content: installed},
+		),
+		testLayer(t, testEntry{name: "lib/ld-musl-x86_64.so.1", content: "modified"}),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	digest := report.Layers[0].Digest
+	expected := map[string][]string{"usr/lib/ld-musl-x86_64.so.1": {digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+
+	// without resolution, the file is taken to be at the path it was written.
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithSymlinkResolution(false))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.DisallowedModifications) != 0 {
+		t.Fatalf("expected no disallowed modifications, got %v", report.DisallowedModifications)
+	}
+}
+
+func TestScanImageInstallLayerCheck(t *testing.T) {
+	img := testImage(t,
+		testLayer(t,
+			testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)},
+			testEntry{name: "usr/bin/bash", content: "modified"},
+			testEntry{name: "opt/app", content: "new"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.Layers) != 0 || len(report.DisallowedModifications) != 0 {
+		t.Fatalf("expected the RPMDB layer not to be checked, got %v", report.Layers)
+	}
+
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithInstallLayerCheck(true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{"usr/bin/bash": {report.RPMDBLayerDigest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+	if len(report.Layers) != 1 || report.Layers[0].Digest != report.RPMDBLayerDigest {
+		t.Fatalf("expected only the RPMDB layer to be checked, got %v", report.Layers)
+	}
+}
+
+func TestScanImageConfigAdvisories(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		testLayer(t,
+			testEntry{name: "etc/motd", content: "welcome"},
+			testEntry{name: "usr/share/licenses/bash/COPYING", content: "modified"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.Advisories) != 0 {
+		t.Fatalf("expected no advisories unless requested, got %v", report.Advisories)
+	}
+
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithConfigAdvisories(true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Modification{{File: "etc/motd", Package: "sles-release-15.3-55.4.1", Layer: report.Layers[0].Digest, Kind: ChangeModified}}
+	if !reflect.DeepEqual(report.Advisories, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.Advisories)
+	}
+	if len(report.DisallowedModifications) != 0 {
+		t.Fatalf("expected advisories not to be disallowed, got %v", report.DisallowedModifications)
+	}
+}
+
+func TestScanImageRPMDBRemoved(t *testing.T) {
+	rpmdbLayer := testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)})
+	removal := testLayer(t,
+		testEntry{name: "var/lib/.wh.rpm"},
+		testEntry{name: "usr/bin/bash", content: "modified"},
+	)
+
+	report, err := ScanImage(context.Background(), testImage(t, rpmdbLayer, removal), WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.RPMDBRemovedBy != report.Layers[0].Digest || !report.Layers[0].RemovedRPMDB {
+		t.Fatalf("want=%v, got=%v", report.Layers[0].Digest, report.RPMDBRemovedBy)
+	}
+	// the modifications are still reported against the removed RPMDB.
+	if _, found := report.DisallowedModifications["usr/bin/bash"]; !found {
+		t.Fatalf("expected usr/bin/bash to be disallowed, got %v", report.DisallowedModifications)
+	}
+
+	// a later copy of the RPMDB describes the packages installed again.
+	report, err = ScanImage(context.Background(), testImage(t, rpmdbLayer, removal, rpmdbLayer), WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.RPMDBRemovedBy != "" {
+		t.Fatalf("want=%v, got=%v", "", report.RPMDBRemovedBy)
+	}
+}
+
+func TestScanImageEmptyPackageDB(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: ""}),
+		testLayer(t, testEntry{name: "bin/busybox", content: "modified"}),
+	)
+
+	_, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if !errors.Is(err, ErrEmptyPackageDB) {
+		t.Fatalf("want=%v, got=%v", ErrEmptyPackageDB, err)
+	}
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithEmptyPackageDB(true))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !report.EmptyPackageDB || report.PackageManager != PackageManagerApk || len(report.Layers) != 0 {
+		t.Fatalf("expected an empty apk database and no layers checked, got %+v", report)
+	}
+}
+
+func TestScanImageOnlyPackages(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "lib/apk/db/installed", content: testApkInstalled}),
+		testLayer(t,
+			testEntry{name: "bin/busybox", content: "modified"},
+			testEntry{name: "lib/ld-musl-x86_64.so.1", content: "modified"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithOnlyPackages("musl"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{"lib/ld-musl-x86_64.so.1": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+	for file, owner := range report.FileMap {
+		if owner != "musl-1.2.4-r2" {
+			t.Fatalf("expected only the files of musl in the filemap, got %s owned by %s", file, owner)
+		}
+	}
+
+	files, err := newOptions(WithOutput(io.Discard), WithOnlyPackages("busybox")).installedFiles(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, found := files.Files["lib/ld-musl-x86_64.so.1"]; found || files.Files["bin/busybox"] != "busybox-1.36.1-r5" {
+		t.Fatalf("expected only the files of busybox, got %v", files.Files)
+	}
+
+	_, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithOnlyPackages("openssl"))
+	if !errors.Is(err, ErrInvalidPackageDB) {
+		t.Fatalf("want=%v, got=%v", ErrInvalidPackageDB, err)
+	}
+}
+
+func TestScanImageTopLayers(t *testing.T) {
+	var reads int32
+	skipped := countingLayer{testLayer(t, testEntry{name: "usr/bin/bash", content: "modified"}), &reads}
+	top := testLayer(t, testEntry{name: "usr/bin/bash", content: "modified again"})
+	img := testImage(t,
+		testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		skipped,
+		top,
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard), WithTopLayers(1))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	topDigest, _ := top.Digest()
+	if len(report.Layers) != 1 || report.Layers[0].Digest != topDigest.String() || report.SkippedLayers != 1 {
+		t.Fatalf("want=only %s checked with 1 skipped, got=%d layers with %d skipped", topDigest, len(report.Layers), report.SkippedLayers)
+	}
+	if reads != 0 {
+		t.Fatalf("want=%v, got=%v reads of the skipped layer", 0, reads)
+	}
+
+	// more top layers than follow the package database checks them all.
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithTopLayers(5))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.Layers) != 2 || report.SkippedLayers != 0 {
+		t.Fatalf("want=2 layers with 0 skipped, got=%d layers with %d skipped", len(report.Layers), report.SkippedLayers)
+	}
+}
+
+func TestScanImageAlwaysFailPaths(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		testLayer(t,
+			testEntry{name: "usr/bin/bash", content: "modified"},
+			testEntry{name: "etc/motd", content: "modified"},
+		),
+	)
+	opts := []Option{
+		WithOutput(io.Discard),
+		WithExclusions(Exclusions{Directories: []string{"usr/bin", "etc"}}),
+		WithAllowedPackages("bash"),
+	}
+
+	report, err := ScanImage(context.Background(), img, opts...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.DisallowedModifications) != 0 {
+		t.Fatalf("want=%v, got=%v", map[string][]string{}, report.DisallowedModifications)
+	}
+
+	report, err = ScanImage(context.Background(), img, append(opts, WithAlwaysFailPaths("/usr/bin/ba*"))...)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{"usr/bin/bash": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+}
+
+func TestScanImageAdditionalRPMDB(t *testing.T) {
+	img := testImage(t,
+		testLayer(t,
+			testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)},
+			testEntry{name: "mnt/sysroot/var/lib/rpm/Packages.db", content: testRPMDB(t)},
+		),
+		testLayer(t, testEntry{name: "mnt/sysroot/usr/bin/bash", content: "modified"}),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(report.DisallowedModifications) != 0 {
+		t.Fatalf("want=%v, got=%v", map[string][]string{}, report.DisallowedModifications)
+	}
+
+	report, err = ScanImage(context.Background(), img, WithOutput(io.Discard), WithAdditionalRPMDBPaths("/mnt/sysroot/var/lib/rpm", "/opt/missing"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{"mnt/sysroot/usr/bin/bash": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+	for _, file := range []string{"usr/bin/bash", "mnt/sysroot/usr/bin/bash"} {
+		if owner := report.FileMap[file]; owner != "bash-4.4-19.6.1" {
+			t.Fatalf("want=%s, got=%s for %s", "bash-4.4-19.6.1", owner, file)
+		}
+	}
+}
+
+func TestScanImageReplacedDirectory(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		testLayer(t,
+			testEntry{name: "usr/", typeflag: tar.TypeDir},
+			testEntry{name: "usr/lib/pkgconfig", content: "no longer a directory"},
+			testEntry{name: "usr/bin/bash/", typeflag: tar.TypeDir},
+			testEntry{name: "usr/local/foo/", typeflag: tar.TypeDir},
+		),
+		testLayer(t,
+			testEntry{name: "usr/bin/bash/", typeflag: tar.TypeDir},
+			testEntry{name: "usr/bin/bash/foo", content: "foo"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Change{
+		{Path: "usr/lib/pkgconfig", Kind: ChangeReplacedDirectory},
+		{Path: "usr/bin/bash", Kind: ChangeDirectory},
+	}
+	if !reflect.DeepEqual(report.Layers[0].Disallowed, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.Layers[0].Disallowed)
+	}
+	// directories written over directories are not changes to them.
+	if !reflect.DeepEqual(report.Layers[0].Changes, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.Layers[0].Changes)
+	}
+	// the directory replacing bash is only reported by the layer that wrote it.
+	if len(report.Layers[1].Disallowed) != 0 {
+		t.Fatalf("want=%v, got=%v", []Change{}, report.Layers[1].Disallowed)
+	}
+}
+
+func TestParentDirs(t *testing.T) {
+	dirs := parentDirs(map[string]string{
+		"usr":               "base-files-12.4",
+		"usr/share":         "base-files-12.4",
+		"usr/share/doc":     "base-files-12.4",
+		"usr/share/doc/foo": "foo-1.0",
+		"usr/bin/bar":       "bar-1.0",
+	})
+	expected := map[string]struct{}{"usr": {}, "usr/share": {}, "usr/share/doc": {}}
+	if !reflect.DeepEqual(dirs, expected) {
+		t.Fatalf("want=%v, got=%v", expected, dirs)
+	}
+}
+
+func TestPackageDBMerge(t *testing.T) {
+	db := &packageDB{
+		filemap: map[string]string{"usr/bin/foo": "foo-1-1"},
+		flagged: map[string]rpmdb.FileFlags{"etc/foo.conf": rpmdb.FileFlags(rpmdb.RPMFILE_CONFIG)},
+	}
+	db.merge(&packageDB{
+		filemap: map[string]string{"usr/bin/foo": "bar-1-1", "etc/foo.conf": "bar-1-1", "usr/bin/bar": "bar-1-1"},
+		flagged: map[string]rpmdb.FileFlags{},
+	}, "")
+	expected := map[string]string{"usr/bin/foo": "foo-1-1", "usr/bin/bar": "bar-1-1"}
+	if !reflect.DeepEqual(db.filemap, expected) {
+		t.Fatalf("want=%v, got=%v", expected, db.filemap)
+	}
+}
+
+func TestInstalledFiles(t *testing.T) {
+	rpmdbLayer := testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)})
+	img := testImage(t,
+		testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"}),
+		rpmdbLayer,
+		testLayer(t, testEntry{name: "etc/motd", content: "welcome"}),
+	)
+
+	files, err := newOptions(WithOutput(io.Discard)).installedFiles(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	layerDigest, _ := rpmdbLayer.Digest()
+	if files.PackageManager != PackageManagerRPM || files.Layer != layerDigest.String() {
+		t.Fatalf("want=%s in %s, got=%s in %s", PackageManagerRPM, layerDigest, files.PackageManager, files.Layer)
+	}
+	// modifiable files are included along with the rest.
+	for file, expected := range map[string]string{
+		"usr/bin/bash":                    "bash-4.4-19.6.1",
+		"etc/motd":                        "sles-release-15.3-55.4.1",
+		"usr/share/licenses/bash/COPYING": "bash-4.4-19.6.1",
+	} {
+		if actual := files.Files[file]; actual != expected {
+			t.Fatalf("want=%s, got=%s for %s", expected, actual, file)
+		}
+	}
+
+	// the flags of every file are recorded, whatever the modifiable flags.
+	files, err = newOptions(WithOutput(io.Discard), WithModifiableFileFlags(int32(rpmdb.RPMFILE_CONFIG))).installedFiles(context.Background(), img)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if flags := files.Flagged["usr/share/licenses/bash/COPYING"]; int32(flags)&rpmdb.RPMFILE_LICENSE == 0 {
+		t.Fatalf("want=%v, got=%v", rpmdb.RPMFILE_LICENSE, flags)
+	}
+}
+
+func TestScanImageRPM(t *testing.T) {
+	img := testImage(t,
+		testLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		testLayer(t,
+			testEntry{name: "usr/bin/bash", content: "modified"},
+			testEntry{name: "usr/share/bash/helpfiles/.wh.alias"},
+			testEntry{name: "usr/lib/tmpfiles.d/.wh..wh..opq"},
+			testEntry{name: "usr/share/licenses/bash/COPYING", content: "modified"},
+			testEntry{name: "opt/app", content: "new"},
+		),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.PackageManager != PackageManagerRPM {
+		t.Fatalf("want=%s, got=%s", PackageManagerRPM, report.PackageManager)
+	}
+
+	expected := map[string]ChangeKind{
+		"usr/bin/bash":                   ChangeModified,
+		"usr/share/bash/helpfiles/alias": ChangeDeleted,
+		"usr/lib/tmpfiles.d/ca-certificates-mozilla-prebuilt.conf": ChangeDeleted,
+		"usr/lib/tmpfiles.d/fs-tmp.conf":                           ChangeDeleted,
+		"usr/lib/tmpfiles.d/fs-var-tmp.conf":                       ChangeDeleted,
+		"usr/lib/tmpfiles.d/fs-var.conf":                           ChangeDeleted,
+	}
+	actual := map[string]ChangeKind{}
+	for _, mod := range report.Modifications() {
+		actual[mod.File] = mod.Kind
+		if mod.File == "usr/bin/bash" && mod.Package != "bash-4.4-19.6.1" {
+			t.Fatalf("expected usr/bin/bash to be owned by bash-4.4-19.6.1, got %s", mod.Package)
+		}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("want=%v, got=%v", expected, actual)
+	}
+}
+
+func TestPackageNamed(t *testing.T) {
+	names := map[string]struct{}{"gcc-12-base": {}, "libstdc++": {}, "python3-typing_extensions": {}}
+	for owner, expected := range map[string]string{
+		"gcc-12-base_12.2.0-14":                 "gcc-12-base",
+		"gcc-12_12.2.0-14":                      "",
+		"libstdc++-11.3.1-4.el9":                "libstdc++",
+		"libstdc++-devel-11.3.1":                "",
+		"python3-typing_extensions-4.1.1-3.el9": "python3-typing_extensions",
+	} {
+		if actual, _ := packageNamed(owner, names); actual != expected {
+			t.Fatalf("want=%q, got=%q for %s", expected, actual, owner)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package scan
+
+import (
+	"path"
+	"strings"
+)
+
+// maxSymlinkHops bounds symlink resolution so that a symlink loop cannot
+// resolve forever, mirroring the kernel's ELOOP limit.
+const maxSymlinkHops = 40
+
+// symlinks tracks the symlinks in an image as its layers are applied, mapping
+// each to its target relative to the root of the image. It is used to resolve
+// paths written through a symlinked directory, e.g. lib/foo when lib is a
+// symlink to usr/lib as on usrmerge systems, to the path a package installed
+// them at.
+type symlinks map[string]string
+
+// apply records the effect of change on the symlinks in the image.
+func (l symlinks) apply(change Change) {
+	switch change.Kind {
+	case ChangeSymlink:
+		target := change.Linkname
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(change.Path), target)
+		}
+		l[change.Path] = strings.TrimPrefix(path.Join("/", target), "/")
+	case ChangeDeleted:
+		for link := range l {
+			if link == change.Path || strings.HasPrefix(link, change.Path+"/") {
+				delete(l, link)
+			}
+		}
+	case ChangeOpaque:
+		for link := range l {
+			if change.Path == "." || strings.HasPrefix(link, change.Path+"/") {
+				delete(l, link)
+			}
+		}
+	default:
+		// anything else written at the path of a symlink replaces it.
+		delete(l, change.Path)
+	}
+}
+
+// canonical resolves the symlinks among the parent directories of p. The last
+// element of p is not resolved, as writing to a symlink replaces the symlink
+// rather than its target.
+func (l symlinks) canonical(p string) string {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		resolved := false
+		parts := strings.Split(p, "/")
+		for i := 1; i < len(parts); i++ {
+			if target, found := l[strings.Join(parts[:i], "/")]; found {
+				p = strings.TrimPrefix(path.Join("/", target, strings.Join(parts[i:], "/")), "/")
+				resolved = true
+				break
+			}
+		}
+		if !resolved {
+			break
+		}
+	}
+	return p
+}
+
+// resolve is like canonical, but also resolves the last element of p, giving
+// the path that reading p refers to.
+func (l symlinks) resolve(p string) string {
+	for hops := 0; hops < maxSymlinkHops; hops++ {
+		p = l.canonical(p)
+		target, found := l[p]
+		if !found {
+			break
+		}
+		p = target
+	}
+	return p
+}
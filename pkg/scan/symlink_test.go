@@ -0,0 +1,37 @@
+package scan
+
+import "testing"
+
+func TestSymlinksCanonical(t *testing.T) {
+	links := symlinks{}
+	for _, change := range []Change{
+		{Path: "lib", Kind: ChangeSymlink, Linkname: "usr/lib"},
+		{Path: "bin", Kind: ChangeSymlink, Linkname: "/usr/bin"},
+		{Path: "usr/lib64", Kind: ChangeSymlink, Linkname: "../../../usr/lib"},
+		{Path: "opt/loop", Kind: ChangeSymlink, Linkname: "/opt/loop"},
+		{Path: "opt/gone", Kind: ChangeSymlink, Linkname: "/usr/share"},
+		{Path: "opt/gone", Kind: ChangeDeleted},
+	} {
+		links.apply(change)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"lib/foo.so", "usr/lib/foo.so"},
+		{"bin/bash", "usr/bin/bash"},
+		{"usr/lib64/sub/foo.so", "usr/lib/sub/foo.so"},
+		{"lib", "lib"},
+		{"libexec/foo", "libexec/foo"},
+		{"opt/gone/foo", "opt/gone/foo"},
+		{"opt/loop/foo", "opt/loop/foo"},
+	}
+
+	for _, test := range tests {
+		actual := links.canonical(test.input)
+		if actual != test.expected {
+			t.Fatalf("want=%s, got=%s for input %s", test.expected, actual, test.input)
+		}
+	}
+}
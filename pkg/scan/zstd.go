@@ -0,0 +1,76 @@
+package scan
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdMagic begins every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// zstdLayer decompresses the contents of a layer compressed with zstd, such as
+// one with the application/vnd.oci.image.layer.v1.tar+zstd media type. The
+// Uncompressed method of go-containerregistry only recognizes gzip, and returns
+// the contents of any other layer as they were compressed.
+type zstdLayer struct {
+	v1.Layer
+}
+
+// zstdLayers returns layers, each of which is decompressed if it was
+// compressed with zstd.
+func zstdLayers(layers []v1.Layer) []v1.Layer {
+	wrapped := make([]v1.Layer, len(layers))
+	for i, layer := range layers {
+		wrapped[i] = zstdLayer{layer}
+	}
+	return wrapped
+}
+
+// Uncompressed returns the contents of the layer, decompressing them if they
+// begin with a zstd frame.
+func (l zstdLayer) Uncompressed() (io.ReadCloser, error) {
+	rc, err := l.Layer.Uncompressed()
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(rc)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		rc.Close()
+		return nil, err
+	}
+	if !bytes.Equal(magic, zstdMagic) {
+		return readCloser{Reader: br, Closer: rc}, nil
+	}
+	// a single goroutine decodes the stream as it is read, rather than
+	// decoding ahead of the reader.
+	zr, err := zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		rc.Close()
+		return nil, err
+	}
+	return zstdReadCloser{Decoder: zr, rc: rc}, nil
+}
+
+// readCloser reads from Reader and closes Closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// zstdReadCloser reads the decompressed contents of rc, closing the decoder
+// along with rc.
+type zstdReadCloser struct {
+	*zstd.Decoder
+	rc io.ReadCloser
+}
+
+func (z zstdReadCloser) Close() error {
+	z.Decoder.Close()
+	return z.rc.Close()
+}
@@ -0,0 +1,102 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdBlob is a layer blob compressed with zstd, as pulled from a registry.
+type zstdBlob []byte
+
+func (b zstdBlob) Digest() (v1.Hash, error) {
+	sum := sha256.Sum256(b)
+	return v1.Hash{Algorithm: "sha256", Hex: hex.EncodeToString(sum[:])}, nil
+}
+
+func (b zstdBlob) Compressed() (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
+func (b zstdBlob) Size() (int64, error) {
+	return int64(len(b)), nil
+}
+
+func (b zstdBlob) MediaType() (types.MediaType, error) {
+	return "application/vnd.oci.image.layer.v1.tar+zstd", nil
+}
+
+// testZstdLayer builds a zstd-compressed layer containing entries, in order.
+func testZstdLayer(t *testing.T, entries ...testEntry) v1.Layer {
+	t.Helper()
+
+	rc, err := testLayer(t, entries...).Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(zw, rc); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	layer, err := partial.CompressedToLayer(zstdBlob(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestGenerateChangesForZstdLayer(t *testing.T) {
+	layer := zstdLayer{testZstdLayer(t, testEntry{name: "usr/bin/foo", content: "foo"})}
+
+	changes, err := GenerateChangesFor(context.Background(), layer)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []Change{{Path: "usr/bin/foo", Kind: ChangeAdded}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("want=%v, got=%v", expected, changes)
+	}
+
+	// a layer that is not compressed with zstd is read as it is.
+	changes, err = GenerateChangesFor(context.Background(), zstdLayer{testLayer(t, testEntry{name: "usr/bin/foo", content: "foo"})})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("want=%v, got=%v", expected, changes)
+	}
+}
+
+func TestScanImageZstdLayers(t *testing.T) {
+	img := testImage(t,
+		testZstdLayer(t, testEntry{name: "var/lib/rpm/Packages.db", content: testRPMDB(t)}),
+		testZstdLayer(t, testEntry{name: "usr/bin/bash", content: "modified"}),
+	)
+
+	report, err := ScanImage(context.Background(), img, WithOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string][]string{"usr/bin/bash": {report.Layers[0].Digest}}
+	if !reflect.DeepEqual(report.DisallowedModifications, expected) {
+		t.Fatalf("want=%v, got=%v", expected, report.DisallowedModifications)
+	}
+}
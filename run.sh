@@ -11,7 +11,7 @@ for image in $(cat "${IMAGEFILE}"); do
     dirname="${prefix}-${normalizedImage}"
     mkdir "${dirname}"
     pushd "${dirname}" &>/dev/null
-    go run ../. "${image}"
+    go run ../. -fail-on none -output-dir . "${image}"
     popd &>/dev/null
     echo "--"
 done
\ No newline at end of file
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+const (
+	sarifVersion = "2.1.0"
+	sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifRuleID  = "disallowed-file-modification"
+)
+
+// The types below model the subset of SARIF 2.1.0 used by the sarif format.
+// https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool       sarifTool         `json:"tool"`
+	Results    []sarifResult     `json:"results"`
+	Properties map[string]string `json:"properties"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string      `json:"name"`
+	Version string      `json:"version,omitempty"`
+	Rules   []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID                   string             `json:"id"`
+	Name                 string             `json:"name"`
+	ShortDescription     sarifMessage       `json:"shortDescription"`
+	FullDescription      sarifMessage       `json:"fullDescription"`
+	DefaultConfiguration sarifConfiguration `json:"defaultConfiguration"`
+}
+
+type sarifConfiguration struct {
+	Level string `json:"level"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID     string            `json:"ruleId"`
+	RuleIndex  int               `json:"ruleIndex"`
+	Level      string            `json:"level"`
+	Message    sarifMessage      `json:"message"`
+	Locations  []sarifLocation   `json:"locations"`
+	Properties map[string]string `json:"properties"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// writeSARIF writes the result of scanning ref to w as a SARIF 2.1.0 log with
+// a result for each disallowed modification.
+func writeSARIF(w io.Writer, ref string, report *scan.Report) error {
+	results := []sarifResult{}
+	for _, mod := range report.Modifications() {
+		properties := map[string]string{
+			"package":     mod.Package,
+			"layer":       mod.Layer,
+			"kind":        string(mod.Kind),
+			"reference":   ref,
+			"imageDigest": report.ImageDigest,
+		}
+		if mod.CreatedBy != "" {
+			properties["createdBy"] = mod.CreatedBy
+		}
+		text := fmt.Sprintf("%s, owned by package %s, was %s in layer %s of %s", mod.File, mod.Package, describeKind(mod.Kind), mod.Layer, ref)
+		if len(mod.Layers) > 1 {
+			earlier := mod.Layers[:len(mod.Layers)-1]
+			properties["layers"] = strings.Join(mod.Layers, ",")
+			text += fmt.Sprintf(", after also being modified in layer %s", strings.Join(earlier, ", "))
+		}
+		results = append(results, sarifResult{
+			RuleID:    sarifRuleID,
+			RuleIndex: 0,
+			Level:     "error",
+			Message:   sarifMessage{Text: text},
+			Locations: []sarifLocation{{
+				PhysicalLocation: sarifPhysicalLocation{
+					ArtifactLocation: sarifArtifactLocation{URI: mod.File},
+				},
+			}},
+			Properties: properties,
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{{
+			Tool: sarifTool{
+				Driver: sarifDriver{
+					Name:    "hasmodifiedfiles",
+					Version: currentBuild().Version,
+					Rules: []sarifRule{{
+						ID:               sarifRuleID,
+						Name:             "DisallowedFileModification",
+						ShortDescription: sarifMessage{Text: "A file installed by a package was modified by a later layer"},
+						FullDescription: sarifMessage{
+							Text: "A layer following the layer containing the package database modified a file owned by an installed package, which is not permitted.",
+						},
+						DefaultConfiguration: sarifConfiguration{Level: "error"},
+					}},
+				},
+			},
+			Results: results,
+			Properties: map[string]string{
+				"reference":   ref,
+				"imageDigest": report.ImageDigest,
+			},
+		}},
+	})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestWriteSARIF(t *testing.T) {
+	report := &scan.Report{
+		ImageDigest:             "sha256:def",
+		FileMap:                 map[string]string{"usr/bin/foo": "foo-1.0-1"},
+		DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:aaa", "sha256:abc"}},
+	}
+
+	var buf bytes.Buffer
+	if err := writeSARIF(&buf, "quay.io/example/image:latest", report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &log); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if log.Version != sarifVersion || len(log.Runs) != 1 || len(log.Runs[0].Results) != 1 {
+		t.Fatalf("unexpected sarif log: %s", buf.String())
+	}
+	result := log.Runs[0].Results[0]
+	if result.RuleID != sarifRuleID {
+		t.Fatalf("want=%s, got=%s", sarifRuleID, result.RuleID)
+	}
+	if uri := result.Locations[0].PhysicalLocation.ArtifactLocation.URI; uri != "usr/bin/foo" {
+		t.Fatalf("want=%s, got=%s", "usr/bin/foo", uri)
+	}
+	if layer := result.Properties["layer"]; layer != "sha256:abc" {
+		t.Fatalf("want=%s, got=%s", "sha256:abc", layer)
+	}
+	if layers := result.Properties["layers"]; layers != "sha256:aaa,sha256:abc" || !strings.Contains(result.Message.Text, "also being modified in layer sha256:aaa") {
+		t.Fatalf("want=%s, got=%s: %s", "sha256:aaa,sha256:abc", layers, result.Message.Text)
+	}
+	if digest := log.Runs[0].Properties["imageDigest"]; digest != "sha256:def" {
+		t.Fatalf("want=%s, got=%s", "sha256:def", digest)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+const (
+	// maxScanRequestSize is the most bytes read from the body of a request to
+	// the /scan endpoint.
+	maxScanRequestSize = 1 << 20
+	// serveShutdownTimeout is how long the server waits for the scans in
+	// progress to complete once it is asked to stop.
+	serveShutdownTimeout = 30 * time.Second
+)
+
+// scanRequest is the body of a request to the /scan endpoint.
+type scanRequest struct {
+	Reference string `json:"reference"`
+}
+
+// serveError is the body of a response to a request that failed.
+type serveError struct {
+	Error string `json:"error"`
+}
+
+// scanServer scans the container references posted to its /scan endpoint,
+// responding with the report of each as -report-file would write it.
+type scanServer struct {
+	// scanRef scans the container reference ref.
+	scanRef func(ctx context.Context, ref string) (*scan.Report, error)
+	// slots holds a value for each scan in progress, so that no more scans
+	// than its capacity run at the same time.
+	slots chan struct{}
+	// metrics records the outcome of each scan, served at /metrics.
+	metrics *scanMetrics
+	logger  scan.Logger
+}
+
+// newScanServer returns a scanServer running up to maxScans scans at the
+// same time with scanRef, counting those whose report is failing as failed.
+func newScanServer(maxScans int, logger scan.Logger, failing func(*scan.Report) bool, scanRef func(ctx context.Context, ref string) (*scan.Report, error)) *scanServer {
+	return &scanServer{scanRef: scanRef, slots: make(chan struct{}, maxScans), metrics: newScanMetrics(failing), logger: logger}
+}
+
+// Handler returns the handler serving the endpoints of s.
+func (s *scanServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/scan", s.handleScan)
+	mux.Handle("/metrics", s.metrics)
+	return mux
+}
+
+func (s *scanServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleScan scans the reference in the body of a POST request. A reference
+// to an image on the local filesystem is rejected, as it would let a client
+// read any file the server can, and a request made while the server is
+// already running as many scans as it may is rejected rather than queued.
+func (s *scanServer) handleScan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeServeError(w, http.StatusMethodNotAllowed, errors.New("only POST is supported"))
+		return
+	}
+	var req scanRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxScanRequestSize)).Decode(&req); err != nil {
+		writeServeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	switch {
+	case req.Reference == "":
+		writeServeError(w, http.StatusBadRequest, errors.New("invalid request: no reference"))
+		return
+	case scan.IsLocalReference(req.Reference):
+		writeServeError(w, http.StatusBadRequest, fmt.Errorf("%w: only images in a registry can be scanned by the server, not %s", scan.ErrInvalidReference, req.Reference))
+		return
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	default:
+		w.Header().Set("Retry-After", "1")
+		writeServeError(w, http.StatusTooManyRequests, fmt.Errorf("%d scans are already in progress", cap(s.slots)))
+		return
+	}
+
+	start := time.Now()
+	report, err := s.scanRef(r.Context(), req.Reference)
+	s.metrics.observe(report, err, time.Since(start))
+	if err != nil {
+		s.logger.Log("ERR:", req.Reference+":", err)
+		writeServeError(w, httpStatus(err), err)
+		return
+	}
+	s.logger.Log(req.Reference+":", summaryLine(report.Summary()))
+	writeServeJSON(w, http.StatusOK, newReportFile(req.Reference, report, false))
+}
+
+// httpStatus maps err, from a scan, to the status of the response reporting
+// it, as exitCode maps it to an exit code.
+func httpStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, scan.ErrPlatformRequired), errors.Is(err, scan.ErrInvalidReference):
+		return http.StatusBadRequest
+	case errors.Is(err, scan.ErrImagePull):
+		return http.StatusBadGateway
+	case errors.Is(err, scan.ErrRPMDBNotFound), errors.Is(err, scan.ErrNoPackageDB), errors.Is(err, scan.ErrEmptyPackageDB), errors.Is(err, scan.ErrInvalidPackageDB):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeServeJSON writes v to w as the JSON body of a response with status.
+func writeServeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	enc.Encode(v)
+}
+
+// writeServeError writes err to w as the body of a response with status.
+func writeServeError(w http.ResponseWriter, status int, err error) {
+	writeServeJSON(w, status, serveError{Error: err.Error()})
+}
+
+// runServe serves s on addr until ctx is done, and then waits for the scans in
+// progress to complete, returning the exit code of the server.
+func runServe(ctx context.Context, addr string, s *scanServer) int {
+	srv := &http.Server{Addr: addr, Handler: s.Handler(), ReadHeaderTimeout: 10 * time.Second}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+	s.logger.Log("Serving scans on", addr)
+
+	select {
+	case err := <-errs:
+		fmt.Fprintln(os.Stderr, "ERR:", err)
+		return exitError
+	case <-ctx.Done():
+	}
+	s.logger.Log("Stopping, waiting up to", serveShutdownTimeout, "for the scans in progress")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		fmt.Fprintln(os.Stderr, "ERR:", err)
+		return exitError
+	}
+	return 0
+}
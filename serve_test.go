@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hasmodifiedfiles/pkg/scan"
+)
+
+func TestScanServer(t *testing.T) {
+	s := newScanServer(1, scan.NewTextLogger(io.Discard), failingReport(emptyPackageDBError), func(ctx context.Context, ref string) (*scan.Report, error) {
+		if ref == "quay.io/example/missing:latest" {
+			return nil, fmt.Errorf("%w: not found", scan.ErrImagePull)
+		}
+		return &scan.Report{ImageDigest: "sha256:def", DisallowedModifications: map[string][]string{"usr/bin/foo": {"sha256:abc"}}}, nil
+	})
+	srv := httptest.NewServer(s.Handler())
+	defer srv.Close()
+
+	post := func(body string) *http.Response {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/scan", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := post(`{"reference": "quay.io/example/image:latest"}`)
+	var result struct {
+		Reference               string              `json:"reference"`
+		ImageDigest             string              `json:"imageDigest"`
+		DisallowedModifications map[string][]string `json:"disallowedModifications"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("invalid json: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Reference != "quay.io/example/image:latest" || result.ImageDigest != "sha256:def" || len(result.DisallowedModifications) != 1 {
+		t.Fatalf("want=%v, got=%v %+v", http.StatusOK, resp.StatusCode, result)
+	}
+
+	for _, tc := range []struct {
+		body     string
+		expected int
+	}{
+		{`{"reference": "quay.io/example/missing:latest"}`, http.StatusBadGateway},
+		{`{"reference": "oci-layout:///etc"}`, http.StatusBadRequest},
+		{`{"reference": ""}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	} {
+		resp := post(tc.body)
+		var serveErr serveError
+		if err := json.NewDecoder(resp.Body).Decode(&serveErr); err != nil || serveErr.Error == "" {
+			t.Fatalf("want=an error, got=%+v %v for %s", serveErr, err, tc.body)
+		}
+		if resp.StatusCode != tc.expected {
+			t.Fatalf("want=%v, got=%v for %s", tc.expected, resp.StatusCode, tc.body)
+		}
+	}
+
+	// a scan is rejected while the server is running as many as it may.
+	s.slots <- struct{}{}
+	if resp := post(`{"reference": "quay.io/example/image:latest"}`); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("want=%v, got=%v", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	<-s.slots
+
+	resp, err := http.Get(srv.URL + "/scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("want=%v, got=%v", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+
+	resp, err = http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, expected := range []string{
+		`hasmodifiedfiles_scans_total{outcome="fail"} 1`,
+		`hasmodifiedfiles_scans_total{outcome="error"} 1`,
+		`hasmodifiedfiles_disallowed_modifications_total 1`,
+	} {
+		if !strings.Contains(string(b), expected+"\n") {
+			t.Fatalf("want=%s, got=%s", expected, b)
+		}
+	}
+
+	resp, err = http.Get(srv.URL + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if b, _ := io.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(b) != "ok\n" {
+		t.Fatalf("want=%v ok, got=%v %q", http.StatusOK, resp.StatusCode, b)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"os"
 )
 
+// mne panics if err is non-nil. It is reserved for internal invariants that
+// should never fail; expected errors should be reported with fail instead.
 func mne(err error, identifier string) {
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "ERR:"+identifier)
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+// Build metadata, set when building a release with e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Anything left unset falls back to what the go toolchain recorded in the
+// binary.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildInfo identifies the build of the tool that produced a report.
+type buildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+}
+
+// currentBuild returns the build metadata of the running binary.
+func currentBuild() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, Date: date}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if b.Version == "" {
+			b.Version = info.Main.Version
+		}
+		for _, setting := range info.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && b.Commit == "":
+				b.Commit = setting.Value
+			case setting.Key == "vcs.time" && b.Date == "":
+				b.Date = setting.Value
+			}
+		}
+	}
+	if b.Version == "" {
+		b.Version = "(devel)"
+	}
+	return b
+}
+
+func (b buildInfo) String() string {
+	s := "hasmodifiedfiles " + b.Version
+	if b.Commit != "" {
+		s += fmt.Sprintf(" commit %s", b.Commit)
+	}
+	if b.Date != "" {
+		s += fmt.Sprintf(" built %s", b.Date)
+	}
+	return s
+}
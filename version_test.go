@@ -0,0 +1,22 @@
+package main
+
+import "testing"
+
+func TestCurrentBuild(t *testing.T) {
+	defer func(v, c, d string) { version, commit, date = v, c, d }(version, commit, date)
+	version, commit, date = "v1.2.0", "abc123", "2022-11-01T00:00:00Z"
+
+	expected := buildInfo{Version: "v1.2.0", Commit: "abc123", Date: "2022-11-01T00:00:00Z"}
+	actual := currentBuild()
+	if actual != expected {
+		t.Fatalf("want=%+v, got=%+v", expected, actual)
+	}
+	if s := "hasmodifiedfiles v1.2.0 commit abc123 built 2022-11-01T00:00:00Z"; actual.String() != s {
+		t.Fatalf("want=%s, got=%s", s, actual.String())
+	}
+
+	version = ""
+	if actual := currentBuild(); actual.Version == "" {
+		t.Fatal("expected a version when none is set with -ldflags")
+	}
+}